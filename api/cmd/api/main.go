@@ -10,14 +10,19 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"time"
 
+	"matcha/api/internal/audio"
 	"matcha/api/internal/config"
 	"matcha/api/internal/database"
 	"matcha/api/internal/handlers"
+	"matcha/api/internal/jwtkeys"
 	"matcha/api/internal/middleware"
 	"matcha/api/internal/repository"
 	"matcha/api/internal/search"
@@ -47,6 +52,43 @@ func ping(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+func loadJWTKeys() (*jwtkeys.KeySet, error) {
+	dir := config.JWTKeysDir()
+	if dir == "" {
+		if !config.JWTAllowEphemeral() {
+			return nil, fmt.Errorf("JWT_KEYS_DIR is not set (set JWT_ALLOW_EPHEMERAL=true to use a throwaway development key)")
+		}
+		log.Printf("WARNING: JWT_ALLOW_EPHEMERAL is set, using an ephemeral key; tokens will not survive restarts or work across replicas")
+		return jwtkeys.Ephemeral()
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, fmt.Errorf("JWT_KEYS_DIR: %w", err)
+	}
+	return jwtkeys.LoadDir(dir, config.JWTActiveKID())
+}
+
+func newHub(ctx context.Context, events *repository.EventRepository) (*ws.Hub, error) {
+	var backend ws.Backend
+	switch config.HubBackend() {
+	case "local":
+		backend = ws.NewLocalBackend()
+	case "redis":
+		rb, err := ws.NewRedisBackend(config.RedisURL())
+		if err != nil {
+			return nil, err
+		}
+		backend = rb
+	default:
+		return nil, fmt.Errorf("unknown WS_HUB_BACKEND %q (want redis or local)", config.HubBackend())
+	}
+	hub := ws.NewHub(backend, events)
+	if err := hub.Start(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Websocket hub backend: %s", config.HubBackend())
+	return hub, nil
+}
+
 func main() {
 	ctx := context.Background()
 	pool, err := database.NewPool(ctx, config.DatabaseURL())
@@ -69,6 +111,10 @@ func main() {
 	blockRepo := repository.NewBlockRepository(pool)
 	presenceRepo := repository.NewPresenceRepository(pool)
 	photoRepo := repository.NewPhotoRepository(pool)
+	exportRepo := repository.NewExportRepository(pool)
+	eventRepo := repository.NewEventRepository(pool)
+	callRepo := repository.NewCallRepository(pool)
+	conversationRepo := repository.NewConversationRepository(pool)
 
 	tokenStore, err := store.NewTokenStore(config.RedisURL())
 	if err != nil {
@@ -77,6 +123,12 @@ func main() {
 	defer tokenStore.Close()
 	log.Println("Redis connected")
 
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+	log.Printf("JWT signing key: %s", jwtKeys.ActiveKID())
+
 	authSvc := services.NewAuthService(userRepo, tokenStore)
 	seedSvc := services.NewSeedService(userRepo, profileRepo, photoRepo)
 	mailer := services.NewMailer(
@@ -99,6 +151,9 @@ func main() {
 	if err := minioStore.EnsureBucket(ctx); err != nil {
 		log.Fatalf("minio bucket: %v", err)
 	}
+	if err := minioStore.ExpireObjects(ctx, services.ExportPrefix, services.ExportRetentionDays); err != nil {
+		log.Printf("minio lifecycle for exports: %v (continuing)", err)
+	}
 
 	// Elasticsearch
 	esCfg := elasticsearch.Config{Addresses: []string{config.ElasticsearchURL()}}
@@ -128,27 +183,51 @@ func main() {
 
 	discoveryRepo := repository.NewDiscoveryRepository(searchClient)
 
+	purgeSvc := services.NewAccountPurgeService(userRepo, messageRepo, minioStore, syncSvc)
+	go purgeSvc.Run(ctx, time.Hour)
+	go services.NewMessageReaper(messageRepo, minioStore).Run(ctx, time.Minute)
+	go services.NewEventLogTrimmer(eventRepo).Run(ctx, time.Hour)
+
+	wsHub, err := newHub(ctx, eventRepo)
+	if err != nil {
+		log.Fatalf("websocket hub: %v", err)
+	}
 	authH := handlers.NewAuthHandler(
 		authSvc,
 		syncSvc,
 		mailer,
 		tokenStore,
-		config.JWTSecret(),
+		wsHub,
+		jwtKeys,
 		config.PublicAPIBaseURL(),
 		config.FrontendBaseURL(),
 	)
-	wsHub := ws.NewHub()
 	apiBaseURL := config.PublicAPIBaseURL()
 	profileH := handlers.NewProfileHandler(profileRepo, photoRepo, discoveryRepo, syncSvc, minioStore, apiBaseURL)
 	discoveryH := handlers.NewDiscoveryHandler(userRepo, profileRepo, photoRepo, likeRepo, blockRepo, notificationRepo, discoveryRepo, syncSvc, wsHub, minioStore, apiBaseURL)
 	likesH := handlers.NewLikesHandler(likeRepo, userRepo, profileRepo, photoRepo, blockRepo, notificationRepo, mailer, syncSvc, wsHub, minioStore, apiBaseURL)
-	chatH := handlers.NewChatHandler(messageRepo, likeRepo, userRepo, blockRepo, notificationRepo, mailer, wsHub, minioStore)
+	var voiceProcessor *services.VoiceProcessor
+	if ffmpeg, err := audio.NewFFmpeg(config.FFmpegPath(), config.FFprobePath()); err != nil {
+		log.Printf("WARNING: ffmpeg unavailable (%v); voice messages are stored unprocessed", err)
+	} else {
+		voiceProcessor = services.NewVoiceProcessor(messageRepo, minioStore, ffmpeg, 2)
+	}
+	chatH := handlers.NewChatHandler(messageRepo, likeRepo, userRepo, blockRepo, notificationRepo, callRepo, mailer, wsHub, minioStore, voiceProcessor, config.MessageEditWindow())
+	go chatH.ResumeVoiceProcessing(ctx)
 	photoH := handlers.NewPhotoHandler(photoRepo, minioStore, apiBaseURL)
 	notificationsH := handlers.NewNotificationsHandler(notificationRepo, blockRepo)
 	reportsH := handlers.NewReportsHandler(reportRepo, userRepo, blockRepo)
 	blocksH := handlers.NewBlocksHandler(blockRepo, userRepo, profileRepo, photoRepo, apiBaseURL)
-	wsChatH := ws.NewChatHandler(wsHub, likeRepo, messageRepo, userRepo, blockRepo, notificationRepo, presenceRepo, mailer, config.JWTSecret())
+	conversationsH := handlers.NewConversationsHandler(conversationRepo, messageRepo, likeRepo, blockRepo, photoRepo, wsHub, apiBaseURL)
+	wsChatH := ws.NewChatHandler(wsHub, likeRepo, messageRepo, userRepo, blockRepo, notificationRepo, presenceRepo, callRepo, mailer, tokenStore, jwtKeys)
+	go wsChatH.RunCallTimeouts(ctx, 5*time.Second)
 	presenceH := handlers.NewPresenceHandler(presenceRepo, wsHub)
+	if len(config.TURNURLs()) > 0 && config.TURNSecret() == "" {
+		log.Printf("WARNING: TURN_URLS is set but TURN_SECRET is empty; calls will only get STUN servers")
+	}
+	iceH := handlers.NewICEHandler(config.STUNURLs(), config.TURNURLs(), config.TURNSecret(), config.TURNCredentialTTL())
+	exportSvc := services.NewExportService(exportRepo, userRepo, messageRepo, tokenStore, minioStore, mailer, jwtKeys, apiBaseURL)
+	exportH := handlers.NewExportHandler(exportSvc)
 
 	r := gin.Default()
 	// Allow localhost, 127.0.0.1, private IPs (192.168.x.x, 10.x.x.x), null, and CORS_ORIGIN
@@ -157,7 +236,7 @@ func main() {
 	privateIPRE := regexp.MustCompile(`^https?://(192\.168\.\d+\.\d+|10\.\d+\.\d+\.\d+)(:\d+)?$`)
 	r.Use(cors.New(cors.Config{
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Last-Event-ID"},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
 			if origin == "" || origin == "null" {
@@ -178,8 +257,12 @@ func main() {
 
 	r.GET("/health", health)
 	r.GET("/api/v1/ping", ping)
+	r.GET("/.well-known/jwks.json", authH.JWKS)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	authMw := middleware.Auth(config.JWTSecret())
+	if config.MetricsEnabled() {
+		r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
+	authMw := middleware.Auth(jwtKeys, tokenStore)
 	touchPresenceMw := middleware.TouchPresence(presenceRepo)
 
 	api := r.Group("/api/v1")
@@ -187,13 +270,27 @@ func main() {
 		api.GET("/photos/serve/:id", photoH.ServePhoto)
 		api.POST("/auth/register", authH.Register)
 		api.POST("/auth/login", authH.Login)
+		api.POST("/auth/refresh", authH.Refresh)
+		api.POST("/auth/2fa/verify", authH.VerifyMFA)
+		api.POST("/auth/magic-link", authH.RequestMagicLink)
+		api.GET("/auth/magic-link/verify", authH.ConsumeMagicLink)
 		api.GET("/auth/verify-email", authH.VerifyEmail)
+		api.GET("/auth/email-change/confirm", authH.ConfirmEmailChange)
+		api.GET("/auth/email-change/cancel", authH.CancelEmailChange)
 		api.POST("/auth/forgot-password", authH.ForgotPassword)
 		api.POST("/auth/reset-password", authH.ResetPassword)
 		api.POST("/auth/logout", authMw, touchPresenceMw, authH.Logout)
+		api.GET("/auth/sessions", authMw, touchPresenceMw, authH.ListSessions)
+		api.DELETE("/auth/sessions/:id", authMw, touchPresenceMw, authH.RevokeSession)
 		api.GET("/auth/me", authMw, touchPresenceMw, authH.Me)
 		api.PATCH("/auth/me", authMw, touchPresenceMw, authH.UpdateMe)
+		api.DELETE("/auth/me", authMw, authH.DeleteMe)
+		api.POST("/auth/me/export", authMw, touchPresenceMw, exportH.RequestExport)
+		api.GET("/exports/download", exportH.Download)
 		api.PATCH("/auth/me/password", authMw, touchPresenceMw, authH.ChangePassword)
+		api.POST("/auth/2fa/enroll", authMw, touchPresenceMw, authH.EnrollTOTP)
+		api.POST("/auth/2fa/confirm", authMw, touchPresenceMw, authH.ConfirmTOTP)
+		api.POST("/auth/2fa/disable", authMw, touchPresenceMw, authH.DisableTOTP)
 
 		profile := api.Group("/profile")
 		profile.Use(authMw, touchPresenceMw)
@@ -222,10 +319,18 @@ func main() {
 			users.DELETE("/:id/like", likesH.Unlike)
 			users.POST("/:id/messages", chatH.SendMessage)
 			users.POST("/:id/messages/voice", chatH.SendVoiceMessage)
+			users.POST("/:id/messages/image", chatH.SendImageMessage)
 			users.GET("/:id/messages", chatH.GetMessages)
 			users.PATCH("/:id/messages/read", chatH.MarkRead)
+			users.GET("/:id/calls", chatH.ListCalls)
 		}
 
+		api.GET("/messages/search", authMw, touchPresenceMw, chatH.SearchMessages)
+		api.PATCH("/messages/:id", authMw, touchPresenceMw, chatH.EditMessage)
+		api.DELETE("/messages/:id", authMw, touchPresenceMw, chatH.DeleteMessage)
+		api.POST("/messages/:id/reactions", authMw, touchPresenceMw, chatH.ToggleReaction)
+		api.GET("/messages/:id/media", authMw, chatH.ServeMessageMedia)
+
 		photos := api.Group("/photos")
 		photos.Use(authMw, touchPresenceMw)
 		{
@@ -239,11 +344,17 @@ func main() {
 		api.GET("/likes", authMw, touchPresenceMw, likesH.GetLikedByMe)
 		api.GET("/blocks", authMw, touchPresenceMw, blocksH.ListBlockedUsers)
 		api.GET("/matches", authMw, touchPresenceMw, likesH.GetMatches)
+		api.GET("/conversations", authMw, touchPresenceMw, conversationsH.List)
+		api.PATCH("/conversations/:id", authMw, touchPresenceMw, conversationsH.Update)
+		api.GET("/conversations/:id/retention", authMw, conversationsH.GetRetention)
+		api.PUT("/conversations/:id/retention", authMw, touchPresenceMw, conversationsH.SetRetention)
 		api.GET("/notifications", authMw, touchPresenceMw, notificationsH.List)
 		api.PATCH("/notifications/read-all", authMw, touchPresenceMw, notificationsH.MarkAllRead)
 		api.GET("/reports/me", authMw, touchPresenceMw, reportsH.ListMyReports)
 		api.GET("/presence/:id", authMw, touchPresenceMw, presenceH.Get)
+		api.GET("/calls/ice-servers", authMw, touchPresenceMw, iceH.GetICEServers)
 		api.GET("/ws/chat", wsChatH.Handle)
+		api.GET("/events/stream", authMw, wsChatH.Stream)
 	}
 
 	port := os.Getenv("API_PORT")
@@ -0,0 +1,162 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrNotAudio is returned for files that hold no audio stream ffmpeg can read.
+var ErrNotAudio = errors.New("file does not contain audio")
+
+// FFmpeg runs the ffmpeg and ffprobe binaries.
+type FFmpeg struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// NewFFmpeg resolves both binaries, so a missing install is reported at
+// startup rather than on the first upload.
+func NewFFmpeg(ffmpegPath, ffprobePath string) (*FFmpeg, error) {
+	ffmpeg, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return nil, err
+	}
+	ffprobe, err := exec.LookPath(ffprobePath)
+	if err != nil {
+		return nil, err
+	}
+	return &FFmpeg{ffmpeg: ffmpeg, ffprobe: ffprobe}, nil
+}
+
+// Probe checks that the file at path has at least one audio stream.
+func (f *FFmpeg) Probe(ctx context.Context, path string) error {
+	out, err := f.run(ctx, f.ffprobe,
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=codec_type",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotAudio, err)
+	}
+	ok, err := hasAudioStream(out)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotAudio
+	}
+	return nil
+}
+
+// CheckDecode decodes the first second of the audio at path and returns
+// ErrNotAudio if that fails; a file can probe fine and still be corrupt.
+func (f *FFmpeg) CheckDecode(ctx context.Context, path string) error {
+	_, err := f.run(ctx, f.ffmpeg,
+		"-nostdin", "-v", "error", "-xerror",
+		"-t", "1", "-i", path,
+		"-vn", "-f", "null", "-",
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotAudio, err)
+	}
+	return nil
+}
+
+// Transcode writes the audio of in to out as mono Opus in an Ogg container.
+// Video streams and metadata (e.g. recording device tags) are dropped.
+func (f *FFmpeg) Transcode(ctx context.Context, in, out string) error {
+	_, err := f.run(ctx, f.ffmpeg,
+		"-nostdin", "-v", "error", "-y",
+		"-i", in,
+		"-vn", "-map_metadata", "-1",
+		"-ac", "1", "-c:a", "libopus", "-b:a", "32k", "-application", "voip",
+		"-f", "ogg", out,
+	)
+	return err
+}
+
+// Analysis is what Analyze measures on a decoded file.
+type Analysis struct {
+	Duration time.Duration
+	Waveform []int
+}
+
+// Analyze decodes the file at path and returns its duration and a waveform
+// of the given number of bars. The duration comes from the decoded samples,
+// since browser recordings often carry no duration header.
+func (f *FFmpeg) Analyze(ctx context.Context, path string, bars int) (Analysis, error) {
+	cmd := exec.CommandContext(ctx, f.ffmpeg,
+		"-nostdin", "-v", "error",
+		"-i", path,
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(SampleRate),
+		"-f", "s16le", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Analysis{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return Analysis{}, err
+	}
+	peaks, samples, readErr := readPeaks(stdout)
+	if err := cmd.Wait(); err != nil {
+		return Analysis{}, commandError(err, &stderr)
+	}
+	if readErr != nil {
+		return Analysis{}, readErr
+	}
+	if samples == 0 {
+		return Analysis{}, ErrNotAudio
+	}
+	return Analysis{
+		Duration: time.Duration(samples) * time.Second / SampleRate,
+		Waveform: Waveform(peaks, bars),
+	}, nil
+}
+
+func (f *FFmpeg) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(err, &stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+// commandError keeps the first line ffmpeg printed, which names the problem.
+func commandError(err error, stderr *bytes.Buffer) error {
+	msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+	if msg == "" {
+		return err
+	}
+	return fmt.Errorf("%v: %s", err, msg)
+}
+
+func hasAudioStream(probeOutput []byte) (bool, error) {
+	var out struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(probeOutput, &out); err != nil {
+		return false, err
+	}
+	for _, s := range out.Streams {
+		if s.CodecType == "audio" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,85 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// SampleRate is the rate audio is decoded at for analysis. Speech needs no
+// more, and it keeps the decoded stream small.
+const SampleRate = 8000
+
+// blockSamples is how many samples readPeaks folds into one peak (10ms).
+const blockSamples = SampleRate / 100
+
+// readPeaks reads signed 16-bit little-endian mono PCM and returns the peak
+// amplitude of each block of blockSamples samples, along with the number of
+// samples read.
+func readPeaks(r io.Reader) ([]int, int, error) {
+	br := bufio.NewReader(r)
+	var (
+		peaks   []int
+		samples int
+		peak    int
+		buf     [2]byte
+	)
+	for {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, 0, err
+		}
+		v := int(int16(binary.LittleEndian.Uint16(buf[:])))
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+		samples++
+		if samples%blockSamples == 0 {
+			peaks = append(peaks, peak)
+			peak = 0
+		}
+	}
+	if samples%blockSamples != 0 {
+		peaks = append(peaks, peak)
+	}
+	return peaks, samples, nil
+}
+
+// Waveform downsamples peaks to n bars, each the loudest peak of its slice,
+// scaled 0-100 against the loudest bar. It returns nil when there are no
+// peaks.
+func Waveform(peaks []int, n int) []int {
+	if len(peaks) == 0 || n <= 0 {
+		return nil
+	}
+	bars := make([]int, n)
+	loudest := 0
+	for i := range bars {
+		start := i * len(peaks) / n
+		end := (i + 1) * len(peaks) / n
+		if end <= start {
+			end = start + 1
+		}
+		for _, p := range peaks[start:end] {
+			if p > bars[i] {
+				bars[i] = p
+			}
+		}
+		if bars[i] > loudest {
+			loudest = bars[i]
+		}
+	}
+	if loudest == 0 {
+		return bars
+	}
+	for i, b := range bars {
+		bars[i] = (b*100 + loudest/2) / loudest
+	}
+	return bars
+}
@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestReadPeaks(t *testing.T) {
+	samples := make([]int16, blockSamples*2+3)
+	samples[5] = -1200
+	samples[10] = 800
+	samples[blockSamples+1] = 300
+	samples[blockSamples*2+2] = -32768
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, samples); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte(0x7f) // a trailing half sample is ignored
+
+	peaks, n, err := readPeaks(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(samples) {
+		t.Errorf("samples = %d, want %d", n, len(samples))
+	}
+	if want := []int{1200, 300, 32768}; !reflect.DeepEqual(peaks, want) {
+		t.Errorf("peaks = %v, want %v", peaks, want)
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	tests := []struct {
+		name  string
+		peaks []int
+		n     int
+		want  []int
+	}{
+		{"empty", nil, 4, nil},
+		{"downsample", []int{10, 50, 0, 20, 100, 40, 5, 5}, 4, []int{50, 20, 100, 5}},
+		{"upsample", []int{100, 50}, 4, []int{100, 100, 50, 50}},
+		{"silence", []int{0, 0, 0}, 3, []int{0, 0, 0}},
+		{"rounding", []int{3, 1, 2}, 3, []int{100, 33, 67}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Waveform(tt.peaks, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Waveform(%v, %d) = %v, want %v", tt.peaks, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasAudioStream(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{`{"streams":[{"codec_type":"audio"}]}`, true},
+		{`{"streams":[{"codec_type":"video"},{"codec_type":"audio"}]}`, true},
+		{`{"streams":[]}`, false},
+		{`{}`, false},
+	}
+	for _, tt := range tests {
+		got, err := hasAudioStream([]byte(tt.out))
+		if err != nil {
+			t.Fatalf("hasAudioStream(%s): %v", tt.out, err)
+		}
+		if got != tt.want {
+			t.Errorf("hasAudioStream(%s) = %v, want %v", tt.out, got, tt.want)
+		}
+	}
+	if _, err := hasAudioStream([]byte("not json")); err == nil {
+		t.Error("hasAudioStream accepted invalid output")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func mustEnv(name string) string {
@@ -22,8 +23,89 @@ func RedisURL() string {
 	return mustEnv("REDIS_URL")
 }
 
-func JWTSecret() string {
-	return mustEnv("JWT_SECRET")
+// JWTKeysDir is the directory with the PEM keys used to sign access tokens.
+// Empty means an ephemeral development key.
+func JWTKeysDir() string {
+	return strings.TrimSpace(os.Getenv("JWT_KEYS_DIR"))
+}
+
+// JWTAllowEphemeral lets the API start without JWT_KEYS_DIR, signing with a
+// throwaway key. Only for local development: tokens die with the process and
+// replicas cannot verify each other's tokens.
+func JWTAllowEphemeral() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("JWT_ALLOW_EPHEMERAL")))
+	return v == "1" || v == "true"
+}
+
+func JWTActiveKID() string {
+	return strings.TrimSpace(os.Getenv("JWT_ACTIVE_KID"))
+}
+
+// HubBackend selects how websocket events reach other API replicas: "redis"
+// (default) fans out through Redis pub/sub, "local" keeps them in process.
+func HubBackend() string {
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("WS_HUB_BACKEND"))); v != "" {
+		return v
+	}
+	return "redis"
+}
+
+// MetricsEnabled exposes runtime counters (expvar) on /debug/vars. Off by
+// default since the endpoint is unauthenticated.
+func MetricsEnabled() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("METRICS_ENABLED")))
+	return v == "1" || v == "true"
+}
+
+// STUNURLs are handed to clients as ICE servers for calls.
+func STUNURLs() []string {
+	if v := strings.TrimSpace(os.Getenv("STUN_URLS")); v != "" {
+		return splitList(v)
+	}
+	return []string{"stun:stun.l.google.com:19302"}
+}
+
+// TURNURLs lists the TURN servers (e.g. turn:host:3478?transport=udp). No
+// TURN server is offered when it is empty or TURN_SECRET is unset.
+func TURNURLs() []string {
+	return splitList(os.Getenv("TURN_URLS"))
+}
+
+// TURNSecret is the shared secret configured in coturn as
+// static-auth-secret.
+func TURNSecret() string {
+	return strings.TrimSpace(os.Getenv("TURN_SECRET"))
+}
+
+// TURNCredentialTTL is how long issued TURN credentials stay valid.
+func TURNCredentialTTL() time.Duration {
+	if v := os.Getenv("TURN_CREDENTIAL_TTL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return time.Hour
+}
+
+// MessageEditWindow is how long after sending a message its sender may still
+// edit or unsend it.
+func MessageEditWindow() time.Duration {
+	if v := os.Getenv("MESSAGE_EDIT_WINDOW_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 15 * time.Minute
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
 }
 
 func CORSOrigin() string {
@@ -63,6 +145,22 @@ func MinIOPublicBaseURL() string {
 	return "http://localhost:9000"
 }
 
+// FFmpegPath and FFprobePath locate the binaries used to process voice
+// messages. Without them voice messages are stored as uploaded.
+func FFmpegPath() string {
+	if v := strings.TrimSpace(os.Getenv("FFMPEG_PATH")); v != "" {
+		return v
+	}
+	return "ffmpeg"
+}
+
+func FFprobePath() string {
+	if v := strings.TrimSpace(os.Getenv("FFPROBE_PATH")); v != "" {
+		return v
+	}
+	return "ffprobe"
+}
+
 func SMTPHost() string {
 	return mustEnv("SMTP_HOST")
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func expectPanicContains(t *testing.T, want string, fn func()) {
@@ -87,3 +88,79 @@ func TestSMTPCooldownSeconds(t *testing.T) {
 		t.Errorf("SMTPCooldownSeconds() invalid = %d, want default 30", got)
 	}
 }
+
+func TestHubBackend(t *testing.T) {
+	orig := os.Getenv("WS_HUB_BACKEND")
+	defer os.Setenv("WS_HUB_BACKEND", orig)
+
+	os.Unsetenv("WS_HUB_BACKEND")
+	if got := HubBackend(); got != "redis" {
+		t.Errorf("HubBackend() unset = %q, want default redis", got)
+	}
+
+	os.Setenv("WS_HUB_BACKEND", " Local ")
+	if got := HubBackend(); got != "local" {
+		t.Errorf("HubBackend() = %q, want local", got)
+	}
+}
+
+func TestMetricsEnabled(t *testing.T) {
+	orig := os.Getenv("METRICS_ENABLED")
+	defer os.Setenv("METRICS_ENABLED", orig)
+
+	os.Unsetenv("METRICS_ENABLED")
+	if MetricsEnabled() {
+		t.Error("MetricsEnabled() unset = true, want false")
+	}
+
+	os.Setenv("METRICS_ENABLED", " TRUE ")
+	if !MetricsEnabled() {
+		t.Error("MetricsEnabled() = false, want true")
+	}
+}
+
+func TestSTUNURLs(t *testing.T) {
+	orig := os.Getenv("STUN_URLS")
+	defer os.Setenv("STUN_URLS", orig)
+
+	os.Unsetenv("STUN_URLS")
+	if got := STUNURLs(); len(got) != 1 || got[0] != "stun:stun.l.google.com:19302" {
+		t.Errorf("STUNURLs() unset = %v, want public default", got)
+	}
+
+	os.Setenv("STUN_URLS", " stun:a:3478, ,stun:b:3478 ")
+	got := STUNURLs()
+	if len(got) != 2 || got[0] != "stun:a:3478" || got[1] != "stun:b:3478" {
+		t.Errorf("STUNURLs() = %v, want [stun:a:3478 stun:b:3478]", got)
+	}
+}
+
+func TestTURNCredentialTTL(t *testing.T) {
+	orig := os.Getenv("TURN_CREDENTIAL_TTL_SECONDS")
+	defer os.Setenv("TURN_CREDENTIAL_TTL_SECONDS", orig)
+
+	os.Setenv("TURN_CREDENTIAL_TTL_SECONDS", "600")
+	if got := TURNCredentialTTL(); got != 10*time.Minute {
+		t.Errorf("TURNCredentialTTL() = %v, want 10m", got)
+	}
+
+	os.Setenv("TURN_CREDENTIAL_TTL_SECONDS", "-5")
+	if got := TURNCredentialTTL(); got != time.Hour {
+		t.Errorf("TURNCredentialTTL() invalid = %v, want default 1h", got)
+	}
+}
+
+func TestMessageEditWindow(t *testing.T) {
+	orig := os.Getenv("MESSAGE_EDIT_WINDOW_SECONDS")
+	defer os.Setenv("MESSAGE_EDIT_WINDOW_SECONDS", orig)
+
+	os.Setenv("MESSAGE_EDIT_WINDOW_SECONDS", "300")
+	if got := MessageEditWindow(); got != 5*time.Minute {
+		t.Errorf("MessageEditWindow() = %v, want 5m", got)
+	}
+
+	os.Setenv("MESSAGE_EDIT_WINDOW_SECONDS", "0")
+	if got := MessageEditWindow(); got != 15*time.Minute {
+		t.Errorf("MessageEditWindow() invalid = %v, want default 15m", got)
+	}
+}
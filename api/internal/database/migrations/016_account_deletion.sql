@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_requested_at
+    ON users(deletion_requested_at)
+    WHERE deletion_requested_at IS NOT NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS calls (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    call_id VARCHAR(64) NOT NULL,
+    caller_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    callee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mode VARCHAR(8) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    answered_at TIMESTAMPTZ,
+    ended_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_calls_caller_callee_started
+ON calls(caller_id, callee_id, started_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_calls_callee_caller_started
+ON calls(callee_id, caller_id, started_at DESC);
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_messages_pair_created_id
+ON messages(sender_id, receiver_id, created_at DESC, id DESC);
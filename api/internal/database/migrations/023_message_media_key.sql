@@ -0,0 +1,6 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS media_key TEXT;
+
+-- Voice messages stored only their public URL; the key is its tail.
+UPDATE messages
+SET media_key = substring(media_url from '(voice/[^/]+/[^/?]+)$')
+WHERE message_type = 'voice' AND media_key IS NULL AND media_url IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Only text messages are searchable; the 'simple' configuration does no
+-- stemming, so it works the same for every language users write in.
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS content_tsv tsvector
+GENERATED ALWAYS AS (
+    to_tsvector('simple', CASE WHEN message_type = 'text' THEN content ELSE '' END)
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_content_tsv
+ON messages USING GIN (content_tsv);
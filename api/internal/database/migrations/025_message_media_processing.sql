@@ -0,0 +1,9 @@
+-- media_status tracks the voice processing pipeline: 'processing' until the
+-- upload is transcoded, then 'ready' or 'failed'. NULL for everything else,
+-- including voice messages sent before the pipeline existed.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS media_status VARCHAR(16);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_messages_media_processing
+ON messages (created_at)
+WHERE media_status = 'processing';
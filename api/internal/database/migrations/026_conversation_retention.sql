@@ -0,0 +1,23 @@
+-- Disappearing messages are shared by both participants, so the setting is
+-- stored once per pair, with user_a_id the smaller of the two IDs.
+CREATE TABLE IF NOT EXISTS conversation_retention (
+    user_a_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_b_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    retention_seconds INTEGER NOT NULL CHECK (retention_seconds > 0),
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_a_id, user_b_id),
+    CHECK (user_a_id < user_b_id)
+);
+
+-- Messages get their expiry when sent, so changing the setting only affects
+-- what is sent afterwards.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at
+ON messages (expires_at)
+WHERE expires_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_message_entity
+ON notifications (entity_id)
+WHERE type = 'message';
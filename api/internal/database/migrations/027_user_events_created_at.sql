@@ -0,0 +1,2 @@
+-- Lets the periodic trim find expired events without scanning every log.
+CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events(created_at);
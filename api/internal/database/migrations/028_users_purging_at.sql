@@ -0,0 +1,3 @@
+-- Set when the purge job starts removing an account's files; from then on
+-- signing in can no longer restore the account.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purging_at TIMESTAMPTZ;
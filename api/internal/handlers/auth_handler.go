@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,20 +17,28 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"matcha/api/internal/config"
+	"matcha/api/internal/jwtkeys"
 	"matcha/api/internal/middleware"
+	"matcha/api/internal/repository"
 	"matcha/api/internal/services"
 	"matcha/api/internal/store"
 	"matcha/api/internal/validation"
+	ws "matcha/api/internal/websocket"
 )
 
-const emailVerifyTTL = 24 * time.Hour
+const (
+	emailVerifyTTL = 24 * time.Hour
+	accessTokenTTL = 15 * time.Minute
+	mfaTokenTTL    = 5 * time.Minute
+)
 
 type AuthHandler struct {
 	authSvc         *services.AuthService
 	syncSvc         *services.SyncService
 	mailer          *services.Mailer
 	tokenStore      *store.TokenStore
-	secret          string
+	hub             *ws.Hub
+	keys            *jwtkeys.KeySet
 	publicAPIBase   string
 	frontendBaseURL string
 }
@@ -37,7 +48,8 @@ func NewAuthHandler(
 	syncSvc *services.SyncService,
 	mailer *services.Mailer,
 	tokenStore *store.TokenStore,
-	jwtSecret string,
+	hub *ws.Hub,
+	keys *jwtkeys.KeySet,
 	publicAPIBase string,
 	frontendBaseURL string,
 ) *AuthHandler {
@@ -46,7 +58,8 @@ func NewAuthHandler(
 		syncSvc:         syncSvc,
 		mailer:          mailer,
 		tokenStore:      tokenStore,
-		secret:          jwtSecret,
+		hub:             hub,
+		keys:            keys,
 		publicAPIBase:   publicAPIBase,
 		frontendBaseURL: frontendBaseURL,
 	}
@@ -81,6 +94,14 @@ type UpdateAccountReq struct {
 	LastName  string `json:"last_name" binding:"required"`
 }
 
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type DeleteAccountReq struct {
+	Password string `json:"password" binding:"required"`
+}
+
 type ChangePasswordReq struct {
 	CurrentPassword string `json:"current_password" binding:"required"`
 	NewPassword     string `json:"new_password" binding:"required"`
@@ -139,13 +160,15 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	if config.E2ESkipEmailVerification() {
 		_ = h.authSvc.VerifyEmail(c.Request.Context(), u.ID)
-		token, err := h.issueToken(u.ID)
+		tokens, err := h.issueSession(c, u.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 			return
 		}
 		c.JSON(http.StatusCreated, gin.H{
-			"token": token,
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    int(accessTokenTTL.Seconds()),
 			"user": gin.H{
 				"id":         u.ID,
 				"username":   u.Username,
@@ -183,28 +206,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
 // @Failure	302		Redirect to frontend /matches with error param
 // @Router		/api/v1/auth/verify-email [get]
 func (h *AuthHandler) VerifyEmail(c *gin.Context) {
-	base := h.normalizedFrontendBaseURL()
-
 	jsRedirect := func(params string) {
-		target := base + "/matches"
-		if params != "" {
-			target += "?" + params
-		}
-		body := `<!DOCTYPE html>
-<html>
-<head>
-  <meta charset="utf-8">
-  <title>Matcha — Email Verification</title>
-</head>
-<body>
-  <p>Verifying your email, please wait...</p>
-  <script>window.location.replace("` + target + `");</script>
-  <noscript><meta http-equiv="refresh" content="0;url=` + target + `"></noscript>
-  <p>If you are not redirected, <a href="` + target + `">click here</a>.</p>
-</body>
-</html>`
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, body)
+		h.jsRedirect(c, "/matches", params, "Email Verification", "Verifying your email, please wait...")
 	}
 
 	token := c.Query("token")
@@ -225,13 +228,13 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 		jsRedirect("error=verify_failed")
 		return
 	}
-	jwtToken, err := h.issueToken(userID)
+	tokens, err := h.issueSession(c, userID)
 	if err != nil {
 		log.Printf("[auth] verify ok but token issue failed for user=%s: %v", userID, err)
 		jsRedirect("verified=1")
 		return
 	}
-	jsRedirect("verified=1&token=" + jwtToken)
+	jsRedirect("verified=1&token=" + tokens.AccessToken + "&refresh_token=" + url.QueryEscape(tokens.RefreshToken))
 }
 
 // ForgotPassword godoc
@@ -242,6 +245,7 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 // @Param		body	body		ForgotPasswordReq	true	"Forgot password payload"
 // @Success	200		{object}	map[string]string
 // @Failure	400		{object}	map[string]string
+// @Failure	429		{object}	map[string]interface{}
 // @Router		/api/v1/auth/forgot-password [post]
 func (h *AuthHandler) ForgotPassword(c *gin.Context) {
 	var req ForgotPasswordReq
@@ -254,6 +258,13 @@ func (h *AuthHandler) ForgotPassword(c *gin.Context) {
 		return
 	}
 
+	if retry, err := h.authSvc.PasswordResetRetryAfter(c.Request.Context(), req.Email, c.ClientIP()); err != nil {
+		log.Printf("[auth] password reset throttle check failed: %v", err)
+	} else if retry > 0 {
+		tooManyRequests(c, retry, "too many password reset requests, try again later")
+		return
+	}
+
 	resetToken, u, err := h.authSvc.RequestPasswordReset(c.Request.Context(), req.Email)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -290,7 +301,8 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	if err := h.authSvc.ResetPassword(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+	userID, err := h.authSvc.ResetPassword(c.Request.Context(), req.Token, req.NewPassword)
+	if err != nil {
 		if err == services.ErrInvalidResetToken {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
 			return
@@ -298,6 +310,7 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	h.hub.DisconnectUser(userID, "")
 	c.JSON(http.StatusOK, gin.H{"message": "password reset successful"})
 }
 
@@ -310,6 +323,7 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 // @Success	200	{object}	map[string]interface{}
 // @Failure	400	{object}	map[string]string
 // @Failure	401	{object}	map[string]string
+// @Failure	429	{object}	map[string]interface{}
 // @Router		/api/v1/auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginReq
@@ -325,37 +339,96 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	u, err := h.authSvc.Login(c.Request.Context(), req.Username, req.Password)
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	if retry, err := h.authSvc.LoginRetryAfter(ctx, req.Username, ip); err != nil {
+		log.Printf("[auth] login throttle check failed for username=%q: %v", req.Username, err)
+	} else if retry > 0 {
+		tooManyRequests(c, retry, "too many login attempts, try again later")
+		return
+	}
+	u, err := h.authSvc.Login(ctx, req.Username, req.Password)
 	if err != nil {
 		if err == services.ErrEmailNotVerified {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "email not verified"})
 			return
 		}
 		log.Printf("[auth] login failed for username=%q: %v", req.Username, err)
+		retry, locked, err := h.authSvc.RecordLoginFailure(ctx, req.Username, ip)
+		if err != nil {
+			log.Printf("[auth] failed recording login failure for username=%q: %v", req.Username, err)
+		}
+		if locked != nil {
+			log.Printf("[auth] account locked after repeated failures: user=%s ip=%s", locked.ID, ip)
+			h.sendLockoutEmail(locked, ip)
+		}
+		if retry > 0 {
+			c.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(retry), 10))
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
-	token, err := h.issueToken(u.ID)
+	if err := h.authSvc.ResetLoginFailures(ctx, req.Username, ip); err != nil {
+		log.Printf("[auth] failed clearing login failures for user=%s: %v", u.ID, err)
+	}
+	if u.TOTPEnabledAt.Valid {
+		mfaToken, err := h.authSvc.StartMFAChallenge(c.Request.Context(), u.ID)
+		if err != nil {
+			log.Printf("[auth] login ok but mfa challenge failed for user=%s: %v", u.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(mfaTokenTTL.Seconds()),
+		})
+		return
+	}
+	h.respondWithSession(c, u)
+}
+
+// Refresh godoc
+// @Summary	Exchange a refresh token for a new access/refresh token pair
+// @Tags		auth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		RefreshReq	true	"Refresh token"
+// @Success	200	{object}	map[string]interface{}
+// @Failure	400	{object}	map[string]string
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, sessionID, refreshToken, err := h.authSvc.RefreshSession(c.Request.Context(), req.RefreshToken, c.ClientIP())
 	if err != nil {
-		log.Printf("[auth] login ok but token issue failed for user=%s: %v", u.ID, err)
+		if err == services.ErrInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		log.Printf("[auth] refresh failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	accessToken, err := h.issueAccessToken(userID, sessionID)
+	if err != nil {
+		log.Printf("[auth] refresh ok but token issue failed for user=%s: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
-	log.Printf("[auth] login ok: user=%s username=%q", u.ID, u.Username)
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":         u.ID,
-			"username":   u.Username,
-			"email":      u.Email,
-			"first_name": u.FirstName,
-			"last_name":  u.LastName,
-		},
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
 	})
 }
 
 // UpdateMe godoc
-// @Summary	Update account (username, email, first_name, last_name)
+// @Summary	Update account (username, first_name, last_name; a new email must be confirmed first)
 // @Tags		auth
 // @Security	BearerAuth
 // @Accept		json
@@ -375,7 +448,8 @@ func (h *AuthHandler) UpdateMe(c *gin.Context) {
 		return
 	}
 
-	if err := h.authSvc.UpdateAccount(c.Request.Context(), id, req.Username, req.Email, req.FirstName, req.LastName); err != nil {
+	change, err := h.authSvc.UpdateAccount(c.Request.Context(), id, req.Username, req.Email, req.FirstName, req.LastName)
+	if err != nil {
 		if err == services.ErrUserExists {
 			c.JSON(http.StatusConflict, gin.H{"error": "user exists"})
 			return
@@ -386,7 +460,16 @@ func (h *AuthHandler) UpdateMe(c *gin.Context) {
 	if err := h.syncSvc.SyncUser(c.Request.Context(), id); err != nil {
 		log.Printf("[auth] sync to ES failed for user=%s: %v", id, err)
 	}
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	if change == nil {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+	if err := h.sendEmailChangeMails(change); err != nil {
+		log.Printf("[auth] failed sending email change mails for user=%s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send confirmation email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "pending_email": change.NewEmail})
 }
 
 // ChangePassword godoc
@@ -414,7 +497,8 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	if err := h.authSvc.ChangePassword(c.Request.Context(), id, req.CurrentPassword, req.NewPassword); err != nil {
+	sessionID := c.GetString(middleware.SessionIDKey)
+	if err := h.authSvc.ChangePassword(c.Request.Context(), id, req.CurrentPassword, req.NewPassword, sessionID); err != nil {
 		if err == services.ErrInvalidPassword {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
 			return
@@ -422,6 +506,7 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	h.hub.DisconnectUser(id, sessionID)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
@@ -442,15 +527,67 @@ func (h *AuthHandler) Me(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	pendingEmail, err := h.authSvc.PendingEmailChange(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("[auth] pending email lookup failed for user=%s: %v", id, err)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"id":         u.ID,
-		"username":   u.Username,
-		"email":      u.Email,
-		"first_name": u.FirstName,
-		"last_name":  u.LastName,
+		"id":                 u.ID,
+		"username":           u.Username,
+		"email":              u.Email,
+		"pending_email":      pendingEmail,
+		"first_name":         u.FirstName,
+		"last_name":          u.LastName,
+		"two_factor_enabled": u.TOTPEnabledAt.Valid,
 	})
 }
 
+// DeleteMe godoc
+// @Summary	Delete current account (restorable by signing in within 14 days)
+// @Tags		auth
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		DeleteAccountReq	true	"Password confirmation"
+// @Success	200	{object}	map[string]interface{}
+// @Failure	400	{object}	map[string]string
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/me [delete]
+func (h *AuthHandler) DeleteMe(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	var req DeleteAccountReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	purgeAt, err := h.authSvc.RequestAccountDeletion(c.Request.Context(), id, req.Password)
+	if err != nil {
+		if err == services.ErrInvalidPassword {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.hub.DisconnectUser(id, "")
+	if err := h.syncSvc.RemoveUser(c.Request.Context(), id); err != nil {
+		log.Printf("[auth] remove from ES failed for user=%s: %v", id, err)
+	}
+	if u, err := h.authSvc.GetByID(c.Request.Context(), id); err == nil {
+		body := fmt.Sprintf(
+			"Hi %s,\n\nYour Matcha account is scheduled for deletion on %s.\nChanged your mind? Just sign in before then and the account will be restored.\n\n%s/login\n",
+			u.FirstName, purgeAt.UTC().Format("2 January 2006"), h.normalizedFrontendBaseURL(),
+		)
+		if err := h.mailer.Send(u.Email, "Matcha account deletion scheduled", body); err != nil {
+			log.Printf("[auth] failed sending deletion email to user=%s: %v", id, err)
+		}
+	}
+	log.Printf("[auth] account deletion requested: user=%s purge_at=%s", id, purgeAt.UTC().Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{"ok": true, "purge_at": purgeAt.UTC()})
+}
+
 // Logout godoc
 // @Summary	Logout current user
 // @Tags		auth
@@ -460,18 +597,218 @@ func (h *AuthHandler) Me(c *gin.Context) {
 // @Failure	401	{object}	map[string]string
 // @Router		/api/v1/auth/logout [post]
 func (h *AuthHandler) Logout(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+	sessionID := c.GetString(middleware.SessionIDKey)
+	if err := h.authSvc.RevokeSession(c.Request.Context(), id, sessionID); err != nil && err != services.ErrSessionNotFound {
+		log.Printf("[auth] logout failed to revoke session=%s for user=%s: %v", sessionID, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	h.hub.DisconnectSession(id, sessionID)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func (h *AuthHandler) issueToken(userID uuid.UUID) (string, error) {
+// ListSessions godoc
+// @Summary	List active login sessions of the current user
+// @Tags		auth
+// @Security	BearerAuth
+// @Produce	json
+// @Success	200	{array}		object
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/sessions [get]
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+	current := c.GetString(middleware.SessionIDKey)
+
+	sessions, err := h.authSvc.ListSessions(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	resp := make([]gin.H, len(sessions))
+	for i, s := range sessions {
+		resp[i] = gin.H{
+			"id":           s.ID,
+			"created_at":   s.CreatedAt,
+			"last_used_at": s.LastUsedAt,
+			"ip":           s.IP,
+			"user_agent":   s.UserAgent,
+			"current":      s.ID == current,
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeSession godoc
+// @Summary	Sign out one of the current user's sessions
+// @Tags		auth
+// @Security	BearerAuth
+// @Param		id	path		string	true	"Session ID"
+// @Success	204	"No Content"
+// @Failure	401	{object}	map[string]string
+// @Failure	404	{object}	map[string]string
+// @Router		/api/v1/auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+	sessionID := strings.TrimSpace(c.Param("id"))
+
+	if err := h.authSvc.RevokeSession(c.Request.Context(), id, sessionID); err != nil {
+		if err == services.ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.hub.DisconnectSession(id, sessionID)
+	log.Printf("[auth] session revoked: user=%s session=%s", id, sessionID)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) respondWithSession(c *gin.Context, u *repository.User) {
+	restored, err := h.restoreOnSignIn(c.Request.Context(), u)
+	if err == services.ErrAccountPurged {
+		c.JSON(http.StatusGone, gin.H{"error": "account has been deleted"})
+		return
+	}
+	if err != nil {
+		log.Printf("[auth] failed restoring account for user=%s: %v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	tokens, err := h.issueSession(c, u.ID)
+	if err != nil {
+		log.Printf("[auth] login ok but token issue failed for user=%s: %v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	log.Printf("[auth] login ok: user=%s username=%q", u.ID, u.Username)
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"restored":      restored,
+		"user": gin.H{
+			"id":         u.ID,
+			"username":   u.Username,
+			"email":      u.Email,
+			"first_name": u.FirstName,
+			"last_name":  u.LastName,
+		},
+	})
+}
+
+// restoreOnSignIn cancels a pending account deletion; signing in is how a
+// user takes the deletion back.
+func (h *AuthHandler) restoreOnSignIn(ctx context.Context, u *repository.User) (bool, error) {
+	restored, err := h.authSvc.RestoreAccount(ctx, u)
+	if err != nil || !restored {
+		return false, err
+	}
+	log.Printf("[auth] account deletion cancelled by sign-in: user=%s", u.ID)
+	if err := h.syncSvc.SyncUser(ctx, u.ID); err != nil {
+		log.Printf("[auth] sync to ES failed for user=%s: %v", u.ID, err)
+	}
+	return true, nil
+}
+
+// jsRedirect answers an emailed link with a small page that forwards the
+// browser to path on the frontend.
+func (h *AuthHandler) jsRedirect(c *gin.Context, path, params, title, text string) {
+	target := h.normalizedFrontendBaseURL() + path
+	if params != "" {
+		target += "?" + params
+	}
+	body := `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Matcha — ` + title + `</title>
+</head>
+<body>
+  <p>` + text + `</p>
+  <script>window.location.replace("` + target + `");</script>
+  <noscript><meta http-equiv="refresh" content="0;url=` + target + `"></noscript>
+  <p>If you are not redirected, <a href="` + target + `">click here</a>.</p>
+</body>
+</html>`
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusOK, body)
+}
+
+func (h *AuthHandler) sendLockoutEmail(u *repository.User, ip string) {
+	resetLink := fmt.Sprintf("%s/forgot-password", h.normalizedFrontendBaseURL())
+	body := fmt.Sprintf(
+		"Hi %s,\n\nWe noticed repeated failed sign-in attempts on your Matcha account (last from %s), so sign-in is locked for %d minutes.\n\nIf this was not you, we recommend resetting your password:\n%s\n",
+		u.FirstName, ip, int(services.LoginLockoutDuration.Minutes()), resetLink,
+	)
+	if err := h.mailer.Send(u.Email, "Matcha sign-in temporarily locked", body); err != nil {
+		log.Printf("[auth] failed sending lockout email to user=%s: %v", u.ID, err)
+	}
+}
+
+func tooManyRequests(c *gin.Context, retry time.Duration, msg string) {
+	secs := retryAfterSeconds(retry)
+	c.Header("Retry-After", strconv.FormatInt(secs, 10))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": msg, "retry_after": secs})
+}
+
+// retryAfterSeconds formats d for the Retry-After header, rounding up so
+// clients never retry too early.
+func retryAfterSeconds(d time.Duration) int64 {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// JWKS godoc
+// @Summary	Public keys for verifying Matcha access tokens
+// @Tags		auth
+// @Produce	json
+// @Success	200	{object}	jwtkeys.JWKS
+// @Router		/.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func (h *AuthHandler) issueSession(c *gin.Context, userID uuid.UUID) (*tokenPair, error) {
+	sessionID, refreshToken, err := h.authSvc.CreateSession(c.Request.Context(), userID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := h.issueAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (h *AuthHandler) issueAccessToken(userID uuid.UUID, sessionID string) (string, error) {
+	now := time.Now()
 	claims := &middleware.Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
 		},
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(h.secret))
+	return h.keys.Sign(claims)
 }
 
 func (h *AuthHandler) issueEmailVerificationToken(ctx context.Context, userID uuid.UUID) (string, error) {
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+)
+
+// ListCalls godoc
+// @Summary	Call history with a match
+// @Description	Finished calls, newest first. status is completed, missed, rejected, cancelled or busy.
+// @Tags		chat
+// @Security	BearerAuth
+// @Param		id		path		string	true	"User ID (must be a match)"
+// @Param		limit	query		int		false	"Limit (default 50)"
+// @Param		offset	query		int		false	"Offset"
+// @Success	200		{array}		object
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Router		/api/v1/users/{id}/calls [get]
+func (h *ChatHandler) ListCalls(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	otherID, err := h.validateChatPeer(c, myID)
+	if err != nil {
+		if strings.Contains(err.Error(), "match") || strings.Contains(err.Error(), "blocked") {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, offset := parseLimitOffsetChat(c)
+	calls, err := h.callRepo.ListBetween(c.Request.Context(), myID, otherID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, len(calls))
+	for i, call := range calls {
+		var duration int64
+		if call.AnsweredAt != nil {
+			duration = int64(call.EndedAt.Sub(*call.AnsweredAt).Seconds())
+		}
+		result[i] = gin.H{
+			"id":               call.ID,
+			"call_id":          call.CallID,
+			"caller_id":        call.CallerID,
+			"callee_id":        call.CalleeID,
+			"mode":             call.Mode,
+			"status":           call.Status,
+			"started_at":       call.StartedAt,
+			"answered_at":      call.AnsweredAt,
+			"ended_at":         call.EndedAt,
+			"duration_seconds": duration,
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
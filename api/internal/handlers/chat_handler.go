@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"matcha/api/internal/audio"
 	"matcha/api/internal/middleware"
 	"matcha/api/internal/repository"
 	"matcha/api/internal/services"
@@ -25,9 +29,12 @@ type ChatHandler struct {
 	userRepo         *repository.UserRepository
 	blockRepo        *repository.BlockRepository
 	notificationRepo *repository.NotificationRepository
+	callRepo         *repository.CallRepository
 	mailer           *services.Mailer
 	hub              *ws.Hub
 	store            *storage.MinIO
+	voice            *services.VoiceProcessor
+	editWindow       time.Duration
 }
 
 func NewChatHandler(
@@ -36,9 +43,12 @@ func NewChatHandler(
 	userRepo *repository.UserRepository,
 	blockRepo *repository.BlockRepository,
 	notificationRepo *repository.NotificationRepository,
+	callRepo *repository.CallRepository,
 	mailer *services.Mailer,
 	hub *ws.Hub,
 	store *storage.MinIO,
+	voice *services.VoiceProcessor,
+	editWindow time.Duration,
 ) *ChatHandler {
 	return &ChatHandler{
 		messageRepo:      messageRepo,
@@ -46,14 +56,19 @@ func NewChatHandler(
 		userRepo:         userRepo,
 		blockRepo:        blockRepo,
 		notificationRepo: notificationRepo,
+		callRepo:         callRepo,
 		mailer:           mailer,
 		hub:              hub,
 		store:            store,
+		voice:            voice,
+		editWindow:       editWindow,
 	}
 }
 
 type SendMessageReq struct {
 	Content string `json:"content" binding:"required"`
+	// ReplyToMessageID quotes an earlier message of the same conversation.
+	ReplyToMessageID *uuid.UUID `json:"reply_to_message_id"`
 }
 
 // SendMessage godoc
@@ -94,7 +109,19 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	m, err := h.messageRepo.Create(c.Request.Context(), myID, otherID, req.Content)
+	if req.ReplyToMessageID != nil {
+		ok, err := h.messageRepo.InConversation(c.Request.Context(), *req.ReplyToMessageID, myID, otherID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reply_to_message_id must be a message of this conversation"})
+			return
+		}
+	}
+
+	m, err := h.messageRepo.Create(c.Request.Context(), myID, otherID, req.Content, req.ReplyToMessageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -122,17 +149,7 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	if h.hub != nil {
 		event := gin.H{
 			"type": "message",
-			"data": gin.H{
-				"id":           m.ID,
-				"sender_id":    m.SenderID,
-				"receiver_id":  m.ReceiverID,
-				"content":      m.Content,
-				"message_type": m.MessageType,
-				"media_url":    m.MediaURL,
-				"created_at":   m.CreatedAt,
-				"is_read":      m.IsRead,
-				"read_at":      m.ReadAt,
-			},
+			"data": messageJSON(m),
 		}
 		h.hub.SendToUser(myID, event)
 		h.hub.SendToUser(otherID, event)
@@ -154,27 +171,19 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":           m.ID,
-		"sender_id":    m.SenderID,
-		"receiver_id":  m.ReceiverID,
-		"content":      m.Content,
-		"message_type": m.MessageType,
-		"media_url":    m.MediaURL,
-		"created_at":   m.CreatedAt,
-		"is_read":      m.IsRead,
-		"read_at":      m.ReadAt,
-	})
+	c.JSON(http.StatusCreated, messageJSON(m))
 }
 
 // GetMessages godoc
 // @Summary	Get messages with a match
+// @Description	Keyset-paginated, always in chronological order. Without a cursor the newest messages are returned; pass older_cursor as before to scroll back, or newer_cursor as after to fetch what came since.
 // @Tags		chat
 // @Security	BearerAuth
 // @Param		id		path		string	true	"User ID (must be a match)"
-// @Param		limit	query		int		false	"Limit (default 50)"
-// @Param		offset	query		int		false	"Offset"
-// @Success	200		{array}		object
+// @Param		limit	query		int		false	"Limit (default 50, max 100)"
+// @Param		before	query		string	false	"Cursor: messages older than this"
+// @Param		after	query		string	false	"Cursor: messages newer than this"
+// @Success	200		{object}	object
 // @Failure	400		{object}	map[string]string
 // @Failure	403		{object}	map[string]string
 // @Router		/api/v1/users/{id}/messages [get]
@@ -213,29 +222,68 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
-	limit, offset := parseLimitOffsetChat(c)
+	limit := parseCursorLimit(c, 50, 100)
+	before, err := parsePageCursor(c.Query("before"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before cursor"})
+		return
+	}
+	after, err := parsePageCursor(c.Query("after"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid after cursor"})
+		return
+	}
+	if before != nil && after != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "use either before or after"})
+		return
+	}
+	older := after == nil
+	cursor := before
+	if after != nil {
+		cursor = after
+	}
 
-	msgs, err := h.messageRepo.GetBetween(c.Request.Context(), myID, otherID, limit, offset)
+	msgs, err := h.messageRepo.GetPage(c.Request.Context(), myID, otherID, limit+1, cursorTime(cursor), cursorID(cursor), older)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	result := make([]gin.H, len(msgs))
-	for i, m := range msgs {
-		result[i] = gin.H{
-			"id":           m.ID,
-			"sender_id":    m.SenderID,
-			"receiver_id":  m.ReceiverID,
-			"content":      m.Content,
-			"message_type": m.MessageType,
-			"media_url":    m.MediaURL,
-			"created_at":   m.CreatedAt,
-			"is_read":      m.IsRead,
-			"read_at":      m.ReadAt,
+	// The extra row only tells whether the window reaches the end in the
+	// direction of travel.
+	var hasOlder, hasNewer bool
+	if older {
+		hasOlder = len(msgs) > limit
+		hasNewer = before != nil
+		if hasOlder {
+			msgs = msgs[1:]
+		}
+	} else {
+		hasOlder = true
+		hasNewer = len(msgs) > limit
+		if hasNewer {
+			msgs = msgs[:limit]
 		}
 	}
-	c.JSON(http.StatusOK, result)
+
+	result := make([]gin.H, len(msgs))
+	for i := range msgs {
+		result[i] = messageJSON(&msgs[i])
+	}
+	olderCursor, newerCursor := "", ""
+	if len(msgs) > 0 {
+		olderCursor = encodePageCursor(msgs[0].CreatedAt, msgs[0].ID)
+		newerCursor = encodePageCursor(msgs[len(msgs)-1].CreatedAt, msgs[len(msgs)-1].ID)
+	} else if cursor != nil {
+		olderCursor = encodePageCursor(cursor.Time, cursor.ID)
+		newerCursor = olderCursor
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":        result,
+		"older_cursor": olderCursor,
+		"newer_cursor": newerCursor,
+		"has_older":    hasOlder,
+		"has_newer":    hasNewer,
+	})
 }
 
 var allowedVoiceContentTypes = map[string]struct{}{
@@ -350,6 +398,22 @@ func (h *ChatHandler) SendVoiceMessage(c *gin.Context) {
 		return
 	}
 
+	// Without ffmpeg the upload is kept as it is, like before processing
+	// existed.
+	var mediaStatus *string
+	if h.voice != nil {
+		if err := h.voice.Validate(c.Request.Context(), buf, ext); err != nil {
+			if errors.Is(err, audio.ErrNotAudio) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "voice file is not playable audio"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check voice file"})
+			return
+		}
+		status := repository.MediaProcessing
+		mediaStatus = &status
+	}
+
 	voiceID := uuid.NewString()
 	objectKey := fmt.Sprintf("voice/%s/%s%s", myID.String(), voiceID, ext)
 	mediaURL, err := h.store.PutObject(c.Request.Context(), objectKey, bytes.NewReader(buf), int64(len(buf)), contentType)
@@ -359,7 +423,7 @@ func (h *ChatHandler) SendVoiceMessage(c *gin.Context) {
 	}
 
 	voiceLabel := "Voice message"
-	m, err := h.messageRepo.CreateWithMeta(c.Request.Context(), myID, otherID, voiceLabel, "voice", &mediaURL)
+	m, err := h.messageRepo.CreateWithMeta(c.Request.Context(), myID, otherID, voiceLabel, "voice", &mediaURL, &objectKey, mediaStatus, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -368,33 +432,47 @@ func (h *ChatHandler) SendVoiceMessage(c *gin.Context) {
 	if h.hub != nil {
 		event := gin.H{
 			"type": "message",
-			"data": gin.H{
-				"id":           m.ID,
-				"sender_id":    m.SenderID,
-				"receiver_id":  m.ReceiverID,
-				"content":      m.Content,
-				"message_type": m.MessageType,
-				"media_url":    m.MediaURL,
-				"created_at":   m.CreatedAt,
-				"is_read":      m.IsRead,
-				"read_at":      m.ReadAt,
-			},
+			"data": messageJSON(m),
 		}
 		h.hub.SendToUser(myID, event)
 		h.hub.SendToUser(otherID, event)
 	}
+	if mediaStatus != nil {
+		go h.processVoice(m)
+	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":           m.ID,
-		"sender_id":    m.SenderID,
-		"receiver_id":  m.ReceiverID,
-		"content":      m.Content,
-		"message_type": m.MessageType,
-		"media_url":    m.MediaURL,
-		"created_at":   m.CreatedAt,
-		"is_read":      m.IsRead,
-		"read_at":      m.ReadAt,
-	})
+	c.JSON(http.StatusCreated, messageJSON(m))
+}
+
+// processVoice runs the voice pipeline on a new message and tells both
+// participants the outcome with a message_processed event.
+func (h *ChatHandler) processVoice(m *repository.Message) {
+	processed, err := h.voice.Process(context.Background(), m)
+	if err != nil {
+		log.Printf("[chat] voice processing failed for message=%s: %v", m.ID, err)
+	}
+	if processed == nil || h.hub == nil {
+		return
+	}
+	event := gin.H{"type": "message_processed", "data": messageJSON(processed)}
+	h.hub.SendToUser(processed.SenderID, event)
+	h.hub.SendToUser(processed.ReceiverID, event)
+}
+
+// ResumeVoiceProcessing queues the voice messages a previous run left
+// unprocessed.
+func (h *ChatHandler) ResumeVoiceProcessing(ctx context.Context) {
+	if h.voice == nil {
+		return
+	}
+	msgs, err := h.voice.ListUnfinished(ctx)
+	if err != nil {
+		log.Printf("[chat] listing unprocessed voice messages: %v", err)
+		return
+	}
+	for i := range msgs {
+		go h.processVoice(&msgs[i])
+	}
 }
 
 // MarkRead godoc
@@ -460,6 +538,54 @@ func (h *ChatHandler) MarkRead(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"updated": affected})
 }
 
+func messageJSON(m *repository.Message) gin.H {
+	mediaURL := m.MediaURL
+	if mediaURL == nil && m.MediaKey != nil {
+		u := messageMediaPath(m.ID)
+		mediaURL = &u
+	}
+	return gin.H{
+		"id":                  m.ID,
+		"sender_id":           m.SenderID,
+		"receiver_id":         m.ReceiverID,
+		"content":             m.Content,
+		"message_type":        m.MessageType,
+		"media_url":           mediaURL,
+		"media_status":        m.MediaStatus,
+		"metadata":            m.Metadata,
+		"created_at":          m.CreatedAt,
+		"is_read":             m.IsRead,
+		"read_at":             m.ReadAt,
+		"edited_at":           m.EditedAt,
+		"deleted_at":          m.DeletedAt,
+		"reply_to_message_id": m.ReplyToID,
+		"expires_at":          m.ExpiresAt,
+		"reactions":           groupReactions(m.Reactions),
+	}
+}
+
+type reactionGroup struct {
+	Emoji   string      `json:"emoji"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// groupReactions folds reactions into one entry per emoji, in the order each
+// emoji was first used.
+func groupReactions(reactions []repository.Reaction) []reactionGroup {
+	groups := []reactionGroup{}
+	index := map[string]int{}
+	for _, r := range reactions {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(groups)
+			index[r.Emoji] = i
+			groups = append(groups, reactionGroup{Emoji: r.Emoji})
+		}
+		groups[i].UserIDs = append(groups[i].UserIDs, r.UserID)
+	}
+	return groups
+}
+
 func parseLimitOffsetChat(c *gin.Context) (limit, offset int) {
 	limit = 50
 	offset = 0
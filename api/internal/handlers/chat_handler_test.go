@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"matcha/api/internal/repository"
+)
+
+func TestGroupReactions(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	got := groupReactions([]repository.Reaction{
+		{UserID: a, Emoji: "👍"},
+		{UserID: b, Emoji: "❤️"},
+		{UserID: b, Emoji: "👍"},
+	})
+	want := []reactionGroup{
+		{Emoji: "👍", UserIDs: []uuid.UUID{a, b}},
+		{Emoji: "❤️", UserIDs: []uuid.UUID{b}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("groupReactions() = %v, want %v", got, want)
+	}
+
+	if got := groupReactions(nil); got == nil || len(got) != 0 {
+		t.Fatalf("groupReactions(nil) = %#v, want empty slice", got)
+	}
+}
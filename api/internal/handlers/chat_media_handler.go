@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/storage"
+)
+
+var allowedChatImageContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+var allowedChatImageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".gif":  true,
+}
+
+// messageMediaPath is where the participants fetch a private attachment.
+func messageMediaPath(messageID uuid.UUID) string {
+	return "/api/v1/messages/" + messageID.String() + "/media"
+}
+
+// SendImageMessage godoc
+// @Summary	Send an image or GIF to a match
+// @Description	The image is stored privately and served to the two participants only, via the message's media_url.
+// @Tags		chat
+// @Security	BearerAuth
+// @Accept		multipart/form-data
+// @Produce	json
+// @Param		id		path		string	true	"User ID (must be a match)"
+// @Param		file	formData	file	true	"Image (jpeg, png, webp or gif, max 10MB)"
+// @Success	201		{object}	object
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Router		/api/v1/users/{id}/messages/image [post]
+func (h *ChatHandler) SendImageMessage(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	otherID, err := h.validateChatPeer(c, myID)
+	if err != nil {
+		if strings.Contains(err.Error(), "match") || strings.Contains(err.Error(), "blocked") {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "image storage is not configured"})
+		return
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	reader, file, contentType, err := openImageUpload(fh, allowedChatImageExtensions, allowedChatImageContentTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	ctx := c.Request.Context()
+	objectKey := storage.BuildChatObjectKey(myID, otherID, uuid.NewString(), filepath.Ext(fh.Filename))
+	if _, err := h.store.PutObject(ctx, objectKey, reader, fh.Size, contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store image"})
+		return
+	}
+
+	label := "Photo"
+	if contentType == "image/gif" {
+		label = "GIF"
+	}
+	m, err := h.messageRepo.CreateWithMeta(ctx, myID, otherID, label, "image", nil, &objectKey, nil, nil)
+	if err != nil {
+		_ = h.store.RemoveObject(ctx, objectKey)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if h.hub != nil {
+		event := gin.H{"type": "message", "data": messageJSON(m)}
+		h.hub.SendToUser(myID, event)
+		h.hub.SendToUser(otherID, event)
+	}
+	c.JSON(http.StatusCreated, messageJSON(m))
+}
+
+// ServeMessageMedia godoc
+// @Summary	Download a private chat attachment
+// @Description	Only the sender and the receiver of the message can fetch it.
+// @Tags		chat
+// @Security	BearerAuth
+// @Produce	image/*
+// @Param		id	path		string	true	"Message ID"
+// @Success	200	{file}	binary
+// @Failure	404	{object}	map[string]string
+// @Router		/api/v1/messages/{id}/media [get]
+func (h *ChatHandler) ServeMessageMedia(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "media not found"})
+		return
+	}
+	m, err := h.messageRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if m == nil || m.MediaKey == nil || !strings.HasPrefix(*m.MediaKey, storage.ChatPrefix) ||
+		(m.SenderID != myID && m.ReceiverID != myID) || h.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "media not found"})
+		return
+	}
+
+	obj, err := h.store.GetObject(c.Request.Context(), *m.MediaKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "media not found"})
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "media not found"})
+		return
+	}
+	c.Header("Content-Type", info.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+	if _, err := io.Copy(c.Writer, obj); err != nil {
+		log.Printf("[chat] media download interrupted for message=%s: %v", m.ID, err)
+	}
+}
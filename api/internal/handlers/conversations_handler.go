@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/repository"
+	ws "matcha/api/internal/websocket"
+)
+
+// previewLength caps the last-message preview in the inbox, in characters.
+const previewLength = 100
+
+type ConversationsHandler struct {
+	conversations *repository.ConversationRepository
+	messages      *repository.MessageRepository
+	likes         *repository.LikeRepository
+	blocks        *repository.BlockRepository
+	photos        *repository.PhotoRepository
+	hub           *ws.Hub
+	apiBaseURL    string
+}
+
+func NewConversationsHandler(
+	conversations *repository.ConversationRepository,
+	messages *repository.MessageRepository,
+	likes *repository.LikeRepository,
+	blocks *repository.BlockRepository,
+	photos *repository.PhotoRepository,
+	hub *ws.Hub,
+	apiBaseURL string,
+) *ConversationsHandler {
+	return &ConversationsHandler{
+		conversations: conversations,
+		messages:      messages,
+		likes:         likes,
+		blocks:        blocks,
+		photos:        photos,
+		hub:           hub,
+		apiBaseURL:    apiBaseURL,
+	}
+}
+
+// List godoc
+// @Summary	List conversations (inbox)
+// @Description	Matches ordered by last activity (last message, or the match itself), with the last message preview, unread count and mute/pin/archive state. Blocked users are excluded.
+// @Tags		chat
+// @Security	BearerAuth
+// @Param		limit		query		int		false	"Limit (default 20)"
+// @Param		cursor		query		string	false	"Cursor from previous response"
+// @Param		archived	query		bool	false	"List archived conversations instead"
+// @Success	200	{object}	object
+// @Failure	400	{object}	map[string]string
+// @Router		/api/v1/conversations [get]
+func (h *ConversationsHandler) List(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	limit := parseCursorLimit(c, 20, 50)
+	cursor, err := parsePageCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+	archived := c.Query("archived") == "true" || c.Query("archived") == "1"
+
+	convs, err := h.conversations.ListCursor(c.Request.Context(), id, archived, limit+1, cursorTime(cursor), cursorID(cursor))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hasMore := len(convs) > limit
+	if hasMore {
+		convs = convs[:limit]
+	}
+
+	result := make([]gin.H, len(convs))
+	for i := range convs {
+		conv := &convs[i]
+		peer := toUserCardResp(&conv.Peer)
+		if p, err := h.photos.GetPrimaryByUser(c.Request.Context(), conv.Peer.ID); err == nil && p != nil {
+			peer["primary_photo_url"] = photoURL(p, h.apiBaseURL)
+		}
+		var last gin.H
+		if m := conv.LastMessage; m != nil {
+			last = gin.H{
+				"id":           m.ID,
+				"sender_id":    m.SenderID,
+				"receiver_id":  m.ReceiverID,
+				"preview":      messagePreview(m.Content, previewLength),
+				"message_type": m.MessageType,
+				"created_at":   m.CreatedAt,
+				"is_read":      m.IsRead,
+				"read_at":      m.ReadAt,
+				"edited_at":    m.EditedAt,
+				"deleted_at":   m.DeletedAt,
+			}
+		}
+		result[i] = gin.H{
+			"peer":         peer,
+			"last_message": last,
+			"unread_count": conv.UnreadCount,
+			"muted":        conv.Muted,
+			"pinned":       conv.Pinned,
+			"archived":     conv.Archived,
+			"retention":    retentionLabel(conv.Retention),
+			"activity_at":  conv.ActivityAt,
+		}
+	}
+	nextCursor := ""
+	if hasMore && len(convs) > 0 {
+		last := convs[len(convs)-1]
+		nextCursor = encodePageCursor(last.ActivityAt, last.Peer.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":       result,
+		"next_cursor": nextCursor,
+		"has_more":    hasMore,
+	})
+}
+
+type UpdateConversationReq struct {
+	Muted    *bool `json:"muted"`
+	Pinned   *bool `json:"pinned"`
+	Archived *bool `json:"archived"`
+}
+
+// Update godoc
+// @Summary	Mute, pin or archive a conversation
+// @Description	Only the fields present in the body change.
+// @Tags		chat
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		id		path		string					true	"Peer user ID (must be a match)"
+// @Param		body	body		UpdateConversationReq	true	"Flags to change"
+// @Success	200		{object}	map[string]bool
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Router		/api/v1/conversations/{id} [patch]
+func (h *ConversationsHandler) Update(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	var req UpdateConversationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Muted == nil && req.Pinned == nil && req.Archived == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
+	}
+	peerID, ok := h.conversationPeer(c, myID)
+	if !ok {
+		return
+	}
+
+	s, err := h.conversations.UpdateSettings(c.Request.Context(), myID, peerID, req.Muted, req.Pinned, req.Archived)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"muted": s.Muted, "pinned": s.Pinned, "archived": s.Archived})
+}
+
+// retentionOptions are the disappearing-message timers a conversation can
+// use, by the name clients send.
+var retentionOptions = map[string]time.Duration{
+	"off": 0,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+var retentionNames = map[string]string{
+	"24h": "24 hours",
+	"7d":  "7 days",
+	"30d": "30 days",
+}
+
+// retentionLabel names a stored retention the way clients set it.
+func retentionLabel(d time.Duration) string {
+	for label, option := range retentionOptions {
+		if option == d {
+			return label
+		}
+	}
+	return d.String()
+}
+
+type SetRetentionReq struct {
+	Retention string `json:"retention" binding:"required"`
+}
+
+// GetRetention godoc
+// @Summary	Get the disappearing-messages setting of a conversation
+// @Tags		chat
+// @Security	BearerAuth
+// @Produce	json
+// @Param		id	path		string	true	"Peer user ID (must be a match)"
+// @Success	200	{object}	object
+// @Failure	400	{object}	map[string]string
+// @Failure	403	{object}	map[string]string
+// @Router		/api/v1/conversations/{id}/retention [get]
+func (h *ConversationsHandler) GetRetention(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	peerID, ok := h.conversationPeer(c, myID)
+	if !ok {
+		return
+	}
+	retention, err := h.conversations.GetRetention(c.Request.Context(), myID, peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"retention":         retentionLabel(retention),
+		"retention_seconds": int(retention / time.Second),
+	})
+}
+
+// SetRetention godoc
+// @Summary	Turn disappearing messages on or off
+// @Description	Either participant can change it. Messages sent afterwards are deleted once the retention (24h, 7d or 30d) has passed; "off" keeps new messages. A system message in the chat and a conversation_retention event tell both participants.
+// @Tags		chat
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		id		path		string			true	"Peer user ID (must be a match)"
+// @Param		body	body		SetRetentionReq	true	"off, 24h, 7d or 30d"
+// @Success	200		{object}	object
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Router		/api/v1/conversations/{id}/retention [put]
+func (h *ConversationsHandler) SetRetention(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	var req SetRetentionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	label := strings.ToLower(strings.TrimSpace(req.Retention))
+	retention, valid := retentionOptions[label]
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retention must be off, 24h, 7d or 30d"})
+		return
+	}
+	peerID, ok := h.conversationPeer(c, myID)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	changed, err := h.conversations.SetRetention(ctx, myID, peerID, myID, retention)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data := gin.H{
+		"user_ids":          []uuid.UUID{myID, peerID},
+		"changed_by":        myID,
+		"retention":         label,
+		"retention_seconds": int(retention / time.Second),
+	}
+	if changed {
+		content := "Disappearing messages turned off"
+		if retention > 0 {
+			content = "Disappearing messages set to " + retentionNames[label]
+		}
+		m, err := h.messages.CreateSystem(ctx, myID, peerID, content, gin.H{
+			"event":             "retention_changed",
+			"retention":         label,
+			"retention_seconds": int(retention / time.Second),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if h.hub != nil {
+			msgEvent := gin.H{"type": "message", "data": messageJSON(m)}
+			retentionEvent := gin.H{"type": "conversation_retention", "data": data}
+			for _, id := range []uuid.UUID{myID, peerID} {
+				h.hub.SendToUser(id, msgEvent)
+				h.hub.SendToUser(id, retentionEvent)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// conversationPeer parses the peer in the :id param and checks that the two
+// users are matched and not blocked. It writes the error response itself.
+func (h *ConversationsHandler) conversationPeer(c *gin.Context, myID uuid.UUID) (uuid.UUID, bool) {
+	peerID, err := uuid.Parse(c.Param("id"))
+	if err != nil || peerID == myID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return uuid.Nil, false
+	}
+	ctx := c.Request.Context()
+	isMatch, err := h.likes.IsMatch(ctx, myID, peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if !isMatch {
+		c.JSON(http.StatusForbidden, gin.H{"error": "can only manage conversations with matches"})
+		return uuid.Nil, false
+	}
+	isBlocked, err := h.blocks.IsBlockedEither(ctx, myID, peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if isBlocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot manage conversation with blocked user"})
+		return uuid.Nil, false
+	}
+	return peerID, true
+}
+
+// messagePreview shortens content to at most n characters on a rune
+// boundary, collapsing line breaks so the preview fits one line.
+func messagePreview(content string, n int) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(content) <= n {
+		return content
+	}
+	runes := []rune(content)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessagePreview(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"line breaks", "see you\n\ntomorrow", 20, "see you tomorrow"},
+		{"truncated", "abcdefghij", 5, "abcd…"},
+		{"multibyte", "привет мир", 4, "при…"},
+		{"exact", "abcde", 5, "abcde"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messagePreview(tt.content, tt.n); got != tt.want {
+				t.Fatalf("messagePreview(%q, %d) = %q, want %q", tt.content, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionLabel(t *testing.T) {
+	for label, d := range retentionOptions {
+		if got := retentionLabel(d); got != label {
+			t.Errorf("retentionLabel(%v) = %q, want %q", d, got, label)
+		}
+		if d > 0 && retentionNames[label] == "" {
+			t.Errorf("retention %q has no display name", label)
+		}
+	}
+	if got := retentionLabel(90 * time.Minute); got != "1h30m0s" {
+		t.Errorf("retentionLabel(90m) = %q, want the duration", got)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"matcha/api/internal/services"
+)
+
+// ConfirmEmailChange godoc
+// @Summary	Confirm a pending email change (link sent to the new address)
+// @Tags		auth
+// @Param		token	query		string	true	"Email change confirmation token"
+// @Success	302		Redirect to frontend /profile with email_changed=1
+// @Failure	302		Redirect to frontend /profile with error param
+// @Router		/api/v1/auth/email-change/confirm [get]
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	target := h.normalizedFrontendBaseURL() + "/profile?"
+	token := c.Query("token")
+	if token == "" {
+		c.Redirect(http.StatusFound, target+"error=token_required")
+		return
+	}
+	userID, err := h.authSvc.ConfirmEmailChange(c.Request.Context(), token)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidEmailChangeToken:
+			c.Redirect(http.StatusFound, target+"error=email_change_expired")
+		case services.ErrUserExists:
+			c.Redirect(http.StatusFound, target+"error=email_taken")
+		default:
+			log.Printf("[auth] email change confirm failed: %v", err)
+			c.Redirect(http.StatusFound, target+"error=internal")
+		}
+		return
+	}
+	log.Printf("[auth] email changed: user=%s", userID)
+	if err := h.syncSvc.SyncUser(c.Request.Context(), userID); err != nil {
+		log.Printf("[auth] sync to ES failed for user=%s: %v", userID, err)
+	}
+	c.Redirect(http.StatusFound, target+"email_changed=1")
+}
+
+// CancelEmailChange godoc
+// @Summary	Cancel a pending email change and sign out everywhere (link sent to the old address)
+// @Tags		auth
+// @Param		token	query		string	true	"Email change cancel token"
+// @Success	302		Redirect to frontend /login with email_change_cancelled=1
+// @Failure	302		Redirect to frontend /login with error param
+// @Router		/api/v1/auth/email-change/cancel [get]
+func (h *AuthHandler) CancelEmailChange(c *gin.Context) {
+	target := h.normalizedFrontendBaseURL() + "/login?"
+	token := c.Query("token")
+	if token == "" {
+		c.Redirect(http.StatusFound, target+"error=token_required")
+		return
+	}
+	userID, err := h.authSvc.CancelEmailChange(c.Request.Context(), token)
+	if err != nil {
+		if err == services.ErrInvalidEmailChangeToken {
+			c.Redirect(http.StatusFound, target+"error=email_change_expired")
+			return
+		}
+		log.Printf("[auth] email change cancel failed: %v", err)
+		c.Redirect(http.StatusFound, target+"error=internal")
+		return
+	}
+	log.Printf("[auth] email change cancelled: user=%s", userID)
+	h.hub.DisconnectUser(userID, "")
+	c.Redirect(http.StatusFound, target+"email_change_cancelled=1")
+}
+
+func (h *AuthHandler) sendEmailChangeMails(change *services.EmailChangeRequest) error {
+	confirmLink := fmt.Sprintf("%s/api/v1/auth/email-change/confirm?token=%s", h.publicAPIBase, url.QueryEscape(change.ConfirmToken))
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your new Matcha email address by opening this link:\n%s\n\nThe link expires in 24 hours.\n",
+		change.User.FirstName, confirmLink)
+	if err := h.mailer.Send(change.NewEmail, "Confirm your new Matcha email", body); err != nil {
+		return err
+	}
+
+	cancelLink := fmt.Sprintf("%s/api/v1/auth/email-change/cancel?token=%s", h.publicAPIBase, url.QueryEscape(change.CancelToken))
+	body = fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email of your Matcha account to %s.\n"+
+		"If this was not you, cancel the change and sign out all sessions here:\n%s\n\nThen change your password.\n",
+		change.User.FirstName, change.NewEmail, cancelLink)
+	return h.mailer.Send(change.OldEmail, "Your Matcha email is being changed", body)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/services"
+)
+
+type ExportHandler struct {
+	exportSvc *services.ExportService
+}
+
+func NewExportHandler(exportSvc *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportSvc: exportSvc}
+}
+
+// RequestExport godoc
+// @Summary	Request a personal data export (download link is emailed)
+// @Tags		auth
+// @Security	BearerAuth
+// @Produce	json
+// @Success	202	{object}	map[string]interface{}
+// @Failure	401	{object}	map[string]string
+// @Failure	429	{object}	map[string]interface{}
+// @Router		/api/v1/auth/me/export [post]
+func (h *ExportHandler) RequestExport(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	exportID, retry, err := h.exportSvc.Start(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if retry > 0 {
+		tooManyRequests(c, retry, "an export was requested recently, try again later")
+		return
+	}
+	log.Printf("[export] requested: user=%s export=%s", id, exportID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":      exportID,
+		"status":  "pending",
+		"message": "we will email you a download link when the export is ready",
+	})
+}
+
+// Download godoc
+// @Summary	Download a personal data export via the signed link from the email
+// @Tags		auth
+// @Produce	application/zip
+// @Param		token	query		string	true	"Signed download token"
+// @Success	200	{file}	binary
+// @Failure	404	{object}	map[string]string
+// @Router		/api/v1/exports/download [get]
+func (h *ExportHandler) Download(c *gin.Context) {
+	obj, size, err := h.exportSvc.Open(c.Request.Context(), c.Query("token"))
+	if err != nil {
+		if err == services.ErrInvalidExportLink {
+			c.JSON(http.StatusNotFound, gin.H{"error": "export link is invalid or has expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	defer obj.Close()
+	filename := "matcha-export-" + time.Now().UTC().Format("2006-01-02") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Length", strconv.FormatInt(size, 10))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	if _, err := io.Copy(c.Writer, obj); err != nil {
+		log.Printf("[export] download interrupted: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/services"
+)
+
+type ICEHandler struct {
+	stunURLs   []string
+	turnURLs   []string
+	turnSecret string
+	turnTTL    time.Duration
+}
+
+func NewICEHandler(stunURLs, turnURLs []string, turnSecret string, turnTTL time.Duration) *ICEHandler {
+	return &ICEHandler{stunURLs: stunURLs, turnURLs: turnURLs, turnSecret: turnSecret, turnTTL: turnTTL}
+}
+
+// GetICEServers godoc
+// @Summary	ICE servers for WebRTC calls
+// @Description	STUN URLs plus, when a TURN server is configured, TURN credentials valid until expires_at.
+// @Tags		calls
+// @Security	BearerAuth
+// @Produce	json
+// @Success	200	{object}	object
+// @Router		/api/v1/calls/ice-servers [get]
+func (h *ICEHandler) GetICEServers(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	servers := []gin.H{}
+	if len(h.stunURLs) > 0 {
+		servers = append(servers, gin.H{"urls": h.stunURLs})
+	}
+	resp := gin.H{"ice_servers": servers}
+	if len(h.turnURLs) > 0 && h.turnSecret != "" {
+		username, credential, expiresAt := services.TURNCredentials(h.turnSecret, myID, h.turnTTL, time.Now())
+		resp["ice_servers"] = append(servers, gin.H{
+			"urls":       h.turnURLs,
+			"username":   username,
+			"credential": credential,
+		})
+		resp["ttl"] = int(h.turnTTL.Seconds())
+		resp["expires_at"] = expiresAt.UTC()
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
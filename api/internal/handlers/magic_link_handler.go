@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"matcha/api/internal/services"
+	"matcha/api/internal/validation"
+)
+
+type MagicLinkReq struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// RequestMagicLink godoc
+// @Summary	Email a single-use sign-in link (valid for 10 minutes)
+// @Tags		auth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		MagicLinkReq	true	"Account email"
+// @Success	200		{object}	map[string]string
+// @Failure	400		{object}	map[string]string
+// @Failure	429		{object}	map[string]interface{}
+// @Router		/api/v1/auth/magic-link [post]
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validation.ValidateEmail(req.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if retry, err := h.authSvc.MagicLinkRetryAfter(c.Request.Context(), req.Email, c.ClientIP()); err != nil {
+		log.Printf("[auth] magic link throttle check failed: %v", err)
+	} else if retry > 0 {
+		tooManyRequests(c, retry, "too many sign-in link requests, try again later")
+		return
+	}
+
+	token, u, err := h.authSvc.RequestMagicLink(c.Request.Context(), req.Email)
+	if err != nil {
+		log.Printf("[auth] failed creating magic link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if u != nil && token != "" {
+		link := fmt.Sprintf("%s/api/v1/auth/magic-link/verify?token=%s", h.publicAPIBase, token)
+		body := fmt.Sprintf(
+			"Hi %s,\n\nSign in to Matcha by opening this link:\n%s\n\nThe link works once and expires in %d minutes. If you did not ask for it, you can ignore this email.\n",
+			u.FirstName, link, int(services.MagicLinkTTL.Minutes()),
+		)
+		if err := h.mailer.Send(u.Email, "Your Matcha sign-in link", body); err != nil {
+			log.Printf("[auth] failed sending magic link to user=%s: %v", u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if this email exists, a sign-in link was sent"})
+}
+
+// ConsumeMagicLink godoc
+// @Summary	Sign in with an emailed link
+// @Tags		auth
+// @Param		token	query		string	true	"Sign-in link token"
+// @Success	302		Redirect to frontend /matches with JWT, or /login with mfa_token when 2FA is on
+// @Failure	302		Redirect to frontend /login with error param
+// @Router		/api/v1/auth/magic-link/verify [get]
+func (h *AuthHandler) ConsumeMagicLink(c *gin.Context) {
+	redirect := func(path, params string) {
+		h.jsRedirect(c, path, params, "Sign In", "Signing you in, please wait...")
+	}
+
+	token := c.Query("token")
+	if token == "" {
+		redirect("/login", "error=magic_link_invalid")
+		return
+	}
+	ctx := c.Request.Context()
+	u, err := h.authSvc.LoginWithMagicLink(ctx, token)
+	if err != nil {
+		if err == services.ErrInvalidMagicLink {
+			redirect("/login", "error=magic_link_invalid")
+			return
+		}
+		log.Printf("[auth] magic link login failed: %v", err)
+		redirect("/login", "error=internal")
+		return
+	}
+	if u.TOTPEnabledAt.Valid {
+		mfaToken, err := h.authSvc.StartMFAChallenge(ctx, u.ID)
+		if err != nil {
+			log.Printf("[auth] magic link ok but mfa challenge failed for user=%s: %v", u.ID, err)
+			redirect("/login", "error=internal")
+			return
+		}
+		redirect("/login", "mfa_token="+url.QueryEscape(mfaToken))
+		return
+	}
+	if _, err := h.restoreOnSignIn(ctx, u); err == services.ErrAccountPurged {
+		redirect("/login", "error=account_deleted")
+		return
+	} else if err != nil {
+		log.Printf("[auth] failed restoring account for user=%s: %v", u.ID, err)
+		redirect("/login", "error=internal")
+		return
+	}
+	tokens, err := h.issueSession(c, u.ID)
+	if err != nil {
+		log.Printf("[auth] magic link ok but token issue failed for user=%s: %v", u.ID, err)
+		redirect("/login", "error=internal")
+		return
+	}
+	log.Printf("[auth] magic link login ok: user=%s", u.ID)
+	redirect("/matches", "token="+tokens.AccessToken+"&refresh_token="+url.QueryEscape(tokens.RefreshToken))
+}
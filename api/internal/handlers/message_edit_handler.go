@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/repository"
+)
+
+type EditMessageReq struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// EditMessage godoc
+// @Summary	Edit a sent text message
+// @Description	Only the sender can edit, and only within the edit window after sending. The previous content is kept in the edit history.
+// @Tags		chat
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		id		path		string			true	"Message ID"
+// @Param		body	body		EditMessageReq	true	"New content"
+// @Success	200		{object}	object
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Failure	404		{object}	map[string]string
+// @Router		/api/v1/messages/{id} [patch]
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	var req EditMessageReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if len(content) == 0 || len(content) > 2000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content must be 1-2000 characters"})
+		return
+	}
+
+	m, ok := h.ownRecentMessage(c, myID)
+	if !ok {
+		return
+	}
+	if m.MessageType != "text" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only text messages can be edited"})
+		return
+	}
+	isBlocked, err := h.blockRepo.IsBlockedEither(c.Request.Context(), myID, m.ReceiverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if isBlocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot edit messages with blocked user"})
+		return
+	}
+
+	edited, err := h.messageRepo.Edit(c.Request.Context(), m.ID, content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if edited == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	if h.hub != nil {
+		event := gin.H{"type": "message_edited", "data": messageJSON(edited)}
+		h.hub.SendToUser(edited.SenderID, event)
+		h.hub.SendToUser(edited.ReceiverID, event)
+	}
+	c.JSON(http.StatusOK, messageJSON(edited))
+}
+
+// DeleteMessage godoc
+// @Summary	Unsend a message
+// @Description	Only the sender can unsend, and only within the edit window after sending. The message stays in the history as a tombstone without content; its attachment is deleted.
+// @Tags		chat
+// @Security	BearerAuth
+// @Param		id	path		string	true	"Message ID"
+// @Success	200	{object}	object
+// @Failure	400	{object}	map[string]string
+// @Failure	403	{object}	map[string]string
+// @Failure	404	{object}	map[string]string
+// @Router		/api/v1/messages/{id} [delete]
+func (h *ChatHandler) DeleteMessage(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	m, ok := h.ownRecentMessage(c, myID)
+	if !ok {
+		return
+	}
+	if m.MessageType == "system" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "system messages cannot be unsent"})
+		return
+	}
+
+	deleted, before, err := h.messageRepo.SoftDelete(c.Request.Context(), m.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if before.MediaKey != nil && h.store != nil {
+		if err := h.store.RemoveObject(c.Request.Context(), *before.MediaKey); err != nil {
+			log.Printf("[chat] failed removing media of message=%s: %v", deleted.ID, err)
+		}
+	}
+
+	if h.hub != nil {
+		event := gin.H{
+			"type": "message_deleted",
+			"data": gin.H{
+				"id":          deleted.ID,
+				"sender_id":   deleted.SenderID,
+				"receiver_id": deleted.ReceiverID,
+				"deleted_at":  deleted.DeletedAt,
+			},
+		}
+		h.hub.SendToUser(deleted.SenderID, event)
+		h.hub.SendToUser(deleted.ReceiverID, event)
+	}
+	c.JSON(http.StatusOK, messageJSON(deleted))
+}
+
+// ownRecentMessage loads the message in the :id param and checks that myID
+// sent it within the edit window. It writes the error response itself.
+func (h *ChatHandler) ownRecentMessage(c *gin.Context, myID uuid.UUID) (*repository.Message, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	m, err := h.messageRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if m == nil || m.DeletedAt != nil || (m.SenderID != myID && m.ReceiverID != myID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return nil, false
+	}
+	if m.SenderID != myID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the sender can change a message"})
+		return nil, false
+	}
+	if time.Since(m.CreatedAt) > h.editWindow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "message can no longer be changed"})
+		return nil, false
+	}
+	return m, true
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/validation"
+)
+
+type ReactMessageReq struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// ToggleReaction godoc
+// @Summary	React to a message
+// @Description	Adds the emoji reaction, or removes it if the user already reacted with that emoji.
+// @Tags		chat
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		id		path		string			true	"Message ID"
+// @Param		body	body		ReactMessageReq	true	"Emoji"
+// @Success	200		{object}	object
+// @Failure	400		{object}	map[string]string
+// @Failure	403		{object}	map[string]string
+// @Failure	404		{object}	map[string]string
+// @Router		/api/v1/messages/{id}/reactions [post]
+func (h *ChatHandler) ToggleReaction(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req ReactMessageReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	emoji := strings.TrimSpace(req.Emoji)
+	if err := validation.ValidateReaction(emoji); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	m, err := h.messageRepo.GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if m == nil || m.DeletedAt != nil || (m.SenderID != myID && m.ReceiverID != myID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	peerID := m.SenderID
+	if peerID == myID {
+		peerID = m.ReceiverID
+	}
+	isMatch, err := h.likeRepo.IsMatch(ctx, myID, peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !isMatch {
+		c.JSON(http.StatusForbidden, gin.H{"error": "can only react to messages with matches"})
+		return
+	}
+	isBlocked, err := h.blockRepo.IsBlockedEither(ctx, myID, peerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if isBlocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot react to messages with blocked user"})
+		return
+	}
+
+	added, err := h.messageRepo.ToggleReaction(ctx, m.ID, myID, emoji)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := gin.H{
+		"message_id": m.ID,
+		"user_id":    myID,
+		"emoji":      emoji,
+	}
+	if h.hub != nil {
+		kind := "reaction_removed"
+		if added {
+			kind = "reaction_added"
+		}
+		event := gin.H{"type": kind, "data": data}
+		h.hub.SendToUser(myID, event)
+		h.hub.SendToUser(peerID, event)
+	}
+	data["added"] = added
+	c.JSON(http.StatusOK, data)
+}
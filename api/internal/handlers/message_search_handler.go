@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/repository"
+)
+
+const maxSearchQueryLength = 200
+
+type searchSegment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// SearchMessages godoc
+// @Summary	Search my conversations
+// @Description	Full-text search over the text messages the user sent or received, newest first. Hits are grouped by conversation in the order they appear on the page; each hit carries a snippet split into plain and matching segments. Conversations with blocked users are excluded.
+// @Tags		chat
+// @Security	BearerAuth
+// @Produce	json
+// @Param		q		query		string	true	"Search terms (quotes, OR and -word are supported)"
+// @Param		limit	query		int		false	"Hits per page (default 20, max 50)"
+// @Param		cursor	query		string	false	"Cursor from previous response"
+// @Success	200		{object}	object
+// @Failure	400		{object}	map[string]string
+// @Router		/api/v1/messages/search [get]
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	myID := userID.(uuid.UUID)
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || utf8.RuneCountInString(q) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be 1-200 characters"})
+		return
+	}
+	limit := parseCursorLimit(c, 20, 50)
+	cursor, err := parsePageCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+
+	hits, err := h.messageRepo.Search(c.Request.Context(), myID, q, limit+1, cursorTime(cursor), cursorID(cursor))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hasMore := len(hits) > limit
+	if hasMore {
+		hits = hits[:limit]
+	}
+
+	items := make([]gin.H, 0)
+	groupOf := make(map[uuid.UUID]int)
+	for i := range hits {
+		hit := &hits[i]
+		msg := messageJSON(&hit.Message)
+		msg["snippet"] = highlightSegments(hit.Headline)
+		idx, ok := groupOf[hit.Peer.ID]
+		if !ok {
+			idx = len(items)
+			groupOf[hit.Peer.ID] = idx
+			items = append(items, gin.H{
+				"peer": gin.H{
+					"id":         hit.Peer.ID,
+					"username":   hit.Peer.Username,
+					"first_name": hit.Peer.FirstName,
+					"last_name":  hit.Peer.LastName,
+				},
+				"hits": []gin.H{},
+			})
+		}
+		items[idx]["hits"] = append(items[idx]["hits"].([]gin.H), msg)
+	}
+
+	nextCursor := ""
+	if hasMore && len(hits) > 0 {
+		last := hits[len(hits)-1]
+		nextCursor = encodePageCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":       items,
+		"next_cursor": nextCursor,
+		"has_more":    hasMore,
+	})
+}
+
+// highlightSegments splits a search headline on the highlight markers, so the
+// client can render the matches without interpreting the content as HTML.
+func highlightSegments(headline string) []searchSegment {
+	segments := make([]searchSegment, 0)
+	match := false
+	for headline != "" {
+		marker := repository.HighlightStart
+		if match {
+			marker = repository.HighlightStop
+		}
+		text, rest, found := strings.Cut(headline, marker)
+		// A stray marker of the other kind carries no meaning on its own.
+		text = strings.NewReplacer(repository.HighlightStart, "", repository.HighlightStop, "").Replace(text)
+		if text != "" {
+			if n := len(segments); n > 0 && segments[n-1].Match == match {
+				segments[n-1].Text += text
+			} else {
+				segments = append(segments, searchSegment{Text: text, Match: match})
+			}
+		}
+		if !found {
+			break
+		}
+		headline = rest
+		match = !match
+	}
+	return segments
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"matcha/api/internal/repository"
+)
+
+func TestHighlightSegments(t *testing.T) {
+	const on, off = repository.HighlightStart, repository.HighlightStop
+	tests := []struct {
+		name     string
+		headline string
+		want     []searchSegment
+	}{
+		{"empty", "", []searchSegment{}},
+		{"no match", "see you tomorrow", []searchSegment{{Text: "see you tomorrow"}}},
+		{
+			"matches",
+			"dinner at " + on + "eight" + off + " or " + on + "nine" + off,
+			[]searchSegment{
+				{Text: "dinner at "},
+				{Text: "eight", Match: true},
+				{Text: " or "},
+				{Text: "nine", Match: true},
+			},
+		},
+		{"leading match", on + "pizza" + off + "!", []searchSegment{{Text: "pizza", Match: true}, {Text: "!"}}},
+		{"unterminated", "a " + on + "b", []searchSegment{{Text: "a "}, {Text: "b", Match: true}}},
+		{"adjacent matches merge", on + "a" + off + on + "b" + off, []searchSegment{{Text: "ab", Match: true}}},
+		{"stray stop", "a" + off + "b", []searchSegment{{Text: "ab"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := highlightSegments(tt.headline)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("highlightSegments(%q) = %#v, want %#v", tt.headline, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -67,37 +70,13 @@ func (h *PhotoHandler) UploadMe(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
 		return
 	}
-	if fh.Size <= 0 || fh.Size > 10*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file size must be 1B..10MB"})
-		return
-	}
-	contentType := strings.TrimSpace(fh.Header.Get("Content-Type"))
-	ext := strings.ToLower(filepath.Ext(strings.TrimSpace(fh.Filename)))
-	if !allowedPhotoExtensions[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed file extensions: .jpg, .jpeg, .png, .webp"})
-		return
-	}
-
-	file, err := fh.Open()
+	reader, file, detectedType, err := openImageUpload(fh, allowedPhotoExtensions, allowedPhotoContentTypes)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to open file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	defer file.Close()
 
-	head := make([]byte, 512)
-	n, _ := io.ReadFull(file, head)
-	detectedType := http.DetectContentType(head[:n])
-	if !allowedPhotoContentTypes[detectedType] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed file types: image/jpeg, image/png, image/webp"})
-		return
-	}
-	if contentType != "" && !allowedPhotoContentTypes[contentType] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid content-type header"})
-		return
-	}
-
-	reader := io.MultiReader(bytes.NewReader(head[:n]), file)
 	objectKey := storage.BuildPhotoObjectKey(id.String(), uuid.NewString(), fh.Filename)
 	url, err := h.store.PutObject(c.Request.Context(), objectKey, reader, fh.Size, detectedType)
 	if err != nil {
@@ -281,6 +260,49 @@ func (h *PhotoHandler) ServePhoto(c *gin.Context) {
 	io.Copy(c.Writer, obj)
 }
 
+const maxImageUploadBytes = 10 * 1024 * 1024
+
+// openImageUpload applies the upload checks shared by profile photos and chat
+// images: size cap, extension, sniffed content type and a consistent
+// Content-Type header. The returned reader yields the whole file; the
+// returned error is meant for the client.
+func openImageUpload(fh *multipart.FileHeader, extensions, contentTypes map[string]bool) (io.Reader, multipart.File, string, error) {
+	if fh.Size <= 0 || fh.Size > maxImageUploadBytes {
+		return nil, nil, "", errors.New("file size must be 1B..10MB")
+	}
+	contentType := strings.TrimSpace(fh.Header.Get("Content-Type"))
+	ext := strings.ToLower(filepath.Ext(strings.TrimSpace(fh.Filename)))
+	if !extensions[ext] {
+		return nil, nil, "", errors.New("allowed file extensions: " + allowedList(extensions))
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return nil, nil, "", errors.New("failed to open file")
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	detectedType := http.DetectContentType(head[:n])
+	if !contentTypes[detectedType] {
+		file.Close()
+		return nil, nil, "", errors.New("allowed file types: " + allowedList(contentTypes))
+	}
+	if contentType != "" && !contentTypes[contentType] {
+		file.Close()
+		return nil, nil, "", errors.New("invalid content-type header")
+	}
+	return io.MultiReader(bytes.NewReader(head[:n]), file), file, detectedType, nil
+}
+
+func allowedList(allowed map[string]bool) string {
+	items := make([]string, 0, len(allowed))
+	for k := range allowed {
+		items = append(items, k)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
+
 func photoURL(p *repository.Photo, _ string) string {
 	return p.URL
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+	"matcha/api/internal/services"
+)
+
+type TOTPCodeReq struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTOTPReq struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type VerifyMFAReq struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// EnrollTOTP godoc
+// @Summary	Start two-factor enrollment (returns secret and otpauth URI)
+// @Tags		auth
+// @Security	BearerAuth
+// @Produce	json
+// @Success	200	{object}	map[string]interface{}
+// @Failure	401	{object}	map[string]string
+// @Failure	409	{object}	map[string]string
+// @Router		/api/v1/auth/2fa/enroll [post]
+func (h *AuthHandler) EnrollTOTP(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	enrollment, err := h.authSvc.BeginTOTPEnrollment(c.Request.Context(), id)
+	if err != nil {
+		if err == services.ErrTOTPAlreadyEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication already enabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.ProvisioningURI,
+	})
+}
+
+// ConfirmTOTP godoc
+// @Summary	Confirm two-factor enrollment with a code from the authenticator app
+// @Tags		auth
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		TOTPCodeReq	true	"Current TOTP code"
+// @Success	200	{object}	map[string]interface{}
+// @Failure	400	{object}	map[string]string
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/2fa/confirm [post]
+func (h *AuthHandler) ConfirmTOTP(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	var req TOTPCodeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, err := h.authSvc.ConfirmTOTPEnrollment(c.Request.Context(), id, req.Code)
+	if err != nil {
+		switch err {
+		case services.ErrTOTPEnrollmentNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no pending enrollment, start again"})
+		case services.ErrInvalidTOTPCode:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	log.Printf("[auth] 2fa enabled: user=%s", id)
+	c.JSON(http.StatusOK, gin.H{"ok": true, "recovery_codes": codes})
+}
+
+// DisableTOTP godoc
+// @Summary	Disable two-factor authentication
+// @Tags		auth
+// @Security	BearerAuth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		DisableTOTPReq	true	"Password and TOTP or recovery code"
+// @Success	200	{object}	map[string]interface{}
+// @Failure	400	{object}	map[string]string
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/2fa/disable [post]
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	userID, _ := c.Get(middleware.UserIDKey)
+	id := userID.(uuid.UUID)
+
+	var req DisableTOTPReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.authSvc.DisableTOTP(c.Request.Context(), id, req.Password, req.Code); err != nil {
+		switch err {
+		case services.ErrTOTPNotEnabled:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication not enabled"})
+		case services.ErrInvalidPassword:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		case services.ErrInvalidTOTPCode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	log.Printf("[auth] 2fa disabled: user=%s", id)
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// VerifyMFA godoc
+// @Summary	Finish a two-factor login with a TOTP or recovery code
+// @Tags		auth
+// @Accept		json
+// @Produce	json
+// @Param		body	body		VerifyMFAReq	true	"MFA token from login and code"
+// @Success	200	{object}	map[string]interface{}
+// @Failure	400	{object}	map[string]string
+// @Failure	401	{object}	map[string]string
+// @Router		/api/v1/auth/2fa/verify [post]
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req VerifyMFAReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	u, err := h.authSvc.CompleteMFAChallenge(c.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidMFAToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		case services.ErrInvalidTOTPCode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		default:
+			log.Printf("[auth] mfa verify failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		}
+		return
+	}
+	h.respondWithSession(c, u)
+}
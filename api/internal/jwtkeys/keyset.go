@@ -0,0 +1,211 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSABits = 2048
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet signs access tokens with one active key and verifies tokens signed
+// by any loaded key, so a new key can be rolled out before it becomes active
+// and an old one kept around until its tokens expire.
+type KeySet struct {
+	active *key
+	keys   map[string]*key
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadDir reads every *.pem file in dir. The file name without extension is
+// the kid; "<kid>.pub.pem" files hold verification-only public keys of
+// retired signing keys. activeKID may be empty when exactly one private key
+// is present.
+func LoadDir(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{keys: make(map[string]*key)}
+	var signers []string
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(p), ".pem"), ".pub")
+		k, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", filepath.Base(p), err)
+		}
+		if _, dup := ks.keys[kid]; dup {
+			return nil, fmt.Errorf("jwt key %s: duplicate kid", kid)
+		}
+		ks.keys[kid] = k
+		if k.private != nil {
+			signers = append(signers, kid)
+		}
+	}
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("no jwt keys found in %s", dir)
+	}
+
+	if activeKID == "" {
+		if len(signers) != 1 {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID must be set when %d private keys are present", len(signers))
+		}
+		activeKID = signers[0]
+	}
+	active, ok := ks.keys[activeKID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("active jwt key %q has no private key in %s", activeKID, dir)
+	}
+	ks.active = active
+	return ks, nil
+}
+
+// Ephemeral returns a key set with a fresh in-memory Ed25519 key. Tokens do
+// not survive a restart and are not shared between replicas; meant for local
+// development only.
+func Ephemeral() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k := &key{id: "dev-" + base64.RawURLEncoding.EncodeToString(pub[:6]), method: jwt.SigningMethodEdDSA, private: priv, public: pub}
+	return &KeySet{active: k, keys: map[string]*key{k.id: k}}, nil
+}
+
+func (ks *KeySet) ActiveKID() string {
+	return ks.active.id
+}
+
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(ks.active.method, claims)
+	t.Header["kid"] = ks.active.id
+	return t.SignedString(ks.active.private)
+}
+
+// Parse verifies token against the key named by its kid header. The alg
+// header must match the algorithm of that key.
+func (ks *KeySet) Parse(token string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append([]jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	}, opts...)
+	return jwt.ParseWithClaims(token, claims, ks.keyFunc, opts...)
+}
+
+func (ks *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("alg %s does not match key %s", t.Method.Alg(), kid)
+	}
+	return k.public, nil
+}
+
+func (ks *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := JWKS{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		k := ks.keys[id]
+		jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out
+}
+
+func parseKey(kid string, data []byte) (*key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &key{id: kid}
+	switch v := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, v, v.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, v
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, v, &v.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, v
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (want RSA or Ed25519)", parsed)
+	}
+	if pub, ok := k.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+	}
+	return k, nil
+}
@@ -0,0 +1,121 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "u1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestLoadDirRotation(t *testing.T) {
+	dir := t.TempDir()
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edPriv)
+	writePEM(t, dir, "2024-ed.pem", "PRIVATE KEY", edDER)
+
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, "2023-rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPriv))
+
+	if _, err := LoadDir(dir, ""); err == nil {
+		t.Fatalf("expected error without active kid and two private keys")
+	}
+
+	old, err := LoadDir(dir, "2023-rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := old.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := LoadDir(dir, "2024-ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := cur.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tok := range map[string]string{"old": oldToken, "new": newToken} {
+		claims := &jwt.RegisteredClaims{}
+		if _, err := cur.Parse(tok, claims); err != nil {
+			t.Errorf("%s token should verify after rotation: %v", name, err)
+		}
+	}
+
+	jwks := cur.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyType != "RSA" || jwks.Keys[1].Curve != "Ed25519" {
+		t.Fatalf("unexpected jwks: %+v", jwks)
+	}
+}
+
+func TestPublicOnlyKeyVerifies(t *testing.T) {
+	signer, err := Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := signer.Sign(testClaims())
+
+	dir := t.TempDir()
+	pubDER, _ := x509.MarshalPKIXPublicKey(signer.active.public)
+	writePEM(t, dir, signer.ActiveKID()+".pub.pem", "PUBLIC KEY", pubDER)
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edPriv)
+	writePEM(t, dir, "next.pem", "PRIVATE KEY", edDER)
+
+	ks, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.ActiveKID() != "next" {
+		t.Fatalf("expected the only private key to be active, got %q", ks.ActiveKID())
+	}
+	if _, err := ks.Parse(tok, &jwt.RegisteredClaims{}); err != nil {
+		t.Fatalf("token of retired key should still verify: %v", err)
+	}
+}
+
+func TestParseRejectsForeignTokens(t *testing.T) {
+	ks, _ := Ephemeral()
+
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	hs.Header["kid"] = ks.ActiveKID()
+	hsToken, _ := hs.SignedString([]byte("shared-secret"))
+	if _, err := ks.Parse(hsToken, &jwt.RegisteredClaims{}); err == nil {
+		t.Errorf("expected HS256 token to be rejected")
+	}
+
+	other, _ := Ephemeral()
+	otherToken, _ := other.Sign(testClaims())
+	if _, err := ks.Parse(otherToken, &jwt.RegisteredClaims{}); err == nil {
+		t.Errorf("expected token with unknown kid to be rejected")
+	}
+
+	noExp, _ := ks.Sign(jwt.RegisteredClaims{Subject: "u1"})
+	if _, err := ks.Parse(noExp, &jwt.RegisteredClaims{}); err == nil {
+		t.Errorf("expected token without exp to be rejected")
+	}
+}
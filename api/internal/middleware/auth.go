@@ -8,16 +8,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"matcha/api/internal/jwtkeys"
+	"matcha/api/internal/store"
 )
 
-const UserIDKey = "user_id"
+const (
+	UserIDKey    = "user_id"
+	SessionIDKey = "session_id"
+)
 
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
 	jwt.RegisteredClaims
 }
 
-func Auth(jwtSecret string) gin.HandlerFunc {
+func Auth(keys *jwtkeys.KeySet, sessions *store.TokenStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := extractToken(c)
 		if token == "" {
@@ -28,9 +33,7 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 		}
 
 		claims := &Claims{}
-		t, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
+		t, err := keys.Parse(token, claims)
 		if err != nil {
 			log.Printf("[auth] %s %s: token parse error: %v", c.Request.Method, c.Request.URL.Path, err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
@@ -44,7 +47,28 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		if claims.ID == "" {
+			log.Printf("[auth] %s %s: token has no session id", c.Request.Method, c.Request.URL.Path)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			c.Abort()
+			return
+		}
+		active, err := sessions.TouchSession(c.Request.Context(), claims.ID, c.ClientIP())
+		if err != nil {
+			log.Printf("[auth] %s %s: session lookup failed: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			c.Abort()
+			return
+		}
+		if !active {
+			log.Printf("[auth] %s %s: session %s revoked", c.Request.Method, c.Request.URL.Path, claims.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set(UserIDKey, claims.UserID)
+		c.Set(SessionIDKey, claims.ID)
 		c.Next()
 	}
 }
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Call statuses recorded in call history.
+const (
+	CallCompleted = "completed"
+	CallMissed    = "missed"
+	CallRejected  = "rejected"
+	CallCancelled = "cancelled"
+	CallBusy      = "busy"
+)
+
+// Call is a finished call. CallID is the id the clients used for signaling.
+type Call struct {
+	ID         uuid.UUID
+	CallID     string
+	CallerID   uuid.UUID
+	CalleeID   uuid.UUID
+	Mode       string
+	Status     string
+	StartedAt  time.Time
+	AnsweredAt *time.Time
+	EndedAt    time.Time
+}
+
+type CallRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewCallRepository(pool *pgxpool.Pool) *CallRepository {
+	return &CallRepository{pool: pool}
+}
+
+func (r *CallRepository) Create(ctx context.Context, c *Call) error {
+	return r.pool.QueryRow(ctx, `
+		INSERT INTO calls (call_id, caller_id, callee_id, mode, status, started_at, answered_at, ended_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, c.CallID, c.CallerID, c.CalleeID, c.Mode, c.Status, c.StartedAt, c.AnsweredAt, c.EndedAt).Scan(&c.ID)
+}
+
+// ListBetween returns the calls between two users, newest first.
+func (r *CallRepository) ListBetween(ctx context.Context, a, b uuid.UUID, limit, offset int) ([]Call, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, call_id, caller_id, callee_id, mode, status, started_at, answered_at, ended_at
+		FROM calls
+		WHERE (caller_id = $1 AND callee_id = $2) OR (caller_id = $2 AND callee_id = $1)
+		ORDER BY started_at DESC
+		LIMIT $3 OFFSET $4
+	`, a, b, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Call
+	for rows.Next() {
+		var c Call
+		if err := rows.Scan(&c.ID, &c.CallID, &c.CallerID, &c.CalleeID, &c.Mode, &c.Status, &c.StartedAt, &c.AnsweredAt, &c.EndedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
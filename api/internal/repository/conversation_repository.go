@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Conversation is one entry of a user's inbox: a match and the latest state
+// of the chat with them.
+type Conversation struct {
+	Peer        UserCard
+	LastMessage *Message
+	UnreadCount int
+	Muted       bool
+	Pinned      bool
+	Archived    bool
+	// Retention is how long new messages last; zero keeps them.
+	Retention time.Duration
+	// ActivityAt is the time of the last message, or of the match when
+	// nothing was sent yet; the inbox is ordered by it.
+	ActivityAt time.Time
+}
+
+type ConversationSettings struct {
+	Muted    bool
+	Pinned   bool
+	Archived bool
+}
+
+type ConversationRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewConversationRepository(pool *pgxpool.Pool) *ConversationRepository {
+	return &ConversationRepository{pool: pool}
+}
+
+// ListCursor returns the user's conversations (archived or not), most recent
+// activity first. Users blocked in either direction, and accounts pending
+// deletion, are left out.
+func (r *ConversationRepository) ListCursor(ctx context.Context, userID uuid.UUID, archived bool, limit int, cursorTime *time.Time, cursorID *uuid.UUID) ([]Conversation, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH peers AS (
+			SELECT l1.liked_user_id AS peer_id, GREATEST(l1.created_at, l2.created_at) AS matched_at
+			FROM likes l1
+			JOIN likes l2 ON l1.user_id = l2.liked_user_id AND l1.liked_user_id = l2.user_id
+			JOIN users pu ON pu.id = l1.liked_user_id
+			WHERE l1.user_id = $1
+			  AND pu.deletion_requested_at IS NULL
+			  AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_user_id = $1 AND b.blocked_user_id = l1.liked_user_id)
+				   OR (b.blocker_user_id = l1.liked_user_id AND b.blocked_user_id = $1)
+			  )
+		), conv AS (
+			SELECT pe.peer_id, lm.id AS last_id, lm.sender_id, lm.receiver_id, lm.content,
+			       lm.message_type, lm.media_url, lm.created_at AS last_at, lm.is_read, lm.read_at,
+			       lm.edited_at, lm.deleted_at,
+			       COALESCE(lm.created_at, pe.matched_at) AS activity_at
+			FROM peers pe
+			LEFT JOIN LATERAL (
+				SELECT m.id, m.sender_id, m.receiver_id, m.content, m.message_type, m.media_url,
+				       m.created_at, m.is_read, m.read_at, m.edited_at, m.deleted_at
+				FROM messages m
+				WHERE ((m.sender_id = $1 AND m.receiver_id = pe.peer_id)
+				   OR (m.sender_id = pe.peer_id AND m.receiver_id = $1))
+				  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+				ORDER BY m.created_at DESC, m.id DESC
+				LIMIT 1
+			) lm ON TRUE
+		)
+		SELECT u.id, u.username, u.first_name, u.last_name,
+		       p.gender, p.birth_date, p.bio, p.fame_rating, p.latitude, p.longitude,
+		       c.last_id, c.sender_id, c.receiver_id, c.content, c.message_type, c.media_url,
+		       c.last_at, c.is_read, c.read_at, c.edited_at, c.deleted_at, c.activity_at,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.receiver_id = $1 AND m.is_read = FALSE AND m.sender_id = c.peer_id
+		          AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > NOW())) AS unread,
+		       COALESCE(s.muted, FALSE), COALESCE(s.pinned, FALSE), COALESCE(s.archived, FALSE),
+		       COALESCE(r.retention_seconds, 0)
+		FROM conv c
+		JOIN users u ON u.id = c.peer_id
+		LEFT JOIN profiles p ON p.user_id = u.id
+		LEFT JOIN conversation_settings s ON s.user_id = $1 AND s.peer_id = c.peer_id
+		LEFT JOIN conversation_retention r
+		       ON r.user_a_id = LEAST($1::uuid, c.peer_id) AND r.user_b_id = GREATEST($1::uuid, c.peer_id)
+		WHERE COALESCE(s.archived, FALSE) = $3
+		  AND (
+		    $4::timestamptz IS NULL
+		    OR c.activity_at < $4
+		    OR (c.activity_at = $4 AND c.peer_id < $5)
+		  )
+		ORDER BY c.activity_at DESC, c.peer_id DESC
+		LIMIT $2
+	`, userID, limit, archived, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Conversation
+	for rows.Next() {
+		var c Conversation
+		var gender, bio sql.NullString
+		var birthDate sql.NullTime
+		var fameRating sql.NullInt64
+		var lat, lon sql.NullFloat64
+		var lastID, senderID, receiverID *uuid.UUID
+		var content, messageType *string
+		var lastAt *time.Time
+		var isRead *bool
+		var retentionSeconds int
+		var m Message
+
+		err := rows.Scan(
+			&c.Peer.ID, &c.Peer.Username, &c.Peer.FirstName, &c.Peer.LastName,
+			&gender, &birthDate, &bio, &fameRating, &lat, &lon,
+			&lastID, &senderID, &receiverID, &content, &messageType, &m.MediaURL,
+			&lastAt, &isRead, &m.ReadAt, &m.EditedAt, &m.DeletedAt, &c.ActivityAt,
+			&c.UnreadCount, &c.Muted, &c.Pinned, &c.Archived, &retentionSeconds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.Retention = time.Duration(retentionSeconds) * time.Second
+		if gender.Valid {
+			c.Peer.Gender = &gender.String
+		}
+		if birthDate.Valid {
+			c.Peer.BirthDate = &birthDate.Time
+		}
+		if bio.Valid {
+			c.Peer.Bio = &bio.String
+		}
+		if fameRating.Valid {
+			c.Peer.FameRating = int(fameRating.Int64)
+		}
+		if lat.Valid {
+			c.Peer.Latitude = &lat.Float64
+		}
+		if lon.Valid {
+			c.Peer.Longitude = &lon.Float64
+		}
+		if lastID != nil {
+			m.ID = *lastID
+			m.SenderID = *senderID
+			m.ReceiverID = *receiverID
+			m.Content = *content
+			m.MessageType = *messageType
+			m.CreatedAt = *lastAt
+			m.IsRead = *isRead
+			c.LastMessage = &m
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// UpdateSettings changes the given flags of the user's conversation with
+// peerID (nil leaves a flag as it is) and returns the resulting settings.
+func (r *ConversationRepository) UpdateSettings(ctx context.Context, userID, peerID uuid.UUID, muted, pinned, archived *bool) (*ConversationSettings, error) {
+	var s ConversationSettings
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO conversation_settings (user_id, peer_id, muted, pinned, archived)
+		VALUES ($1, $2, COALESCE($3, FALSE), COALESCE($4, FALSE), COALESCE($5, FALSE))
+		ON CONFLICT (user_id, peer_id) DO UPDATE SET
+			muted = COALESCE($3, conversation_settings.muted),
+			pinned = COALESCE($4, conversation_settings.pinned),
+			archived = COALESCE($5, conversation_settings.archived),
+			updated_at = NOW()
+		RETURNING muted, pinned, archived
+	`, userID, peerID, muted, pinned, archived).Scan(&s.Muted, &s.Pinned, &s.Archived)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// orderedPair returns a and b as stored in conversation_retention.
+func orderedPair(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		return b, a
+	}
+	return a, b
+}
+
+// GetRetention returns how long new messages between a and b last, or zero
+// when disappearing messages are off.
+func (r *ConversationRepository) GetRetention(ctx context.Context, a, b uuid.UUID) (time.Duration, error) {
+	a, b = orderedPair(a, b)
+	var seconds int
+	err := r.pool.QueryRow(ctx, `
+		SELECT retention_seconds FROM conversation_retention
+		WHERE user_a_id = $1 AND user_b_id = $2
+	`, a, b).Scan(&seconds)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// SetRetention sets how long new messages between a and b last; zero turns
+// disappearing messages off. It reports whether the setting changed.
+func (r *ConversationRepository) SetRetention(ctx context.Context, a, b, changedBy uuid.UUID, retention time.Duration) (bool, error) {
+	a, b = orderedPair(a, b)
+	if retention <= 0 {
+		res, err := r.pool.Exec(ctx, `
+			DELETE FROM conversation_retention WHERE user_a_id = $1 AND user_b_id = $2
+		`, a, b)
+		if err != nil {
+			return false, err
+		}
+		return res.RowsAffected() > 0, nil
+	}
+	res, err := r.pool.Exec(ctx, `
+		INSERT INTO conversation_retention (user_a_id, user_b_id, retention_seconds, updated_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_a_id, user_b_id) DO UPDATE SET
+			retention_seconds = EXCLUDED.retention_seconds,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		WHERE conversation_retention.retention_seconds <> EXCLUDED.retention_seconds
+	`, a, b, int(retention/time.Second), changedBy)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Each user keeps at most EventLogLimit events, none older than
+// EventLogRetention; a client that fell further behind has to resync over
+// REST.
+const (
+	EventLogLimit     = 1000
+	EventLogRetention = 7 * 24 * time.Hour
+)
+
+type UserEvent struct {
+	Seq       int64
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+type EventRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewEventRepository(pool *pgxpool.Pool) *EventRepository {
+	return &EventRepository{pool: pool}
+}
+
+// Append stores an event under the user's next sequence number and trims
+// the log. Bumping the counter row serializes appends per user, so sequence
+// numbers are gap-free and ordered by commit.
+func (r *EventRepository) Append(ctx context.Context, userID uuid.UUID, eventType string, payload []byte) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var seq int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO user_event_seqs (user_id, last_seq)
+		VALUES ($1, 1)
+		ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_seqs.last_seq + 1
+		RETURNING last_seq
+	`, userID).Scan(&seq); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO user_events (user_id, seq, type, payload)
+		VALUES ($1, $2, $3, $4)
+	`, userID, seq, eventType, payload); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM user_events
+		WHERE user_id = $1
+		  AND (seq <= $2 OR created_at < $3)
+	`, userID, seq-EventLogLimit, time.Now().Add(-EventLogRetention)); err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit(ctx)
+}
+
+// TrimExpired deletes events older than EventLogRetention for all users.
+// Append only trims the log it writes to, so this catches users who stopped
+// receiving events.
+func (r *EventRepository) TrimExpired(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM user_events WHERE created_at < $1
+	`, time.Now().Add(-EventLogRetention))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// LastSeq returns the newest sequence number issued to the user (0 if none).
+func (r *EventRepository) LastSeq(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var seq int64
+	err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE((SELECT last_seq FROM user_event_seqs WHERE user_id = $1), 0)
+	`, userID).Scan(&seq)
+	return seq, err
+}
+
+// ListSince returns up to limit events with seq > after, oldest first, and
+// the oldest seq still in the log (0 if the log is empty) so callers can tell
+// whether events between after and the first returned one were trimmed.
+func (r *EventRepository) ListSince(ctx context.Context, userID uuid.UUID, after int64, limit int) ([]UserEvent, int64, error) {
+	var oldest int64
+	if err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(MIN(seq), 0) FROM user_events WHERE user_id = $1
+	`, userID).Scan(&oldest); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT seq, type, payload, created_at
+		FROM user_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, userID, after, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []UserEvent
+	for rows.Next() {
+		var e UserEvent
+		if err := rows.Scan(&e.Seq, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, e)
+	}
+	return out, oldest, rows.Err()
+}
+
+// patchMessageEvents merges fields into the data of every logged event
+// about message m (new message, edits, processed media) for both
+// participants, so a replay never brings back what an edit or unsend
+// removed. It runs in the caller's transaction.
+func patchMessageEvents(ctx context.Context, tx pgx.Tx, m *Message, fields map[string]any) error {
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE user_events
+		SET payload = jsonb_set(payload, '{data}', (payload->'data') || $4::jsonb)
+		WHERE user_id IN ($1, $2)
+		  AND jsonb_typeof(payload->'data') = 'object'
+		  AND payload->'data'->>'id' = $3
+	`, m.SenderID, m.ReceiverID, m.ID.String(), string(patch))
+	return err
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ExportSection is one JSON document of a personal data export.
+type ExportSection struct {
+	Name string
+	Data json.RawMessage
+}
+
+// exportQueries select everything stored about $1. Secrets (password hash,
+// TOTP secret, recovery codes) are deliberately left out.
+var exportQueries = []struct {
+	name  string
+	query string
+}{
+	{"account", `
+		SELECT id, username, email, first_name, last_name, email_verified_at, created_at,
+		       totp_enabled_at IS NOT NULL AS two_factor_enabled
+		FROM users WHERE id = $1`},
+	{"profile", `SELECT * FROM profiles WHERE user_id = $1`},
+	{"tags", `
+		SELECT t.name, ut.created_at
+		FROM user_tags ut JOIN tags t ON t.id = ut.tag_id
+		WHERE ut.user_id = $1
+		ORDER BY t.name`},
+	{"photos", `SELECT * FROM user_photos WHERE user_id = $1 ORDER BY position, created_at`},
+	{"likes_given", `
+		SELECT l.liked_user_id AS user_id, u.username, l.created_at
+		FROM likes l JOIN users u ON u.id = l.liked_user_id
+		WHERE l.user_id = $1
+		ORDER BY l.created_at`},
+	{"likes_received", `
+		SELECT l.user_id, u.username, l.created_at
+		FROM likes l JOIN users u ON u.id = l.user_id
+		WHERE l.liked_user_id = $1
+		ORDER BY l.created_at`},
+	{"matches", `
+		SELECT a.liked_user_id AS user_id, u.username, GREATEST(a.created_at, b.created_at) AS matched_at
+		FROM likes a
+		JOIN likes b ON b.user_id = a.liked_user_id AND b.liked_user_id = a.user_id
+		JOIN users u ON u.id = a.liked_user_id
+		WHERE a.user_id = $1
+		ORDER BY matched_at`},
+	{"messages", `
+		SELECT * FROM messages
+		WHERE sender_id = $1 OR receiver_id = $1
+		ORDER BY created_at, id`},
+	{"message_edits", `
+		SELECT e.message_id, e.previous_content, e.edited_at
+		FROM message_edits e JOIN messages m ON m.id = e.message_id
+		WHERE m.sender_id = $1
+		ORDER BY e.edited_at`},
+	{"message_reactions", `
+		SELECT message_id, emoji, created_at
+		FROM message_reactions WHERE user_id = $1
+		ORDER BY created_at`},
+	{"disappearing_messages", `
+		SELECT CASE WHEN r.user_a_id = $1 THEN r.user_b_id ELSE r.user_a_id END AS user_id,
+		       u.username, r.retention_seconds, r.updated_by = $1 AS set_by_me, r.updated_at
+		FROM conversation_retention r
+		JOIN users u ON u.id = CASE WHEN r.user_a_id = $1 THEN r.user_b_id ELSE r.user_a_id END
+		WHERE r.user_a_id = $1 OR r.user_b_id = $1
+		ORDER BY r.updated_at`},
+	{"notifications", `SELECT * FROM notifications WHERE user_id = $1 ORDER BY created_at`},
+	{"reports_filed", `
+		SELECT id, target_user_id, reason, comment, status, created_at, updated_at
+		FROM user_reports WHERE reporter_user_id = $1
+		ORDER BY created_at`},
+	{"blocks", `
+		SELECT b.blocked_user_id AS user_id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_user_id
+		WHERE b.blocker_user_id = $1
+		ORDER BY b.created_at`},
+	{"profile_views_by_me", `
+		SELECT v.viewed_user_id AS user_id, u.username, v.created_at
+		FROM profile_views v JOIN users u ON u.id = v.viewed_user_id
+		WHERE v.viewer_user_id = $1
+		ORDER BY v.created_at`},
+	{"profile_views_of_me", `
+		SELECT v.viewer_user_id AS user_id, u.username, v.created_at
+		FROM profile_views v JOIN users u ON u.id = v.viewer_user_id
+		WHERE v.viewed_user_id = $1
+		ORDER BY v.created_at`},
+	{"last_seen", `SELECT * FROM user_presence WHERE user_id = $1`},
+}
+
+func NewExportRepository(pool *pgxpool.Pool) *ExportRepository {
+	return &ExportRepository{pool: pool}
+}
+
+type ExportRepository struct {
+	pool *pgxpool.Pool
+}
+
+// CollectUserData runs every export query inside one read-only snapshot so
+// the sections are consistent with each other.
+func (r *ExportRepository) CollectUserData(ctx context.Context, userID uuid.UUID) ([]ExportSection, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`); err != nil {
+		return nil, err
+	}
+
+	sections := make([]ExportSection, 0, len(exportQueries))
+	for _, q := range exportQueries {
+		var data []byte
+		err := tx.QueryRow(ctx, `SELECT COALESCE(json_agg(row_to_json(t)), '[]'::json) FROM (`+q.query+`) t`, userID).Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, ExportSection{Name: q.name, Data: data})
+	}
+	return sections, nil
+}
@@ -53,11 +53,47 @@ func (r *LikeRepository) IsMatch(ctx context.Context, userID, otherUserID uuid.U
 			SELECT 1 FROM likes l1
 			JOIN likes l2 ON l1.user_id = l2.liked_user_id AND l1.liked_user_id = l2.user_id
 			WHERE l1.user_id = $1 AND l1.liked_user_id = $2
+			  AND NOT EXISTS (
+			    SELECT 1 FROM users u
+			    WHERE u.id IN ($1, $2) AND u.deletion_requested_at IS NOT NULL
+			  )
 		)
 	`, userID, otherUserID).Scan(&exists)
 	return exists, err
 }
 
+// ListMatchIDs returns the IDs of all of the user's matches, leaving out
+// anyone blocked in either direction.
+func (r *LikeRepository) ListMatchIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT l1.liked_user_id
+		FROM likes l1
+		JOIN likes l2 ON l1.user_id = l2.liked_user_id AND l1.liked_user_id = l2.user_id
+		JOIN users u ON u.id = l1.liked_user_id
+		WHERE l1.user_id = $1
+		  AND u.deletion_requested_at IS NULL
+		  AND NOT EXISTS (
+		    SELECT 1 FROM user_blocks b
+		    WHERE (b.blocker_user_id = $1 AND b.blocked_user_id = l1.liked_user_id)
+		       OR (b.blocker_user_id = l1.liked_user_id AND b.blocked_user_id = $1)
+		  )
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func (r *LikeRepository) GetLikedByMe(ctx context.Context, userID uuid.UUID, excludeIDs []uuid.UUID, limit, offset int) ([]UserCard, error) {
 	return r.getUserCardsFromLikes(ctx, `
 		SELECT u.id, u.username, u.first_name, u.last_name,
@@ -67,6 +103,7 @@ func (r *LikeRepository) GetLikedByMe(ctx context.Context, userID uuid.UUID, exc
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l.user_id = $1
 		  AND NOT (u.id = ANY($4::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		  AND NOT EXISTS (
 		    SELECT 1 FROM likes l2
 		    WHERE l2.user_id = l.liked_user_id AND l2.liked_user_id = l.user_id
@@ -85,6 +122,7 @@ func (r *LikeRepository) GetLikedMe(ctx context.Context, userID uuid.UUID, exclu
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l.liked_user_id = $1
 		  AND NOT (u.id = ANY($4::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		  AND NOT EXISTS (
 		    SELECT 1 FROM likes l2
 		    WHERE l2.user_id = $1 AND l2.liked_user_id = l.user_id
@@ -103,6 +141,7 @@ func (r *LikeRepository) GetMatches(ctx context.Context, userID uuid.UUID, exclu
 		JOIN users u ON u.id = l1.liked_user_id
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l1.user_id = $1 AND NOT (u.id = ANY($4::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		ORDER BY l1.created_at DESC
 		LIMIT $2 OFFSET $3
 	`, userID, excludeIDs, limit, offset)
@@ -119,6 +158,7 @@ func (r *LikeRepository) GetLikedByMeCursor(ctx context.Context, userID uuid.UUI
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l.user_id = $1
 		  AND NOT (u.id = ANY($3::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		  AND NOT EXISTS (
 		    SELECT 1 FROM likes l2
 		    WHERE l2.user_id = l.liked_user_id AND l2.liked_user_id = l.user_id
@@ -144,6 +184,7 @@ func (r *LikeRepository) GetLikedMeCursor(ctx context.Context, userID uuid.UUID,
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l.liked_user_id = $1
 		  AND NOT (u.id = ANY($3::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		  AND (
 		    $4::timestamptz IS NULL
 		    OR l.created_at < $4
@@ -166,6 +207,7 @@ func (r *LikeRepository) GetMatchesCursor(ctx context.Context, userID uuid.UUID,
 		LEFT JOIN profiles p ON p.user_id = u.id
 		WHERE l1.user_id = $1
 		  AND NOT (u.id = ANY($3::uuid[]))
+		  AND u.deletion_requested_at IS NULL
 		  AND (
 		    $4::timestamptz IS NULL
 		    OR l1.created_at < $4
@@ -3,12 +3,20 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// messageColumns is the column list every message query selects, in the
+// order scanMessage expects.
+const messageColumns = `id, sender_id, receiver_id, content, message_type, media_url, created_at, is_read, read_at, edited_at, deleted_at, reply_to_message_id, media_key, media_status, metadata, expires_at`
+
 func NewMessageRepository(pool *pgxpool.Pool) *MessageRepository {
 	return &MessageRepository{pool: pool}
 }
@@ -24,35 +32,498 @@ type Message struct {
 	Content     string
 	MessageType string
 	MediaURL    *string
-	CreatedAt   time.Time
-	IsRead      bool
-	ReadAt      *time.Time
+	// MediaKey is the object key of the attachment in storage.
+	MediaKey  *string
+	CreatedAt time.Time
+	IsRead    bool
+	ReadAt    *time.Time
+	EditedAt  *time.Time
+	// DeletedAt marks an unsent message; its content and media are cleared.
+	DeletedAt *time.Time
+	ReplyToID *uuid.UUID
+	// MediaStatus is set on voice messages going through processing.
+	MediaStatus *string
+	// Metadata describes the attachment, e.g. VoiceMetadata, or the change a
+	// system message announces.
+	Metadata json.RawMessage
+	// ExpiresAt is set on messages sent while disappearing messages were on.
+	ExpiresAt *time.Time
+	// Reactions is only filled by GetPage.
+	Reactions []Reaction
+}
+
+// Voice processing states kept in Message.MediaStatus.
+const (
+	MediaProcessing = "processing"
+	MediaReady      = "ready"
+	MediaFailed     = "failed"
+)
+
+type VoiceMetadata struct {
+	DurationMs int64 `json:"duration_ms"`
+	// Waveform holds one peak level (0-100) per bar.
+	Waveform []int `json:"waveform"`
+}
+
+type Reaction struct {
+	UserID    uuid.UUID
+	Emoji     string
+	CreatedAt time.Time
+}
+
+// scanMessage reads messageColumns, followed by any extra columns of the
+// query into extra.
+func scanMessage(row pgx.Row, m *Message, extra ...any) error {
+	var readAt sql.NullTime
+	dest := []any{
+		&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.MessageType, &m.MediaURL,
+		&m.CreatedAt, &m.IsRead, &readAt, &m.EditedAt, &m.DeletedAt, &m.ReplyToID, &m.MediaKey,
+		&m.MediaStatus, &m.Metadata, &m.ExpiresAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if readAt.Valid {
+		t := readAt.Time
+		m.ReadAt = &t
+	}
+	return nil
 }
 
-func (r *MessageRepository) Create(ctx context.Context, senderID, receiverID uuid.UUID, content string) (*Message, error) {
-	return r.CreateWithMeta(ctx, senderID, receiverID, content, "text", nil)
+func (r *MessageRepository) Create(ctx context.Context, senderID, receiverID uuid.UUID, content string, replyToID *uuid.UUID) (*Message, error) {
+	return r.CreateWithMeta(ctx, senderID, receiverID, content, "text", nil, nil, nil, replyToID)
 }
 
-func (r *MessageRepository) CreateWithMeta(ctx context.Context, senderID, receiverID uuid.UUID, content string, messageType string, mediaURL *string) (*Message, error) {
+func (r *MessageRepository) CreateWithMeta(ctx context.Context, senderID, receiverID uuid.UUID, content string, messageType string, mediaURL, mediaKey, mediaStatus *string, replyToID *uuid.UUID) (*Message, error) {
 	var m Message
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO messages (sender_id, receiver_id, content, message_type, media_url)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, sender_id, receiver_id, content, message_type, media_url, created_at, is_read, read_at
-	`, senderID, receiverID, content, messageType, mediaURL).Scan(
-		&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.MessageType, &m.MediaURL, &m.CreatedAt, &m.IsRead, &m.ReadAt,
-	)
+	err := scanMessage(r.pool.QueryRow(ctx, `
+		INSERT INTO messages (sender_id, receiver_id, content, message_type, media_url, media_key, media_status, reply_to_message_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, `+expiresAtForPair+`)
+		RETURNING `+messageColumns,
+		senderID, receiverID, content, messageType, mediaURL, mediaKey, mediaStatus, replyToID), &m)
 	return &m, err
 }
 
-func (r *MessageRepository) GetBetween(ctx context.Context, userID, otherUserID uuid.UUID, limit, offset int) ([]Message, error) {
+// expiresAtForPair is the expiry of a message sent now between $1 and $2:
+// NULL unless the conversation has disappearing messages on.
+const expiresAtForPair = `NOW() + (
+	SELECT make_interval(secs => retention_seconds) FROM conversation_retention
+	WHERE user_a_id = LEAST($1::uuid, $2::uuid) AND user_b_id = GREATEST($1::uuid, $2::uuid)
+)`
+
+// CreateSystem records a notice about the conversation itself, attributed to
+// the user whose action it reports. meta is stored as the message metadata.
+func (r *MessageRepository) CreateSystem(ctx context.Context, actorID, peerID uuid.UUID, content string, meta any) (*Message, error) {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	var m Message
+	err = scanMessage(r.pool.QueryRow(ctx, `
+		INSERT INTO messages (sender_id, receiver_id, content, message_type, metadata, expires_at)
+		VALUES ($1, $2, $3, 'system', $4, `+expiresAtForPair+`)
+		RETURNING `+messageColumns,
+		actorID, peerID, content, raw), &m)
+	return &m, err
+}
+
+// ListExpired returns up to limit messages whose disappearing timer ran out,
+// soonest expired first, starting after the (expires_at, id) cursor when one
+// is given.
+func (r *MessageRepository) ListExpired(ctx context.Context, limit int, cursorTime *time.Time, cursorID *uuid.UUID) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE expires_at <= NOW()`
+	args := []any{limit}
+	if cursorTime != nil && cursorID != nil {
+		query += ` AND (expires_at, id) > ($2, $3)`
+		args = append(args, *cursorTime, *cursorID)
+	}
+	query += `
+		ORDER BY expires_at, id
+		LIMIT $1`
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []Message
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// DeleteExpired hard-deletes the given messages together with the message
+// notifications pointing at them and their logged events (the message's own
+// and the reactions to it), so a replay does not bring them back. Edits and
+// reactions go with the rows.
+func (r *MessageRepository) DeleteExpired(ctx context.Context, ids []uuid.UUID) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM notifications WHERE type = 'message' AND entity_id = ANY($1)
+	`, ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM user_events e
+		USING messages m
+		WHERE m.id = ANY($1) AND m.expires_at <= NOW()
+		  AND e.user_id IN (m.sender_id, m.receiver_id)
+		  AND (e.payload->'data'->>'id' = m.id::text
+		    OR e.payload->'data'->>'message_id' = m.id::text)
+	`, ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM messages WHERE id = ANY($1) AND expires_at <= NOW()
+	`, ids); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetVoiceReady points a message still being processed at its transcoded
+// file. It returns nil if the message was unsent in the meantime.
+func (r *MessageRepository) SetVoiceReady(ctx context.Context, id uuid.UUID, mediaURL, mediaKey string, meta VoiceMetadata) (*Message, error) {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	var m Message
+	err = scanMessage(r.pool.QueryRow(ctx, `
+		UPDATE messages
+		SET media_url = $2, media_key = $3, metadata = $4, media_status = '`+MediaReady+`'
+		WHERE id = $1 AND deleted_at IS NULL AND media_status = '`+MediaProcessing+`'
+		RETURNING `+messageColumns,
+		id, mediaURL, mediaKey, raw), &m)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SetVoiceFailed records that a message's voice file could not be processed;
+// it keeps pointing at the original upload. It returns nil if the message was
+// unsent in the meantime.
+func (r *MessageRepository) SetVoiceFailed(ctx context.Context, id uuid.UUID) (*Message, error) {
+	var m Message
+	err := scanMessage(r.pool.QueryRow(ctx, `
+		UPDATE messages SET media_status = '`+MediaFailed+`'
+		WHERE id = $1 AND deleted_at IS NULL AND media_status = '`+MediaProcessing+`'
+		RETURNING `+messageColumns,
+		id), &m)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListProcessingVoice returns voice messages sent before the cutoff that are
+// still being processed, oldest first.
+func (r *MessageRepository) ListProcessingVoice(ctx context.Context, before time.Time, limit int) ([]Message, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE media_status = '`+MediaProcessing+`' AND deleted_at IS NULL AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2
+	`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []Message
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// InConversation reports whether messageID was exchanged between a and b
+// and is still visible, i.e. neither unsent nor expired.
+func (r *MessageRepository) InConversation(ctx context.Context, messageID, a, b uuid.UUID) (bool, error) {
+	var ok bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM messages
+			WHERE id = $1
+			  AND ((sender_id = $2 AND receiver_id = $3) OR (sender_id = $3 AND receiver_id = $2))
+			  AND deleted_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+		)
+	`, messageID, a, b).Scan(&ok)
+	return ok, err
+}
+
+// ToggleReaction adds the user's emoji reaction to a message, or removes it
+// if it was already there. It reports whether the reaction was added.
+func (r *MessageRepository) ToggleReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string) (bool, error) {
+	res, err := r.pool.Exec(ctx, `
+		DELETE FROM message_reactions
+		WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	if res.RowsAffected() > 0 {
+		return false, nil
+	}
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, messageID, userID, emoji)
+	return err == nil, err
+}
+
+func (r *MessageRepository) reactionsFor(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(msgs))
+	index := make(map[uuid.UUID]int, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+		index[msgs[i].ID] = i
+	}
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, sender_id, receiver_id, content, message_type, media_url, created_at, is_read, read_at
+		SELECT message_id, user_id, emoji, created_at
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		ORDER BY created_at, user_id
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID uuid.UUID
+		var rc Reaction
+		if err := rows.Scan(&messageID, &rc.UserID, &rc.Emoji, &rc.CreatedAt); err != nil {
+			return err
+		}
+		m := &msgs[index[messageID]]
+		m.Reactions = append(m.Reactions, rc)
+	}
+	return rows.Err()
+}
+
+// GetByID returns the message, or nil if it does not exist.
+func (r *MessageRepository) GetByID(ctx context.Context, id uuid.UUID) (*Message, error) {
+	var m Message
+	err := scanMessage(r.pool.QueryRow(ctx, `SELECT `+messageColumns+` FROM messages WHERE id = $1`, id), &m)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Edit replaces the content of a message that is not deleted, keeping the
+// previous content in message_edits. Logged events for the message get the
+// new content too. It returns nil if there is no such message.
+func (r *MessageRepository) Edit(ctx context.Context, id uuid.UUID, content string) (*Message, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var previous string
+	err = tx.QueryRow(ctx, `
+		SELECT content FROM messages
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO message_edits (message_id, previous_content) VALUES ($1, $2)
+	`, id, previous); err != nil {
+		return nil, err
+	}
+	var m Message
+	err = scanMessage(tx.QueryRow(ctx, `
+		UPDATE messages SET content = $2, edited_at = NOW()
+		WHERE id = $1
+		RETURNING `+messageColumns,
+		id, content), &m)
+	if err != nil {
+		return nil, err
+	}
+	if err := patchMessageEvents(ctx, tx, &m, map[string]any{
+		"content":   m.Content,
+		"edited_at": m.EditedAt,
+	}); err != nil {
+		return nil, err
+	}
+	return &m, tx.Commit(ctx)
+}
+
+// SoftDelete turns a message into a tombstone: the row stays so history and
+// cursors are stable, but its content, media, edit history and reactions
+// are dropped, including from logged events. It returns the tombstone and
+// the message as it was (for its media), or nil messages if there was
+// nothing left to delete.
+func (r *MessageRepository) SoftDelete(ctx context.Context, id uuid.UUID) (*Message, *Message, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var before Message
+	err = scanMessage(tx.QueryRow(ctx, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id), &before)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM message_edits WHERE message_id = $1`, id); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM message_reactions WHERE message_id = $1`, id); err != nil {
+		return nil, nil, err
+	}
+	var m Message
+	err = scanMessage(tx.QueryRow(ctx, `
+		UPDATE messages
+		SET content = '', media_url = NULL, media_key = NULL, media_status = NULL, metadata = NULL, deleted_at = NOW()
+		WHERE id = $1
+		RETURNING `+messageColumns,
+		id), &m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := patchMessageEvents(ctx, tx, &m, map[string]any{
+		"content":      "",
+		"media_url":    nil,
+		"media_status": nil,
+		"metadata":     nil,
+		"reactions":    []any{},
+		"deleted_at":   m.DeletedAt,
+	}); err != nil {
+		return nil, nil, err
+	}
+	return &m, &before, tx.Commit(ctx)
+}
+
+// ListMediaKeys returns the storage keys under prefix of attachments in any
+// conversation of the user.
+func (r *MessageRepository) ListMediaKeys(ctx context.Context, userID uuid.UUID, prefix string) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT media_key FROM messages
+		WHERE (sender_id = $1 OR receiver_id = $1)
+		  AND media_key LIKE $2 || '%'
+	`, userID, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// ListSentMediaKeys returns the storage keys under prefix of attachments the
+// user sent.
+func (r *MessageRepository) ListSentMediaKeys(ctx context.Context, userID uuid.UUID, prefix string) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT media_key FROM messages
+		WHERE sender_id = $1
+		  AND media_key LIKE $2 || '%'
+		ORDER BY created_at
+	`, userID, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetPage returns up to limit messages between two users on one side of a
+// (created_at, id) cursor: older than it when older is set, newer otherwise.
+// Without a cursor it returns the newest messages. Either way the result is
+// in chronological order, with reactions loaded.
+func (r *MessageRepository) GetPage(ctx context.Context, userID, otherUserID uuid.UUID, limit int, cursorTime *time.Time, cursorID *uuid.UUID, older bool) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (
+		    $4::timestamptz IS NULL
+		    OR created_at < $4
+		    OR (created_at = $4 AND id < $5)
+		  )
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+	if !older {
+		query = `
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE (sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1)
-		ORDER BY created_at ASC
-		LIMIT $3 OFFSET $4
-	`, userID, otherUserID, limit, offset)
+		WHERE ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (
+		    $4::timestamptz IS NULL
+		    OR created_at > $4
+		    OR (created_at = $4 AND id > $5)
+		  )
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3
+		`
+	}
+	rows, err := r.pool.Query(ctx, query, userID, otherUserID, limit, cursorTime, cursorID)
 	if err != nil {
 		return nil, err
 	}
@@ -61,17 +532,23 @@ func (r *MessageRepository) GetBetween(ctx context.Context, userID, otherUserID
 	var msgs []Message
 	for rows.Next() {
 		var m Message
-		var readAt sql.NullTime
-		if err := rows.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.MessageType, &m.MediaURL, &m.CreatedAt, &m.IsRead, &readAt); err != nil {
+		if err := scanMessage(rows, &m); err != nil {
 			return nil, err
 		}
-		if readAt.Valid {
-			t := readAt.Time
-			m.ReadAt = &t
-		}
 		msgs = append(msgs, m)
 	}
-	return msgs, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if older {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	if err := r.reactionsFor(ctx, msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
 }
 
 func (r *MessageRepository) MarkReadFromSender(ctx context.Context, receiverID, senderID uuid.UUID) (int64, error) {
@@ -85,3 +562,70 @@ func (r *MessageRepository) MarkReadFromSender(ctx context.Context, receiverID,
 	}
 	return res.RowsAffected(), nil
 }
+
+// Highlighted terms in SearchHit.Headline are wrapped in these private-use
+// characters rather than markup, so content never has to be trusted as HTML.
+const (
+	HighlightStart = "\ue000"
+	HighlightStop  = "\ue001"
+)
+
+type SearchHit struct {
+	Message
+	Peer     UserCard
+	Headline string
+}
+
+// Search finds the user's messages matching a web-search style query, newest
+// first, paginated on (created_at, id). Conversations with users blocked in
+// either direction, or with accounts pending deletion, are left out.
+func (r *MessageRepository) Search(ctx context.Context, userID uuid.UUID, query string, limit int, cursorTime *time.Time, cursorID *uuid.UUID) ([]SearchHit, error) {
+	prefixed := "m." + strings.ReplaceAll(messageColumns, ", ", ", m.")
+	rows, err := r.pool.Query(ctx, `
+		WITH q AS (SELECT websearch_to_tsquery('simple', $2) AS query)
+		SELECT `+prefixed+`, u.id, u.username, u.first_name, u.last_name,
+		       ts_headline('simple', m.content, q.query,
+		                   'StartSel=`+HighlightStart+`, StopSel=`+HighlightStop+`, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "')
+		FROM (
+			SELECT `+messageColumns+`
+			FROM messages, q
+			WHERE (sender_id = $1 OR receiver_id = $1)
+			  AND deleted_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+			  AND content_tsv @@ q.query
+			  AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_user_id = sender_id AND b.blocked_user_id = receiver_id)
+				   OR (b.blocker_user_id = receiver_id AND b.blocked_user_id = sender_id)
+			  )
+			  AND NOT EXISTS (
+				SELECT 1 FROM users d
+				WHERE d.id IN (sender_id, receiver_id) AND d.deletion_requested_at IS NOT NULL
+			  )
+			  AND (
+			    $4::timestamptz IS NULL
+			    OR created_at < $4
+			    OR (created_at = $4 AND id < $5)
+			  )
+			ORDER BY created_at DESC, id DESC
+			LIMIT $3
+		) m
+		CROSS JOIN q
+		JOIN users u ON u.id = CASE WHEN m.sender_id = $1 THEN m.receiver_id ELSE m.sender_id END
+		ORDER BY m.created_at DESC, m.id DESC
+	`, userID, query, limit, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := scanMessage(rows, &h.Message, &h.Peer.ID, &h.Peer.Username, &h.Peer.FirstName, &h.Peer.LastName, &h.Headline); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
@@ -3,19 +3,45 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrEmailTaken is returned when an email update collides with another
+// account's address.
+var ErrEmailTaken = errors.New("email taken")
+
 type User struct {
-	ID              uuid.UUID
-	Username        string
-	Email           string
-	PasswordHash    string
-	FirstName       string
-	LastName        string
-	EmailVerifiedAt sql.NullTime
+	ID                  uuid.UUID
+	Username            string
+	Email               string
+	PasswordHash        string
+	FirstName           string
+	LastName            string
+	EmailVerifiedAt     sql.NullTime
+	TOTPSecret          sql.NullString
+	TOTPEnabledAt       sql.NullTime
+	DeletionRequestedAt sql.NullTime
+}
+
+const userColumns = `id, username, email, password_hash, first_name, last_name, email_verified_at,
+	totp_secret, totp_enabled_at, deletion_requested_at`
+
+func scanUser(row pgx.Row) (*User, error) {
+	var u User
+	err := row.Scan(
+		&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.EmailVerifiedAt,
+		&u.TOTPSecret, &u.TOTPEnabledAt, &u.DeletionRequestedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
 func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
@@ -41,43 +67,19 @@ func (r *UserRepository) Create(ctx context.Context, u *User) error {
 }
 
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
-	var u User
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, username, email, password_hash, first_name, last_name, email_verified_at
-		FROM users WHERE username = $1
-	`, username).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.EmailVerifiedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &u, nil
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username))
 }
 
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
-	var u User
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, username, email, password_hash, first_name, last_name, email_verified_at
-		FROM users WHERE email = $1
-	`, email).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.EmailVerifiedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &u, nil
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, email))
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
-	var u User
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, username, email, password_hash, first_name, last_name, email_verified_at
-		FROM users WHERE id = $1
-	`, id).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.EmailVerifiedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &u, nil
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
 }
 
 func (r *UserRepository) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
-	rows, err := r.pool.Query(ctx, `SELECT id FROM users`)
+	rows, err := r.pool.Query(ctx, `SELECT id FROM users WHERE deletion_requested_at IS NULL`)
 	if err != nil {
 		return nil, err
 	}
@@ -111,14 +113,171 @@ func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID uuid.UUI
 	return err
 }
 
-func (r *UserRepository) UpdateAccount(ctx context.Context, userID uuid.UUID, username, email, firstName, lastName string) error {
+func (r *UserRepository) UpdateAccount(ctx context.Context, userID uuid.UUID, username, firstName, lastName string) error {
 	_, err := r.pool.Exec(ctx, `
 		UPDATE users
 		SET username = $2,
-		    email = $3,
-		    first_name = $4,
-		    last_name = $5
+		    first_name = $3,
+		    last_name = $4
+		WHERE id = $1
+	`, userID, username, firstName, lastName)
+	return err
+}
+
+// ChangeEmail switches to a confirmed new address; following the link proved
+// ownership, so the address counts as verified from now on.
+func (r *UserRepository) ChangeEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET email = $2,
+		    email_verified_at = NOW()
+		WHERE id = $1
+	`, userID, email)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// EnableTOTP stores the confirmed secret and replaces any previous recovery
+// codes in one transaction.
+func (r *UserRepository) EnableTOTP(ctx context.Context, userID uuid.UUID, secret string, recoveryCodeHashes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users
+		SET totp_secret = $2, totp_enabled_at = NOW()
+		WHERE id = $1
+	`, userID, secret); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, h := range recoveryCodeHashes {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_recovery_codes (user_id, code_hash)
+			VALUES ($1, $2)
+		`, userID, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL
 		WHERE id = $1
-	`, userID, username, email, firstName, lastName)
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UseRecoveryCode marks an unused recovery code as spent and reports whether
+// one matched.
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := r.pool.Exec(ctx, `
+		UPDATE user_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) MarkForDeletion(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET deletion_requested_at = COALESCE(deletion_requested_at, NOW())
+		WHERE id = $1
+	`, userID)
 	return err
 }
+
+// CancelDeletion clears a pending deletion and reports whether there was one
+// that the purge job had not started on yet.
+func (r *UserRepository) CancelDeletion(ctx context.Context, userID uuid.UUID) (bool, error) {
+	res, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET deletion_requested_at = NULL
+		WHERE id = $1 AND deletion_requested_at IS NOT NULL AND purging_at IS NULL
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) ListDueForPurge(ctx context.Context, requestedBefore time.Time, limit int) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id FROM users
+		WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at <= $1
+		ORDER BY deletion_requested_at
+		LIMIT $2
+	`, requestedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Purge deletes the user row (dependent rows cascade) if the account is still
+// pending deletion past requestedBefore, so a last-minute restore wins.
+// ClaimPurge marks a due account as being purged, after which CancelDeletion
+// refuses it. It reports whether the account is (still) due; a claim left by
+// an earlier failed run is taken over.
+func (r *UserRepository) ClaimPurge(ctx context.Context, userID uuid.UUID, requestedBefore time.Time) (bool, error) {
+	res, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET purging_at = COALESCE(purging_at, NOW())
+		WHERE id = $1 AND deletion_requested_at IS NOT NULL AND deletion_requested_at <= $2
+	`, userID, requestedBefore)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// Purge deletes an account claimed with ClaimPurge.
+func (r *UserRepository) Purge(ctx context.Context, userID uuid.UUID) (bool, error) {
+	res, err := r.pool.Exec(ctx, `
+		DELETE FROM users
+		WHERE id = $1 AND purging_at IS NOT NULL
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
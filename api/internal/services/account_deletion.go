@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/storage"
+)
+
+// AccountDeletionGrace is how long a deleted account can still be restored
+// by signing in before it is purged.
+const AccountDeletionGrace = 14 * 24 * time.Hour
+
+const purgeBatchSize = 100
+
+// ErrAccountPurged means the account is past its grace period and already
+// being removed, so it can no longer be restored.
+var ErrAccountPurged = errors.New("account is being deleted")
+
+// RequestAccountDeletion schedules the account for purge and signs it out
+// everywhere. It returns when the purge becomes due.
+func (s *AuthService) RequestAccountDeletion(ctx context.Context, userID uuid.UUID, password string) (time.Time, error) {
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return time.Time{}, ErrInvalidPassword
+	}
+	if err := s.userRepo.MarkForDeletion(ctx, userID); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.tokenStore.RevokeUserSessions(ctx, userID, ""); err != nil {
+		return time.Time{}, err
+	}
+	requestedAt := time.Now()
+	if u.DeletionRequestedAt.Valid {
+		requestedAt = u.DeletionRequestedAt.Time
+	}
+	return requestedAt.Add(AccountDeletionGrace), nil
+}
+
+// RestoreAccount cancels a pending deletion. It reports whether the account
+// was actually pending deletion, and fails with ErrAccountPurged once the
+// purge has started.
+func (s *AuthService) RestoreAccount(ctx context.Context, u *repository.User) (bool, error) {
+	if !u.DeletionRequestedAt.Valid {
+		return false, nil
+	}
+	restored, err := s.userRepo.CancelDeletion(ctx, u.ID)
+	if err != nil {
+		return false, err
+	}
+	if !restored {
+		return false, ErrAccountPurged
+	}
+	u.DeletionRequestedAt.Valid = false
+	return true, nil
+}
+
+type AccountPurgeService struct {
+	userRepo    *repository.UserRepository
+	messageRepo *repository.MessageRepository
+	store       *storage.MinIO
+	syncSvc     *SyncService
+}
+
+func NewAccountPurgeService(userRepo *repository.UserRepository, messageRepo *repository.MessageRepository, store *storage.MinIO, syncSvc *SyncService) *AccountPurgeService {
+	return &AccountPurgeService{userRepo: userRepo, messageRepo: messageRepo, store: store, syncSvc: syncSvc}
+}
+
+// Run purges due accounts every interval until ctx is cancelled.
+func (s *AccountPurgeService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.PurgeDue(ctx); err != nil {
+			log.Printf("[purge] account purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[purge] purged %d deleted accounts", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AccountPurgeService) PurgeDue(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-AccountDeletionGrace)
+	ids, err := s.userRepo.ListDueForPurge(ctx, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, id := range ids {
+		ok, err := s.purgeUser(ctx, id, cutoff)
+		if err != nil {
+			log.Printf("[purge] user=%s: %v", id, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purgeUser claims the account first, so a sign-in can no longer restore it
+// once files start disappearing. Stored files go before the row so that a
+// storage failure leaves the account in place for the next run to retry.
+func (s *AccountPurgeService) purgeUser(ctx context.Context, userID uuid.UUID, cutoff time.Time) (bool, error) {
+	claimed, err := s.userRepo.ClaimPurge(ctx, userID, cutoff)
+	if err != nil || !claimed {
+		return false, err
+	}
+	prefixes := []string{
+		"users/" + userID.String() + "/",
+		"voice/" + userID.String() + "/",
+		ExportPrefix + userID.String() + "/",
+	}
+	for _, prefix := range prefixes {
+		if err := s.store.RemovePrefix(ctx, prefix); err != nil {
+			return false, err
+		}
+	}
+	// Chat attachments live per conversation; the messages go with the
+	// account, so their files must too.
+	keys, err := s.messageRepo.ListMediaKeys(ctx, userID, storage.ChatPrefix)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if err := s.store.RemoveObject(ctx, key); err != nil {
+			return false, err
+		}
+	}
+	ok, err := s.userRepo.Purge(ctx, userID)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := s.syncSvc.RemoveUser(ctx, userID); err != nil {
+		log.Printf("[purge] remove user=%s from search index: %v", userID, err)
+	}
+	return true, nil
+}
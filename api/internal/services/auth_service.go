@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,6 +20,8 @@ var ErrInvalidPassword = errors.New("invalid password")
 var ErrUserExists = errors.New("user exists")
 var ErrInvalidResetToken = errors.New("invalid reset token")
 var ErrEmailNotVerified = errors.New("email not verified")
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+var ErrSessionNotFound = errors.New("session not found")
 
 func NewAuthService(userRepo *repository.UserRepository, tokenStore *store.TokenStore) *AuthService {
 	return &AuthService{userRepo: userRepo, tokenStore: tokenStore}
@@ -30,8 +33,10 @@ type AuthService struct {
 }
 
 const (
-	emailVerifyTTL = 24 * time.Hour
+	emailVerifyTTL   = 24 * time.Hour
 	passwordResetTTL = 30 * time.Minute
+	refreshTokenTTL  = 30 * 24 * time.Hour
+	maxUserAgentLen  = 256
 )
 
 func (s *AuthService) Register(ctx context.Context, username, email, password, firstName, lastName string) (*repository.User, error) {
@@ -86,30 +91,46 @@ func (s *AuthService) Login(ctx context.Context, username, password string) (*re
 	return u, nil
 }
 
-func (s *AuthService) UpdateAccount(ctx context.Context, userID uuid.UUID, username, email, firstName, lastName string) error {
+// UpdateAccount saves username and names right away. A different email is
+// not applied here: it starts a pending change that the caller has to mail
+// out, returned as non-nil *EmailChangeRequest.
+func (s *AuthService) UpdateAccount(ctx context.Context, userID uuid.UUID, username, email, firstName, lastName string) (*EmailChangeRequest, error) {
 	if err := validation.ValidateUsername(username); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validation.ValidateEmail(email); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validation.ValidateName(firstName, "first_name"); err != nil {
-		return err
+		return nil, err
 	}
 	if err := validation.ValidateName(lastName, "last_name"); err != nil {
-		return err
+		return nil, err
 	}
 
+	current, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 	if u, err := s.userRepo.GetByUsername(ctx, username); err == nil && u.ID != userID {
-		return ErrUserExists
+		return nil, ErrUserExists
 	}
-	if u, err := s.userRepo.GetByEmail(ctx, email); err == nil && u.ID != userID {
-		return ErrUserExists
+	emailChanged := email != current.Email
+	if emailChanged {
+		if _, err := s.userRepo.GetByEmail(ctx, email); err == nil {
+			return nil, ErrUserExists
+		}
+	}
+	if err := s.userRepo.UpdateAccount(ctx, userID, username, firstName, lastName); err != nil {
+		return nil, err
 	}
-	return s.userRepo.UpdateAccount(ctx, userID, username, email, firstName, lastName)
+	if !emailChanged {
+		return nil, nil
+	}
+	return s.requestEmailChange(ctx, current, email)
 }
 
-func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string) error {
+func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword, currentSessionID string) error {
 	if err := validation.ValidatePassword(newPassword); err != nil {
 		return err
 	}
@@ -129,7 +150,10 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, curr
 	if err != nil {
 		return err
 	}
-	return s.userRepo.UpdatePasswordHash(ctx, userID, string(hash))
+	if err := s.userRepo.UpdatePasswordHash(ctx, userID, string(hash)); err != nil {
+		return err
+	}
+	return s.tokenStore.RevokeUserSessions(ctx, userID, currentSessionID)
 }
 
 func (s *AuthService) VerifyEmail(ctx context.Context, userID uuid.UUID) error {
@@ -142,7 +166,7 @@ func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) (s
 		return "", nil, nil
 	}
 
-	resetToken, tokenHash, err := generateResetToken()
+	resetToken, tokenHash, err := generateToken()
 	if err != nil {
 		return "", nil, err
 	}
@@ -152,28 +176,89 @@ func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) (s
 	return resetToken, u, nil
 }
 
-func (s *AuthService) ResetPassword(ctx context.Context, resetToken, newPassword string) error {
+func (s *AuthService) ResetPassword(ctx context.Context, resetToken, newPassword string) (uuid.UUID, error) {
 	if err := validation.ValidatePassword(newPassword); err != nil {
-		return err
+		return uuid.Nil, err
 	}
 
 	tokenHash := hashToken(resetToken)
 	userID, err := s.tokenStore.GetAndDeletePwdReset(ctx, tokenHash)
 	if err != nil {
 		if err == store.ErrTokenNotFound {
-			return ErrInvalidResetToken
+			return uuid.Nil, ErrInvalidResetToken
 		}
-		return err
+		return uuid.Nil, err
 	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.userRepo.UpdatePasswordHash(ctx, userID, string(hash)); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, s.tokenStore.RevokeUserSessions(ctx, userID, "")
+}
+
+// CreateSession starts a new login session and returns its ID (used as the
+// access token jti) and an opaque refresh token of the form "<session>.<secret>".
+func (s *AuthService) CreateSession(ctx context.Context, userID uuid.UUID, ip, userAgent string) (string, string, error) {
+	sessionID := uuid.NewString()
+	secret, secretHash, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	if err := s.tokenStore.CreateSession(ctx, sessionID, userID, secretHash, ip, userAgent, refreshTokenTTL); err != nil {
+		return "", "", err
+	}
+	return sessionID, sessionID + "." + secret, nil
+}
+
+// RefreshSession rotates the refresh token of an existing session. Presenting
+// an already rotated token revokes the whole session.
+func (s *AuthService) RefreshSession(ctx context.Context, refreshToken, ip string) (uuid.UUID, string, string, error) {
+	sessionID, secret, ok := strings.Cut(strings.TrimSpace(refreshToken), ".")
+	if !ok || sessionID == "" || secret == "" {
+		return uuid.Nil, "", "", ErrInvalidRefreshToken
+	}
+	newSecret, newHash, err := generateToken()
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	userID, err := s.tokenStore.RotateRefresh(ctx, sessionID, hashToken(secret), newHash, ip, refreshTokenTTL)
+	if err != nil {
+		if err == store.ErrTokenNotFound || err == store.ErrRefreshTokenReused {
+			return uuid.Nil, "", "", ErrInvalidRefreshToken
+		}
+		return uuid.Nil, "", "", err
+	}
+	return userID, sessionID, sessionID + "." + newSecret, nil
+}
+
+func (s *AuthService) ListSessions(ctx context.Context, userID uuid.UUID) ([]store.Session, error) {
+	return s.tokenStore.ListUserSessions(ctx, userID)
+}
+
+// RevokeSession ends one of the user's sessions. Sessions owned by someone
+// else are reported as not found.
+func (s *AuthService) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	sess, err := s.tokenStore.GetSession(ctx, sessionID)
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return ErrSessionNotFound
+		}
 		return err
 	}
-	return s.userRepo.UpdatePasswordHash(ctx, userID, string(hash))
+	if sess.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return s.tokenStore.RevokeSession(ctx, userID, sessionID)
 }
 
-func generateResetToken() (string, string, error) {
+func generateToken() (string, string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", "", err
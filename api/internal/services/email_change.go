@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/store"
+)
+
+var ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+
+const emailChangeTTL = 24 * time.Hour
+
+// EmailChangeRequest carries what the caller needs to mail out a pending
+// change: the confirm link goes to NewEmail, the cancel link to OldEmail.
+type EmailChangeRequest struct {
+	User         *repository.User
+	OldEmail     string
+	NewEmail     string
+	ConfirmToken string
+	CancelToken  string
+}
+
+func (s *AuthService) requestEmailChange(ctx context.Context, u *repository.User, newEmail string) (*EmailChangeRequest, error) {
+	confirmToken, confirmHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	cancelToken, cancelHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokenStore.SetEmailChange(ctx, u.ID, newEmail, confirmHash, cancelHash, emailChangeTTL); err != nil {
+		return nil, err
+	}
+	return &EmailChangeRequest{
+		User:         u,
+		OldEmail:     u.Email,
+		NewEmail:     newEmail,
+		ConfirmToken: confirmToken,
+		CancelToken:  cancelToken,
+	}, nil
+}
+
+// PendingEmailChange returns the address the user is changing to, or "" if
+// no change is pending.
+func (s *AuthService) PendingEmailChange(ctx context.Context, userID uuid.UUID) (string, error) {
+	email, err := s.tokenStore.GetEmailChange(ctx, userID)
+	if err == store.ErrTokenNotFound {
+		return "", nil
+	}
+	return email, err
+}
+
+// ConfirmEmailChange applies the pending change behind token. The address is
+// checked again since another account may have claimed it in the meantime.
+func (s *AuthService) ConfirmEmailChange(ctx context.Context, token string) (uuid.UUID, error) {
+	change, err := s.tokenStore.TakeEmailChangeConfirm(ctx, hashToken(token))
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return uuid.Nil, ErrInvalidEmailChangeToken
+		}
+		return uuid.Nil, err
+	}
+	if u, err := s.userRepo.GetByEmail(ctx, change.NewEmail); err == nil && u.ID != change.UserID {
+		return uuid.Nil, ErrUserExists
+	}
+	if err := s.userRepo.ChangeEmail(ctx, change.UserID, change.NewEmail); err != nil {
+		if err == repository.ErrEmailTaken {
+			return uuid.Nil, ErrUserExists
+		}
+		return uuid.Nil, err
+	}
+	return change.UserID, nil
+}
+
+// CancelEmailChange drops the pending change behind token. Since the request
+// may have come from a hijacked session, all sessions of the user are
+// revoked as well.
+func (s *AuthService) CancelEmailChange(ctx context.Context, token string) (uuid.UUID, error) {
+	change, err := s.tokenStore.TakeEmailChangeCancel(ctx, hashToken(token))
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return uuid.Nil, ErrInvalidEmailChangeToken
+		}
+		return uuid.Nil, err
+	}
+	return change.UserID, s.tokenStore.RevokeUserSessions(ctx, change.UserID, "")
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"matcha/api/internal/repository"
+)
+
+// EventLogTrimmer enforces repository.EventLogRetention on the per-user
+// event logs, including those of users who no longer receive events.
+type EventLogTrimmer struct {
+	eventRepo *repository.EventRepository
+}
+
+func NewEventLogTrimmer(eventRepo *repository.EventRepository) *EventLogTrimmer {
+	return &EventLogTrimmer{eventRepo: eventRepo}
+}
+
+// Run trims the event logs every interval until ctx is cancelled.
+func (s *EventLogTrimmer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.eventRepo.TrimExpired(ctx); err != nil {
+			log.Printf("[events] trimming event logs failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[events] trimmed %d expired events", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"matcha/api/internal/jwtkeys"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/storage"
+	"matcha/api/internal/store"
+)
+
+// ExportPrefix holds finished archives. It is not publicly readable; archives
+// are only reachable through a signed download link.
+const ExportPrefix = "exports/"
+
+// ExportRetentionDays is how long archives (and their links) stay valid.
+const ExportRetentionDays = 7
+
+const (
+	exportLinkTTL    = ExportRetentionDays * 24 * time.Hour
+	exportJobTimeout = 15 * time.Minute
+	exportWindow     = 24 * time.Hour
+	exportsPerWindow = 2
+	exportAudience   = "matcha:data-export"
+)
+
+var ErrInvalidExportLink = errors.New("invalid or expired export link")
+
+const exportReadme = `Matcha personal data export
+
+Each .json file holds one category of data we store about your account.
+photos/ contains the original files of your profile photos, voice/ the
+voice messages you sent and chat/ the attachments you sent in chats.
+Passwords and two-factor secrets are not included.
+`
+
+type ExportService struct {
+	exportRepo    *repository.ExportRepository
+	userRepo      *repository.UserRepository
+	messageRepo   *repository.MessageRepository
+	tokenStore    *store.TokenStore
+	store         *storage.MinIO
+	mailer        *Mailer
+	keys          *jwtkeys.KeySet
+	publicAPIBase string
+}
+
+func NewExportService(
+	exportRepo *repository.ExportRepository,
+	userRepo *repository.UserRepository,
+	messageRepo *repository.MessageRepository,
+	tokenStore *store.TokenStore,
+	store *storage.MinIO,
+	mailer *Mailer,
+	keys *jwtkeys.KeySet,
+	publicAPIBase string,
+) *ExportService {
+	return &ExportService{
+		exportRepo:    exportRepo,
+		userRepo:      userRepo,
+		messageRepo:   messageRepo,
+		tokenStore:    tokenStore,
+		store:         store,
+		mailer:        mailer,
+		keys:          keys,
+		publicAPIBase: publicAPIBase,
+	}
+}
+
+// Start queues an export for the user and returns its ID. A non-zero
+// duration means the user already requested too many exports recently.
+func (s *ExportService) Start(ctx context.Context, userID uuid.UUID) (string, time.Duration, error) {
+	key := "export:" + userID.String()
+	n, oldest, err := s.tokenStore.CountHits(ctx, key, exportWindow)
+	if err != nil {
+		return "", 0, err
+	}
+	if n >= exportsPerWindow {
+		return "", windowRetryAfter(oldest, exportWindow, time.Now()), nil
+	}
+	if _, _, err := s.tokenStore.RecordHit(ctx, key, exportWindow); err != nil {
+		return "", 0, err
+	}
+	exportID := uuid.NewString()
+	go s.run(userID, exportID)
+	return exportID, 0, nil
+}
+
+// Open resolves a download token to the archive it points to.
+func (s *ExportService) Open(ctx context.Context, token string) (io.ReadCloser, int64, error) {
+	claims := &jwt.RegisteredClaims{}
+	t, err := s.keys.Parse(token, claims, jwt.WithAudience(exportAudience))
+	if err != nil || !t.Valid {
+		return nil, 0, ErrInvalidExportLink
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || claims.ID == "" {
+		return nil, 0, ErrInvalidExportLink
+	}
+	obj, err := s.store.GetObject(ctx, exportObjectKey(userID, claims.ID))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, 0, ErrInvalidExportLink
+	}
+	return obj, info.Size, nil
+}
+
+func (s *ExportService) run(userID uuid.UUID, exportID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportJobTimeout)
+	defer cancel()
+
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		log.Printf("[export] user=%s export=%s: load user: %v", userID, exportID, err)
+		return
+	}
+	if err := s.build(ctx, userID, exportID); err != nil {
+		log.Printf("[export] user=%s export=%s: %v", userID, exportID, err)
+		body := fmt.Sprintf("Hi %s,\n\nWe could not prepare your Matcha data export. Please request it again later.\n", u.FirstName)
+		if err := s.mailer.Send(u.Email, "Matcha data export failed", body); err != nil {
+			log.Printf("[export] failed sending failure email to user=%s: %v", userID, err)
+		}
+		return
+	}
+
+	link, err := s.downloadLink(userID, exportID)
+	if err != nil {
+		log.Printf("[export] user=%s export=%s: sign link: %v", userID, exportID, err)
+		return
+	}
+	body := fmt.Sprintf(
+		"Hi %s,\n\nYour Matcha data export is ready. Download it within %d days:\n%s\n\nIf you did not request this export, please change your password.\n",
+		u.FirstName, ExportRetentionDays, link,
+	)
+	if err := s.mailer.Send(u.Email, "Your Matcha data export", body); err != nil {
+		log.Printf("[export] failed sending export email to user=%s: %v", userID, err)
+		return
+	}
+	log.Printf("[export] ready: user=%s export=%s", userID, exportID)
+}
+
+// build writes the archive to a temp file first because MinIO needs the
+// object size up front.
+func (s *ExportService) build(ctx context.Context, userID uuid.UUID, exportID string) error {
+	f, err := os.CreateTemp("", "matcha-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	if err := writeZipFile(zw, "README.txt", zip.Deflate, bytes.NewReader([]byte(exportReadme))); err != nil {
+		return err
+	}
+
+	sections, err := s.exportRepo.CollectUserData(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("collect data: %w", err)
+	}
+	for _, sec := range sections {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, sec.Data, "", "  "); err != nil {
+			return fmt.Errorf("format %s: %w", sec.Name, err)
+		}
+		if err := writeZipFile(zw, sec.Name+".json", zip.Deflate, &buf); err != nil {
+			return err
+		}
+	}
+
+	media := []struct{ prefix, dir string }{
+		{"users/" + userID.String() + "/", "photos/"},
+		{"voice/" + userID.String() + "/", "voice/"},
+	}
+	for _, m := range media {
+		keys, err := s.store.ListObjectKeys(ctx, m.prefix)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", m.prefix, err)
+		}
+		for _, key := range keys {
+			if err := s.copyObject(ctx, zw, key, m.dir+path.Base(key)); err != nil {
+				return err
+			}
+		}
+	}
+	// Chat attachments live in per-conversation folders shared with the
+	// other participant, so only the ones the user sent are included.
+	chatKeys, err := s.messageRepo.ListSentMediaKeys(ctx, userID, storage.ChatPrefix)
+	if err != nil {
+		return fmt.Errorf("list chat media: %w", err)
+	}
+	for _, key := range chatKeys {
+		if err := s.copyObject(ctx, zw, key, "chat/"+path.Base(key)); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = s.store.PutObject(ctx, exportObjectKey(userID, exportID), f, size, "application/zip")
+	return err
+}
+
+func (s *ExportService) copyObject(ctx context.Context, zw *zip.Writer, key, name string) error {
+	obj, err := s.store.GetObject(ctx, key)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", key, err)
+	}
+	defer obj.Close()
+	// Media is already compressed, so store it as is.
+	if err := writeZipFile(zw, name, zip.Store, obj); err != nil {
+		return fmt.Errorf("copy %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *ExportService) downloadLink(userID uuid.UUID, exportID string) (string, error) {
+	now := time.Now()
+	token, err := s.keys.Sign(jwt.RegisteredClaims{
+		ID:        exportID,
+		Subject:   userID.String(),
+		Audience:  jwt.ClaimStrings{exportAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(exportLinkTTL)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.publicAPIBase + "/api/v1/exports/download?token=" + url.QueryEscape(token), nil
+}
+
+func exportObjectKey(userID uuid.UUID, exportID string) string {
+	return ExportPrefix + userID.String() + "/" + exportID + ".zip"
+}
+
+func writeZipFile(zw *zip.Writer, name string, method uint16, r io.Reader) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
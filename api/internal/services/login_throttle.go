@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"matcha/api/internal/repository"
+)
+
+// Login throttling works on three sliding windows: per username+IP pair
+// (progressive delay), per username across IPs (temporary lockout) and per IP
+// across usernames (blocks credential stuffing from a single address).
+const (
+	loginWindow        = 15 * time.Minute
+	loginFreeAttempts  = 3
+	loginMaxDelay      = time.Minute
+	loginUserLockAfter = 10
+	loginIPBlockAfter  = 50
+	resetWindow        = time.Hour
+	resetPerEmailLimit = 3
+	resetPerIPLimit    = 10
+)
+
+// LoginLockoutDuration is how long sign-in stays blocked after a lockout.
+const LoginLockoutDuration = 15 * time.Minute
+
+func loginUserKey(username string) string { return "login:user:" + strings.TrimSpace(username) }
+func loginIPKey(ip string) string         { return "login:ip:" + ip }
+func loginPairKey(username, ip string) string {
+	return "login:pair:" + strings.TrimSpace(username) + "|" + ip
+}
+
+// LoginRetryAfter reports how long the client has to wait before another
+// login attempt for username from ip is accepted (0 = allowed now).
+func (s *AuthService) LoginRetryAfter(ctx context.Context, username, ip string) (time.Duration, error) {
+	return s.tokenStore.BlockTTL(ctx, loginUserKey(username), loginIPKey(ip), loginPairKey(username, ip))
+}
+
+// RecordLoginFailure counts a failed password attempt and applies delays and
+// lockouts. It returns how long the client must wait before retrying and,
+// when this failure locked an existing account, that account so the owner
+// can be notified.
+func (s *AuthService) RecordLoginFailure(ctx context.Context, username, ip string) (time.Duration, *repository.User, error) {
+	userKey, ipKey, pairKey := loginUserKey(username), loginIPKey(ip), loginPairKey(username, ip)
+
+	pairCount, _, err := s.tokenStore.RecordHit(ctx, pairKey, loginWindow)
+	if err != nil {
+		return 0, nil, err
+	}
+	if d := loginDelay(pairCount); d > 0 {
+		if _, err := s.tokenStore.SetBlock(ctx, pairKey, d); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	ipCount, _, err := s.tokenStore.RecordHit(ctx, ipKey, loginWindow)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ipCount >= loginIPBlockAfter {
+		if _, err := s.tokenStore.SetBlock(ctx, ipKey, LoginLockoutDuration); err != nil {
+			return 0, nil, err
+		}
+		_ = s.tokenStore.ClearHits(ctx, ipKey)
+	}
+
+	var locked *repository.User
+	userCount, _, err := s.tokenStore.RecordHit(ctx, userKey, loginWindow)
+	if err != nil {
+		return 0, nil, err
+	}
+	if userCount >= loginUserLockAfter {
+		created, err := s.tokenStore.SetBlock(ctx, userKey, LoginLockoutDuration)
+		if err != nil {
+			return 0, nil, err
+		}
+		_ = s.tokenStore.ClearHits(ctx, userKey)
+		if created {
+			if u, err := s.userRepo.GetByUsername(ctx, username); err == nil {
+				locked = u
+			}
+		}
+	}
+
+	retry, err := s.LoginRetryAfter(ctx, username, ip)
+	if err != nil {
+		return 0, nil, err
+	}
+	return retry, locked, nil
+}
+
+// ResetLoginFailures is called after a correct password. The per-IP window is
+// kept so a valid account cannot be used to reset a stuffing counter.
+func (s *AuthService) ResetLoginFailures(ctx context.Context, username, ip string) error {
+	return s.tokenStore.ClearHits(ctx, loginUserKey(username), loginPairKey(username, ip))
+}
+
+// PasswordResetRetryAfter records a forgot-password request and returns a
+// non-zero wait if the email or IP exceeded its hourly budget.
+func (s *AuthService) PasswordResetRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	return s.emailRequestRetryAfter(ctx, "reset", email, ip)
+}
+
+// MagicLinkRetryAfter is PasswordResetRetryAfter for sign-in link requests,
+// counted separately so one flow cannot starve the other.
+func (s *AuthService) MagicLinkRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	return s.emailRequestRetryAfter(ctx, "magic", email, ip)
+}
+
+func (s *AuthService) emailRequestRetryAfter(ctx context.Context, scope, email, ip string) (time.Duration, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	limits := []struct {
+		key   string
+		limit int64
+	}{
+		{scope + ":email:" + email, resetPerEmailLimit},
+		{scope + ":ip:" + ip, resetPerIPLimit},
+	}
+	now := time.Now()
+	for _, l := range limits {
+		n, oldest, err := s.tokenStore.CountHits(ctx, l.key, resetWindow)
+		if err != nil {
+			return 0, err
+		}
+		if n >= l.limit {
+			return windowRetryAfter(oldest, resetWindow, now), nil
+		}
+	}
+	for _, l := range limits {
+		if _, _, err := s.tokenStore.RecordHit(ctx, l.key, resetWindow); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// loginDelay is the back-off after n failures from one username+IP pair:
+// a few free attempts, then 1s, 2s, 4s... capped at loginMaxDelay.
+func loginDelay(failures int64) time.Duration {
+	over := failures - loginFreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	if over > 10 {
+		return loginMaxDelay
+	}
+	d := time.Second << (over - 1)
+	if d > loginMaxDelay {
+		return loginMaxDelay
+	}
+	return d
+}
+
+// windowRetryAfter is the time until the oldest hit leaves the window.
+func windowRetryAfter(oldest time.Time, window time.Duration, now time.Time) time.Duration {
+	if oldest.IsZero() {
+		return window
+	}
+	d := oldest.Add(window).Sub(now)
+	if d < time.Second {
+		return time.Second
+	}
+	return d
+}
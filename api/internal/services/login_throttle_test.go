@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginFreeAttempts, 0},
+		{loginFreeAttempts + 1, time.Second},
+		{loginFreeAttempts + 2, 2 * time.Second},
+		{loginFreeAttempts + 4, 8 * time.Second},
+		{loginFreeAttempts + 7, loginMaxDelay},
+		{1000, loginMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestWindowRetryAfter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	if got := windowRetryAfter(now.Add(-40*time.Minute), time.Hour, now); got != 20*time.Minute {
+		t.Errorf("expected 20m, got %v", got)
+	}
+	if got := windowRetryAfter(now.Add(-2*time.Hour), time.Hour, now); got != time.Second {
+		t.Errorf("expected floor of 1s for an already expired hit, got %v", got)
+	}
+	if got := windowRetryAfter(time.Time{}, time.Hour, now); got != time.Hour {
+		t.Errorf("expected full window without hits, got %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"matcha/api/internal/repository"
+	"matcha/api/internal/store"
+)
+
+var ErrInvalidMagicLink = errors.New("invalid magic link")
+
+// MagicLinkTTL is how long an emailed sign-in link stays valid.
+const MagicLinkTTL = 10 * time.Minute
+
+// RequestMagicLink creates a single-use sign-in link token for the account
+// registered with email. Unknown addresses yield an empty token and no error
+// so callers do not reveal which emails exist.
+func (s *AuthService) RequestMagicLink(ctx context.Context, email string) (string, *repository.User, error) {
+	u, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return "", nil, nil
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.tokenStore.SetMagicLink(ctx, tokenHash, u.ID, MagicLinkTTL); err != nil {
+		return "", nil, err
+	}
+	return token, u, nil
+}
+
+// LoginWithMagicLink consumes a sign-in link. Opening it proves control of
+// the address, so an unverified email is marked verified on the way.
+func (s *AuthService) LoginWithMagicLink(ctx context.Context, token string) (*repository.User, error) {
+	userID, err := s.tokenStore.GetAndDeleteMagicLink(ctx, hashToken(token))
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return nil, ErrInvalidMagicLink
+		}
+		return nil, err
+	}
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+	if !u.EmailVerifiedAt.Valid {
+		if err := s.userRepo.SetEmailVerified(ctx, u.ID); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/storage"
+)
+
+const reapBatchSize = 200
+
+// MessageReaper deletes disappearing messages once they expire.
+type MessageReaper struct {
+	messageRepo *repository.MessageRepository
+	store       *storage.MinIO
+}
+
+func NewMessageReaper(messageRepo *repository.MessageRepository, store *storage.MinIO) *MessageReaper {
+	return &MessageReaper{messageRepo: messageRepo, store: store}
+}
+
+// Run reaps expired messages every interval until ctx is cancelled.
+func (s *MessageReaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.ReapExpired(ctx); err != nil {
+			log.Printf("[reaper] deleting expired messages failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[reaper] deleted %d expired messages", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReapExpired deletes expired messages in batches and returns how many went.
+// Attachments are removed before the rows, so that a storage failure leaves
+// the message for the next run to retry; the cursor moves past it so the
+// rest of the backlog is not held up. Clients hide messages past their
+// expires_at on their own, so no event is sent.
+func (s *MessageReaper) ReapExpired(ctx context.Context) (int, error) {
+	total := 0
+	var cursorTime *time.Time
+	var cursorID *uuid.UUID
+	for {
+		msgs, err := s.messageRepo.ListExpired(ctx, reapBatchSize, cursorTime, cursorID)
+		if err != nil {
+			return total, err
+		}
+		ids := make([]uuid.UUID, 0, len(msgs))
+		for _, m := range msgs {
+			if m.MediaKey != nil {
+				if err := s.store.RemoveObject(ctx, *m.MediaKey); err != nil {
+					log.Printf("[reaper] message=%s: remove %s: %v", m.ID, *m.MediaKey, err)
+					continue
+				}
+			}
+			ids = append(ids, m.ID)
+		}
+		if len(ids) > 0 {
+			if err := s.messageRepo.DeleteExpired(ctx, ids); err != nil {
+				return total, err
+			}
+			total += len(ids)
+		}
+		if len(msgs) < reapBatchSize {
+			return total, nil
+		}
+		last := msgs[len(msgs)-1]
+		cursorTime, cursorID = last.ExpiresAt, &last.ID
+	}
+}
@@ -28,6 +28,10 @@ func (s *SyncService) SyncUser(ctx context.Context, userID uuid.UUID) error {
 	if err != nil {
 		return err
 	}
+	// Accounts pending deletion stay out of discovery until restored.
+	if u.DeletionRequestedAt.Valid {
+		return s.RemoveUser(ctx, userID)
+	}
 	doc := &search.UserDoc{
 		UserID:    u.ID.String(),
 		Username:  u.Username,
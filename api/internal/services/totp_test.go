@@ -0,0 +1,60 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFCVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode(%d) error: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(%d) = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP_Skew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	prev, _ := totpCode(rfcSecret, totpStep(now)-1)
+	old, _ := totpCode(rfcSecret, totpStep(now)-3)
+
+	if step, ok := matchTOTP(rfcSecret, prev, now); !ok || step != totpStep(now)-1 {
+		t.Fatalf("expected previous step code to match, got step=%d ok=%v", step, ok)
+	}
+	if _, ok := matchTOTP(rfcSecret, old, now); ok {
+		t.Fatalf("expected code three steps old to be rejected")
+	}
+	if _, ok := matchTOTP(rfcSecret, "12345", now); ok {
+		t.Fatalf("expected short code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := totpProvisioningURI("ABC", "alice")
+	if !strings.HasPrefix(uri, "otpauth://totp/Matcha:alice?") {
+		t.Fatalf("unexpected uri prefix: %s", uri)
+	}
+	for _, part := range []string{"secret=ABC", "issuer=Matcha", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("uri %q missing %q", uri, part)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TURNCredentials issues credentials for coturn's REST API scheme
+// (use-auth-secret): the username is "<expiry unix>:<user id>" and the
+// password is base64(HMAC-SHA1(secret, username)). coturn recomputes the
+// HMAC with the same shared secret and rejects the username once it expired.
+func TURNCredentials(secret string, userID uuid.UUID, ttl time.Duration, now time.Time) (username, credential string, expiresAt time.Time) {
+	expiresAt = now.Add(ttl).Truncate(time.Second)
+	username = strconv.FormatInt(expiresAt.Unix(), 10) + ":" + userID.String()
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, credential, expiresAt
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	userID := uuid.MustParse("3f1b9a4e-7c2d-4e8f-9a1b-2c3d4e5f6a7b")
+	now := time.Unix(1700000000, 500)
+
+	username, credential, expiresAt := TURNCredentials("turn-secret", userID, time.Hour, now)
+
+	if want := "1700003600:3f1b9a4e-7c2d-4e8f-9a1b-2c3d4e5f6a7b"; username != want {
+		t.Fatalf("username = %q, want %q", username, want)
+	}
+	if want := "eKkO2NPOjdD8turAwR4cwUhndVI="; credential != want {
+		t.Fatalf("credential = %q, want %q", credential, want)
+	}
+	if expiresAt.Unix() != 1700003600 {
+		t.Fatalf("expiresAt = %v, want unix 1700003600", expiresAt)
+	}
+
+	_, other, _ := TURNCredentials("other-secret", userID, time.Hour, now)
+	if other == credential {
+		t.Fatal("credential does not depend on the secret")
+	}
+}
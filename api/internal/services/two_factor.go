@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/store"
+)
+
+var ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+var ErrTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+var ErrTOTPEnrollmentNotFound = errors.New("no pending two-factor enrollment")
+var ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+var ErrInvalidMFAToken = errors.New("invalid or expired mfa token")
+
+const (
+	totpEnrollTTL     = 10 * time.Minute
+	mfaChallengeTTL   = 5 * time.Minute
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// BeginTOTPEnrollment generates a new secret and parks it in Redis until the
+// user proves their authenticator app produces matching codes.
+func (s *AuthService) BeginTOTPEnrollment(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabledAt.Valid {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokenStore.SetTOTPPending(ctx, userID, secret, totpEnrollTTL); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(secret, u.Username),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables 2FA and returns freshly generated recovery
+// codes. The plain codes are only ever returned here.
+func (s *AuthService) ConfirmTOTPEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	secret, err := s.tokenStore.GetTOTPPending(ctx, userID)
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return nil, ErrTOTPEnrollmentNotFound
+		}
+		return nil, err
+	}
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if _, err := s.tokenStore.MarkTOTPStepUsed(ctx, userID, step, totpUsedTTL()); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.EnableTOTP(ctx, userID, secret, hashes); err != nil {
+		return nil, err
+	}
+	_ = s.tokenStore.DeleteTOTPPending(ctx, userID)
+	return codes, nil
+}
+
+func (s *AuthService) DisableTOTP(ctx context.Context, userID uuid.UUID, password, code string) error {
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabledAt.Valid {
+		return ErrTOTPNotEnabled
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	ok, err := s.verifySecondFactor(ctx, u, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	return s.userRepo.DisableTOTP(ctx, userID)
+}
+
+// StartMFAChallenge is called after a correct password for a 2FA user and
+// returns the short-lived token the client exchanges for a session.
+func (s *AuthService) StartMFAChallenge(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.tokenStore.SetMFAChallenge(ctx, tokenHash, userID, mfaChallengeTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AuthService) CompleteMFAChallenge(ctx context.Context, mfaToken, code string) (*repository.User, error) {
+	tokenHash := hashToken(strings.TrimSpace(mfaToken))
+	userID, err := s.tokenStore.TakeMFAAttempt(ctx, tokenHash, maxMFAAttempts)
+	if err != nil {
+		if err == store.ErrTokenNotFound {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	u, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.verifySecondFactor(ctx, u, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if err := s.tokenStore.DeleteMFAChallenge(ctx, tokenHash); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code (each time step only
+// once) or an unused recovery code.
+func (s *AuthService) verifySecondFactor(ctx context.Context, u *repository.User, code string) (bool, error) {
+	if !u.TOTPSecret.Valid {
+		return false, nil
+	}
+	if step, ok := matchTOTP(u.TOTPSecret.String, code, time.Now()); ok {
+		return s.tokenStore.MarkTOTPStepUsed(ctx, u.ID, step, totpUsedTTL())
+	}
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+	return s.userRepo.UseRecoveryCode(ctx, u.ID, hashToken(normalized))
+}
+
+func totpUsedTTL() time.Duration {
+	return time.Duration(2*totpSkew+1) * totpPeriod * time.Second
+}
+
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"matcha/api/internal/audio"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/storage"
+)
+
+const (
+	// voiceWaveformBars is how many bars clients get to draw a voice note.
+	voiceWaveformBars = 64
+	voiceProbeTimeout = 10 * time.Second
+	voiceJobTimeout   = 2 * time.Minute
+)
+
+// VoiceProcessor normalizes voice messages to Opus in an Ogg container and
+// records their duration and waveform, so clients can show them without
+// downloading the file.
+type VoiceProcessor struct {
+	messageRepo *repository.MessageRepository
+	store       *storage.MinIO
+	ffmpeg      *audio.FFmpeg
+	slots       chan struct{}
+}
+
+// NewVoiceProcessor runs at most workers ffmpeg jobs at a time.
+func NewVoiceProcessor(messageRepo *repository.MessageRepository, store *storage.MinIO, ffmpeg *audio.FFmpeg, workers int) *VoiceProcessor {
+	if workers < 1 {
+		workers = 1
+	}
+	return &VoiceProcessor{
+		messageRepo: messageRepo,
+		store:       store,
+		ffmpeg:      ffmpeg,
+		slots:       make(chan struct{}, workers),
+	}
+}
+
+// Validate returns audio.ErrNotAudio if the upload has no audio stream or
+// its start cannot be decoded.
+func (p *VoiceProcessor) Validate(ctx context.Context, data []byte, ext string) error {
+	ctx, cancel := context.WithTimeout(ctx, voiceProbeTimeout)
+	defer cancel()
+
+	f, err := os.CreateTemp("", "matcha-voice-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.ffmpeg.Probe(ctx, f.Name()); err != nil {
+		return err
+	}
+	return p.ffmpeg.CheckDecode(ctx, f.Name())
+}
+
+// Process transcodes the voice message's upload, stores the result next to
+// it and removes the original. It returns the updated message, which is
+// marked failed if processing did not work out, or nil if the message was
+// unsent meanwhile.
+func (p *VoiceProcessor) Process(ctx context.Context, m *repository.Message) (*repository.Message, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	jobCtx, cancel := context.WithTimeout(ctx, voiceJobTimeout)
+	defer cancel()
+	processed, newKey, err := p.transcode(jobCtx, m)
+	if err != nil {
+		// The job context may be what ran out.
+		failed, ferr := p.messageRepo.SetVoiceFailed(context.WithoutCancel(ctx), m.ID)
+		if ferr != nil {
+			return nil, fmt.Errorf("%v (and marking it failed: %v)", err, ferr)
+		}
+		return failed, err
+	}
+	if processed == nil {
+		// Unsent while we were working: the new file has no message left.
+		_ = p.store.RemoveObject(context.WithoutCancel(ctx), newKey)
+		return nil, nil
+	}
+	if err := p.store.RemoveObject(jobCtx, *m.MediaKey); err != nil {
+		return processed, fmt.Errorf("remove original: %w", err)
+	}
+	return processed, nil
+}
+
+func (p *VoiceProcessor) transcode(ctx context.Context, m *repository.Message) (*repository.Message, string, error) {
+	if m.MediaKey == nil {
+		return nil, "", fmt.Errorf("message has no voice file")
+	}
+	dir, err := os.MkdirTemp("", "matcha-voice-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in"+filepath.Ext(*m.MediaKey))
+	if err := p.download(ctx, *m.MediaKey, in); err != nil {
+		return nil, "", fmt.Errorf("download: %w", err)
+	}
+	out := filepath.Join(dir, "out.ogg")
+	if err := p.ffmpeg.Transcode(ctx, in, out); err != nil {
+		return nil, "", fmt.Errorf("transcode: %w", err)
+	}
+	analysis, err := p.ffmpeg.Analyze(ctx, out, voiceWaveformBars)
+	if err != nil {
+		return nil, "", fmt.Errorf("analyze: %w", err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	newKey := "voice/" + m.SenderID.String() + "/" + uuid.NewString() + ".ogg"
+	mediaURL, err := p.store.PutObject(ctx, newKey, f, info.Size(), "audio/ogg")
+	if err != nil {
+		return nil, "", fmt.Errorf("upload: %w", err)
+	}
+
+	processed, err := p.messageRepo.SetVoiceReady(ctx, m.ID, mediaURL, newKey, repository.VoiceMetadata{
+		DurationMs: analysis.Duration.Milliseconds(),
+		Waveform:   analysis.Waveform,
+	})
+	if err != nil {
+		_ = p.store.RemoveObject(context.WithoutCancel(ctx), newKey)
+		return nil, "", err
+	}
+	return processed, newKey, nil
+}
+
+func (p *VoiceProcessor) download(ctx context.Context, key, path string) error {
+	obj, err := p.store.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, obj); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ListUnfinished returns voice messages left in processing, e.g. by a
+// restart, so they can be queued again. Recent uploads are skipped: another
+// replica may still be working on them.
+func (p *VoiceProcessor) ListUnfinished(ctx context.Context) ([]repository.Message, error) {
+	return p.messageRepo.ListProcessingVoice(ctx, time.Now().Add(-2*voiceJobTimeout), 500)
+}
@@ -8,10 +8,19 @@ import (
 	"path"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
 )
 
+// PublicPrefixes are the object key prefixes served directly by MinIO.
+var PublicPrefixes = []string{"users/", "voice/"}
+
+// ChatPrefix holds chat attachments, one folder per conversation. It is not
+// public: the API serves the files to the two participants only.
+const ChatPrefix = "chat/"
+
 type MinIO struct {
 	client        *minio.Client
 	endpoint      string
@@ -69,6 +78,54 @@ func (m *MinIO) RemoveObject(ctx context.Context, objectKey string) error {
 	return m.client.RemoveObject(ctx, m.bucket, objectKey, minio.RemoveObjectOptions{})
 }
 
+// RemovePrefix deletes every object whose key starts with prefix.
+func (m *MinIO) RemovePrefix(ctx context.Context, prefix string) error {
+	objects := m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	toRemove := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(toRemove)
+		for obj := range objects {
+			if obj.Err != nil {
+				listErr <- obj.Err
+				return
+			}
+			select {
+			case toRemove <- obj:
+			case <-ctx.Done():
+				listErr <- ctx.Err()
+				return
+			}
+		}
+		listErr <- nil
+	}()
+	var removeErr error
+	for res := range m.client.RemoveObjects(ctx, m.bucket, toRemove, minio.RemoveObjectsOptions{}) {
+		if res.Err != nil && removeErr == nil {
+			removeErr = fmt.Errorf("remove %s: %w", res.ObjectName, res.Err)
+		}
+	}
+	if err := <-listErr; err != nil {
+		return err
+	}
+	return removeErr
+}
+
+func (m *MinIO) ListObjectKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+func (m *MinIO) StatObject(ctx context.Context, objectKey string) (minio.ObjectInfo, error) {
+	return m.client.StatObject(ctx, m.bucket, objectKey, minio.StatObjectOptions{})
+}
+
 func (m *MinIO) GetObject(ctx context.Context, objectKey string) (*minio.Object, error) {
 	return m.client.GetObject(ctx, m.bucket, objectKey, minio.GetObjectOptions{})
 }
@@ -93,11 +150,49 @@ func (m *MinIO) ObjectURL(objectKey string) string {
 	return u.String()
 }
 
+// ensurePublicReadPolicy makes profile photos and voice notes readable by
+// URL. Everything else (e.g. exports/) stays private, and the bucket cannot
+// be listed anonymously.
 func (m *MinIO) ensurePublicReadPolicy(ctx context.Context) error {
-	policy := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/*"]},{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::%s"]}]}`, m.bucket, m.bucket)
+	resources := make([]string, len(PublicPrefixes))
+	for i, p := range PublicPrefixes {
+		resources[i] = fmt.Sprintf(`"arn:aws:s3:::%s/%s*"`, m.bucket, p)
+	}
+	policy := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":[%s]}]}`, strings.Join(resources, ","))
 	return m.client.SetBucketPolicy(ctx, m.bucket, policy)
 }
 
+// ExpireObjects installs a lifecycle rule deleting objects under prefix
+// after the given number of days. Other rules already on the bucket are
+// kept.
+func (m *MinIO) ExpireObjects(ctx context.Context, prefix string, days int) error {
+	cfg, err := m.client.GetBucketLifecycle(ctx, m.bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return err
+		}
+		cfg = lifecycle.NewConfiguration()
+	}
+	withLifecycleRule(cfg, lifecycle.Rule{
+		ID:         "expire-" + strings.Trim(prefix, "/"),
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: prefix},
+		Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(days)},
+	})
+	return m.client.SetBucketLifecycle(ctx, m.bucket, cfg)
+}
+
+// withLifecycleRule adds rule to cfg, replacing a rule with the same ID.
+func withLifecycleRule(cfg *lifecycle.Configuration, rule lifecycle.Rule) {
+	for i := range cfg.Rules {
+		if cfg.Rules[i].ID == rule.ID {
+			cfg.Rules[i] = rule
+			return
+		}
+	}
+	cfg.Rules = append(cfg.Rules, rule)
+}
+
 func BuildPhotoObjectKey(userID, photoID string, fileName string) string {
 	ext := ""
 	if idx := strings.LastIndex(fileName, "."); idx >= 0 && idx < len(fileName)-1 {
@@ -108,3 +203,12 @@ func BuildPhotoObjectKey(userID, photoID string, fileName string) string {
 	}
 	return fmt.Sprintf("users/%s/%s%s", userID, photoID, strings.ToLower(ext))
 }
+
+// BuildChatObjectKey places a chat attachment in the folder of the
+// conversation between a and b, which is the same whoever sends it.
+func BuildChatObjectKey(a, b uuid.UUID, fileID, ext string) string {
+	if b.String() < a.String() {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%s%s_%s/%s%s", ChatPrefix, a, b, fileID, strings.ToLower(ext))
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func TestBuildChatObjectKey(t *testing.T) {
+	a := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	b := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+
+	got := BuildChatObjectKey(a, b, "f", ".PNG")
+	want := "chat/11111111-1111-1111-1111-111111111111_22222222-2222-2222-2222-222222222222/f.png"
+	if got != want {
+		t.Fatalf("BuildChatObjectKey(a, b) = %q, want %q", got, want)
+	}
+	if other := BuildChatObjectKey(b, a, "f", ".png"); other != want {
+		t.Fatalf("BuildChatObjectKey(b, a) = %q, want same folder as (a, b)", other)
+	}
+	if !strings.HasPrefix(got, ChatPrefix) {
+		t.Fatalf("key %q is not under %q", got, ChatPrefix)
+	}
+}
+
+func TestWithLifecycleRule(t *testing.T) {
+	cfg := lifecycle.NewConfiguration()
+	cfg.Rules = []lifecycle.Rule{{ID: "admin-tmp", Status: "Enabled"}}
+
+	withLifecycleRule(cfg, lifecycle.Rule{ID: "expire-exports", Expiration: lifecycle.Expiration{Days: 7}})
+	withLifecycleRule(cfg, lifecycle.Rule{ID: "expire-exports", Expiration: lifecycle.Expiration{Days: 3}})
+
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("got %d rules, want the existing rule plus one export rule", len(cfg.Rules))
+	}
+	if cfg.Rules[0].ID != "admin-tmp" {
+		t.Errorf("existing rule was replaced: %+v", cfg.Rules[0])
+	}
+	if got := cfg.Rules[1].Expiration.Days; got != 3 {
+		t.Errorf("export rule expires after %d days, want the updated 3", got)
+	}
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixCall     = "matcha:call:"
+	prefixUserCall = "matcha:user_call:"
+	// keyCallRingDeadlines holds the ringing calls scored by when they time
+	// out, so any replica can expire them, including after a restart.
+	keyCallRingDeadlines = "matcha:call_ring_deadlines"
+)
+
+// Live call states.
+const (
+	CallRinging = "ringing"
+	CallActive  = "active"
+)
+
+// LiveCall is a call that is ringing or in progress. Both participants are
+// marked busy until it ends.
+type LiveCall struct {
+	ID         string
+	CallerID   uuid.UUID
+	CalleeID   uuid.UUID
+	Mode       string
+	State      string
+	StartedAt  time.Time
+	AnsweredAt *time.Time
+}
+
+// startCallScript registers call ARGV[1] in KEYS[1], marks both
+// participants busy (KEYS[2], KEYS[3]) and schedules its ring timeout at
+// ARGV[7] in KEYS[4].
+var startCallScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 'exists'
+end
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 'caller_busy'
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 'callee_busy'
+end
+redis.call('HSET', KEYS[1], 'caller', ARGV[2], 'callee', ARGV[3], 'mode', ARGV[4], 'state', 'ringing', 'started_at', ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[6])
+redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[6])
+redis.call('SET', KEYS[3], ARGV[1], 'PX', ARGV[6])
+redis.call('ZADD', KEYS[4], ARGV[7], ARGV[1])
+return 'ok'
+`)
+
+// answerCallScript moves ringing call KEYS[1] to active if ARGV[1] is its
+// callee, extending the busy marks KEYS[2] (caller) and KEYS[3] (callee) and
+// dropping its ring timeout from KEYS[4]. ARGV[4] and ARGV[5] are the
+// participants the keys were built from.
+var answerCallScript = redis.NewScript(`
+local rec = redis.call('HMGET', KEYS[1], 'caller', 'callee', 'state')
+if not rec[1] or rec[1] ~= ARGV[4] or rec[2] ~= ARGV[5] then
+	return false
+end
+if rec[2] ~= ARGV[1] or rec[3] ~= 'ringing' then
+	return false
+end
+redis.call('HSET', KEYS[1], 'state', 'active', 'answered_at', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[3])
+redis.call('ZREM', KEYS[4], ARGV[6])
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// endCallScript removes call ARGV[1] (only if it is in state ARGV[2], when
+// given), drops its ring timeout from KEYS[4] and clears the busy marks
+// KEYS[2] and KEYS[3] that still point at it. ARGV[3] and ARGV[4] are the
+// participants the keys were built from. It returns the call as it was.
+var endCallScript = redis.NewScript(`
+local rec = redis.call('HGETALL', KEYS[1])
+if #rec == 0 then
+	redis.call('ZREM', KEYS[4], ARGV[1])
+	return false
+end
+local h = {}
+for i = 1, #rec, 2 do
+	h[rec[i]] = rec[i + 1]
+end
+if h['caller'] ~= ARGV[3] or h['callee'] ~= ARGV[4] then
+	return false
+end
+redis.call('ZREM', KEYS[4], ARGV[1])
+if ARGV[2] ~= '' and h['state'] ~= ARGV[2] then
+	return false
+end
+redis.call('DEL', KEYS[1])
+for _, key in ipairs({KEYS[2], KEYS[3]}) do
+	if redis.call('GET', key) == ARGV[1] then
+		redis.call('DEL', key)
+	end
+end
+return rec
+`)
+
+// StartCall registers a ringing call. It fails with ErrCallExists for a
+// reused id and ErrCallerBusy/ErrCalleeBusy when either side is already in
+// a call. The call shows up in DueRingingCalls after ring; ttl bounds how
+// long it may ring if nobody ends it.
+func (s *TokenStore) StartCall(ctx context.Context, c LiveCall, ring, ttl time.Duration) error {
+	res, err := startCallScript.Run(ctx, s.client,
+		callKeys(c.ID, c.CallerID.String(), c.CalleeID.String()),
+		c.ID, c.CallerID.String(), c.CalleeID.String(), c.Mode, c.StartedAt.UnixMilli(), ttl.Milliseconds(),
+		c.StartedAt.Add(ring).UnixMilli(),
+	).Text()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case "ok":
+		return nil
+	case "exists":
+		return ErrCallExists
+	case "caller_busy":
+		return ErrCallerBusy
+	case "callee_busy":
+		return ErrCalleeBusy
+	}
+	return fmt.Errorf("unexpected start call reply: %s", res)
+}
+
+// AnswerCall marks a ringing call as answered by calleeID; ttl then bounds
+// the length of the call.
+func (s *TokenStore) AnswerCall(ctx context.Context, callID string, calleeID uuid.UUID, ttl time.Duration) (*LiveCall, error) {
+	caller, callee, err := s.callParticipants(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := answerCallScript.Run(ctx, s.client, callKeys(callID, caller, callee),
+		calleeID.String(), time.Now().UnixMilli(), ttl.Milliseconds(), caller, callee, callID,
+	).StringSlice()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrCallNotFound
+		}
+		return nil, err
+	}
+	return parseLiveCall(callID, res)
+}
+
+// GetCall returns a live call.
+func (s *TokenStore) GetCall(ctx context.Context, callID string) (*LiveCall, error) {
+	res, err := s.client.HGetAll(ctx, prefixCall+callID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrCallNotFound
+	}
+	flat := make([]string, 0, 2*len(res))
+	for k, v := range res {
+		flat = append(flat, k, v)
+	}
+	return parseLiveCall(callID, flat)
+}
+
+// UserCall returns the id of the call userID is in.
+func (s *TokenStore) UserCall(ctx context.Context, userID uuid.UUID) (string, error) {
+	val, err := s.client.Get(ctx, prefixUserCall+userID.String()).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", ErrCallNotFound
+		}
+		return "", err
+	}
+	return val, nil
+}
+
+// EndCall removes a call and returns it as it was before ending.
+func (s *TokenStore) EndCall(ctx context.Context, callID string) (*LiveCall, error) {
+	return s.endCall(ctx, callID, "")
+}
+
+// EndRingingCall ends a call only if it was never answered; used for
+// ringing timeouts racing an accept.
+func (s *TokenStore) EndRingingCall(ctx context.Context, callID string) (*LiveCall, error) {
+	return s.endCall(ctx, callID, CallRinging)
+}
+
+// DueRingingCalls returns up to limit calls whose ring timeout has passed at
+// now. Expiring them is left to EndRingingCall, which is safe to race.
+func (s *TokenStore) DueRingingCalls(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, keyCallRingDeadlines, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+func (s *TokenStore) endCall(ctx context.Context, callID, state string) (*LiveCall, error) {
+	caller, callee, err := s.callParticipants(ctx, callID)
+	if err == ErrCallNotFound {
+		// The call expired on its own; forget its ring timeout too.
+		if err := s.client.ZRem(ctx, keyCallRingDeadlines, callID).Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrCallNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := endCallScript.Run(ctx, s.client, callKeys(callID, caller, callee),
+		callID, state, caller, callee,
+	).StringSlice()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrCallNotFound
+		}
+		return nil, err
+	}
+	return parseLiveCall(callID, res)
+}
+
+// callParticipants reads who takes part in a call so the scripts can be
+// given every key they touch; the scripts check the record still matches.
+func (s *TokenStore) callParticipants(ctx context.Context, callID string) (caller, callee string, err error) {
+	rec, err := s.client.HMGet(ctx, prefixCall+callID, "caller", "callee").Result()
+	if err != nil {
+		return "", "", err
+	}
+	caller, ok1 := rec[0].(string)
+	callee, ok2 := rec[1].(string)
+	if !ok1 || !ok2 {
+		return "", "", ErrCallNotFound
+	}
+	return caller, callee, nil
+}
+
+func callKeys(callID, caller, callee string) []string {
+	return []string{prefixCall + callID, prefixUserCall + caller, prefixUserCall + callee, keyCallRingDeadlines}
+}
+
+func parseLiveCall(callID string, flat []string) (*LiveCall, error) {
+	h := make(map[string]string, len(flat)/2)
+	for i := 0; i+1 < len(flat); i += 2 {
+		h[flat[i]] = flat[i+1]
+	}
+	c := &LiveCall{ID: callID, Mode: h["mode"], State: h["state"]}
+	var err error
+	if c.CallerID, err = uuid.Parse(h["caller"]); err != nil {
+		return nil, fmt.Errorf("call %s: caller: %w", callID, err)
+	}
+	if c.CalleeID, err = uuid.Parse(h["callee"]); err != nil {
+		return nil, fmt.Errorf("call %s: callee: %w", callID, err)
+	}
+	ms, err := strconv.ParseInt(h["started_at"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("call %s: started_at: %w", callID, err)
+	}
+	c.StartedAt = time.UnixMilli(ms).UTC()
+	if v, ok := h["answered_at"]; ok {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: answered_at: %w", callID, err)
+		}
+		t := time.UnixMilli(ms).UTC()
+		c.AnsweredAt = &t
+	}
+	return c, nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// These tests run the call scripts against a real Redis, e.g.
+// TEST_REDIS_URL=redis://localhost:6379/15 go test ./internal/store
+func testTokenStore(t *testing.T) *TokenStore {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("set TEST_REDIS_URL to run Redis tests")
+	}
+	s, err := NewTokenStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func newTestCall() LiveCall {
+	return LiveCall{
+		ID:        uuid.NewString(),
+		CallerID:  uuid.New(),
+		CalleeID:  uuid.New(),
+		Mode:      "audio",
+		State:     CallRinging,
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+func TestStartCallBusy(t *testing.T) {
+	s := testTokenStore(t)
+	ctx := context.Background()
+	c := newTestCall()
+	if err := s.StartCall(ctx, c, time.Minute, time.Minute); err != nil {
+		t.Fatalf("StartCall: %v", err)
+	}
+	defer s.EndCall(ctx, c.ID)
+
+	if err := s.StartCall(ctx, c, time.Minute, time.Minute); err != ErrCallExists {
+		t.Errorf("reused id = %v, want ErrCallExists", err)
+	}
+	other := newTestCall()
+	other.CallerID = c.CalleeID
+	if err := s.StartCall(ctx, other, time.Minute, time.Minute); err != ErrCallerBusy {
+		t.Errorf("busy caller = %v, want ErrCallerBusy", err)
+	}
+	other = newTestCall()
+	other.CalleeID = c.CallerID
+	if err := s.StartCall(ctx, other, time.Minute, time.Minute); err != ErrCalleeBusy {
+		t.Errorf("busy callee = %v, want ErrCalleeBusy", err)
+	}
+}
+
+func TestAnswerAndEndCall(t *testing.T) {
+	s := testTokenStore(t)
+	ctx := context.Background()
+	c := newTestCall()
+	if err := s.StartCall(ctx, c, time.Minute, time.Minute); err != nil {
+		t.Fatalf("StartCall: %v", err)
+	}
+
+	if _, err := s.AnswerCall(ctx, c.ID, c.CallerID, time.Hour); err != ErrCallNotFound {
+		t.Fatalf("answer by caller = %v, want ErrCallNotFound", err)
+	}
+	got, err := s.AnswerCall(ctx, c.ID, c.CalleeID, time.Hour)
+	if err != nil {
+		t.Fatalf("AnswerCall: %v", err)
+	}
+	if got.State != CallActive || got.AnsweredAt == nil {
+		t.Fatalf("answered call = %+v, want active with answered_at", got)
+	}
+	if _, err := s.AnswerCall(ctx, c.ID, c.CalleeID, time.Hour); err != ErrCallNotFound {
+		t.Fatalf("second answer = %v, want ErrCallNotFound", err)
+	}
+	if _, err := s.EndRingingCall(ctx, c.ID); err != ErrCallNotFound {
+		t.Fatalf("EndRingingCall on active call = %v, want ErrCallNotFound", err)
+	}
+
+	ended, err := s.EndCall(ctx, c.ID)
+	if err != nil {
+		t.Fatalf("EndCall: %v", err)
+	}
+	if ended.State != CallActive || ended.CallerID != c.CallerID || ended.CalleeID != c.CalleeID {
+		t.Fatalf("ended call = %+v", ended)
+	}
+	for _, id := range []uuid.UUID{c.CallerID, c.CalleeID} {
+		if _, err := s.UserCall(ctx, id); err != ErrCallNotFound {
+			t.Errorf("UserCall(%s) after end = %v, want ErrCallNotFound", id, err)
+		}
+	}
+	if _, err := s.EndCall(ctx, c.ID); err != ErrCallNotFound {
+		t.Errorf("second EndCall = %v, want ErrCallNotFound", err)
+	}
+}
+
+func TestEndCallKeepsNewerBusyMark(t *testing.T) {
+	s := testTokenStore(t)
+	ctx := context.Background()
+	c := newTestCall()
+	if err := s.StartCall(ctx, c, time.Minute, time.Minute); err != nil {
+		t.Fatalf("StartCall: %v", err)
+	}
+	// The caller's busy mark already points at another call.
+	if err := s.client.Set(ctx, prefixUserCall+c.CallerID.String(), "other", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.client.Del(ctx, prefixUserCall+c.CallerID.String())
+
+	if _, err := s.EndCall(ctx, c.ID); err != nil {
+		t.Fatalf("EndCall: %v", err)
+	}
+	if id, err := s.UserCall(ctx, c.CallerID); err != nil || id != "other" {
+		t.Errorf("caller busy mark = %q, %v; want other", id, err)
+	}
+	if _, err := s.UserCall(ctx, c.CalleeID); err != ErrCallNotFound {
+		t.Errorf("callee busy mark after end = %v, want ErrCallNotFound", err)
+	}
+}
+
+func TestDueRingingCalls(t *testing.T) {
+	s := testTokenStore(t)
+	ctx := context.Background()
+	c := newTestCall()
+	c.StartedAt = time.Now().Add(-time.Hour).UTC()
+	if err := s.StartCall(ctx, c, time.Second, time.Minute); err != nil {
+		t.Fatalf("StartCall: %v", err)
+	}
+
+	if !dueContains(t, s, c.ID) {
+		t.Fatal("timed out call missing from DueRingingCalls")
+	}
+	if _, err := s.EndRingingCall(ctx, c.ID); err != nil {
+		t.Fatalf("EndRingingCall: %v", err)
+	}
+	if dueContains(t, s, c.ID) {
+		t.Fatal("ended call still in DueRingingCalls")
+	}
+}
+
+func dueContains(t *testing.T, s *TokenStore, callID string) bool {
+	t.Helper()
+	ids, err := s.DueRingingCalls(context.Background(), time.Now(), 1000)
+	if err != nil {
+		t.Fatalf("DueRingingCalls: %v", err)
+	}
+	for _, id := range ids {
+		if id == callID {
+			return true
+		}
+	}
+	return false
+}
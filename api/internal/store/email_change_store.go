@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixEmailChange        = "matcha:email_change:"
+	prefixEmailChangeConfirm = "matcha:email_change_confirm:"
+	prefixEmailChangeCancel  = "matcha:email_change_cancel:"
+)
+
+// EmailChange is a pending email address change awaiting confirmation from
+// the new address.
+type EmailChange struct {
+	UserID   uuid.UUID
+	NewEmail string
+}
+
+// takeEmailChangeScript removes a pending change (KEYS[1]) together with its
+// confirm and cancel tokens (KEYS[2], KEYS[3]), provided the record still
+// belongs to ARGV[1] with the token hashes ARGV[3] and ARGV[4] and the token
+// named by ARGV[2] still points at it. Tokens of a superseded change no
+// longer match the record and are rejected.
+var takeEmailChangeScript = redis.NewScript(`
+local token = KEYS[2]
+if ARGV[2] == 'cancel' then
+	token = KEYS[3]
+end
+if redis.call('GET', token) ~= ARGV[1] then
+	return false
+end
+local rec = redis.call('HMGET', KEYS[1], 'new_email', 'confirm', 'cancel')
+if not rec[1] or rec[2] ~= ARGV[3] or rec[3] ~= ARGV[4] then
+	return false
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return rec[1]
+`)
+
+// SetEmailChange stores a pending change for userID, replacing (and
+// invalidating the links of) any previous one.
+func (s *TokenStore) SetEmailChange(ctx context.Context, userID uuid.UUID, newEmail, confirmHash, cancelHash string, ttl time.Duration) error {
+	key := prefixEmailChange + userID.String()
+	prev, err := s.client.HMGet(ctx, key, "confirm", "cancel").Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	if h, ok := prev[0].(string); ok {
+		pipe.Del(ctx, prefixEmailChangeConfirm+h)
+	}
+	if h, ok := prev[1].(string); ok {
+		pipe.Del(ctx, prefixEmailChangeCancel+h)
+	}
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "new_email", newEmail, "confirm", confirmHash, "cancel", cancelHash)
+	pipe.Expire(ctx, key, ttl)
+	pipe.Set(ctx, prefixEmailChangeConfirm+confirmHash, userID.String(), ttl)
+	pipe.Set(ctx, prefixEmailChangeCancel+cancelHash, userID.String(), ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetEmailChange returns the address userID is currently changing to.
+func (s *TokenStore) GetEmailChange(ctx context.Context, userID uuid.UUID) (string, error) {
+	val, err := s.client.HGet(ctx, prefixEmailChange+userID.String(), "new_email").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", ErrTokenNotFound
+		}
+		return "", err
+	}
+	return val, nil
+}
+
+func (s *TokenStore) TakeEmailChangeConfirm(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	return s.takeEmailChange(ctx, prefixEmailChangeConfirm+tokenHash, "confirm")
+}
+
+func (s *TokenStore) TakeEmailChangeCancel(ctx context.Context, tokenHash string) (*EmailChange, error) {
+	return s.takeEmailChange(ctx, prefixEmailChangeCancel+tokenHash, "cancel")
+}
+
+// takeEmailChange resolves the token at key to its pending change and
+// removes the change atomically. The record is read first so that every key
+// the script touches can be passed in KEYS.
+func (s *TokenStore) takeEmailChange(ctx context.Context, key, kind string) (*EmailChange, error) {
+	uid, err := s.client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		return nil, err
+	}
+	pending := prefixEmailChange + uid
+	rec, err := s.client.HMGet(ctx, pending, "confirm", "cancel").Result()
+	if err != nil {
+		return nil, err
+	}
+	confirm, ok1 := rec[0].(string)
+	cancel, ok2 := rec[1].(string)
+	if !ok1 || !ok2 {
+		return nil, ErrTokenNotFound
+	}
+	keys := []string{pending, prefixEmailChangeConfirm + confirm, prefixEmailChangeCancel + cancel}
+	if (kind == "confirm" && key != keys[1]) || (kind == "cancel" && key != keys[2]) {
+		return nil, ErrTokenNotFound
+	}
+	email, err := takeEmailChangeScript.Run(ctx, s.client, keys, uid, kind, confirm, cancel).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return &EmailChange{UserID: id, NewEmail: email}, nil
+}
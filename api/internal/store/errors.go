@@ -3,3 +3,8 @@ package store
 import "errors"
 
 var ErrTokenNotFound = errors.New("token not found or expired")
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+var ErrCallNotFound = errors.New("call not found or already over")
+var ErrCallExists = errors.New("call id already in use")
+var ErrCallerBusy = errors.New("caller is already in a call")
+var ErrCalleeBusy = errors.New("callee is already in a call")
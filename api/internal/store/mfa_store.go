@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixTOTPPending  = "matcha:totp_pending:"
+	prefixTOTPUsedStep = "matcha:totp_used:"
+	prefixMFAChallenge = "matcha:mfa_pending:"
+)
+
+func (s *TokenStore) SetTOTPPending(ctx context.Context, userID uuid.UUID, secret string, ttl time.Duration) error {
+	return s.client.Set(ctx, prefixTOTPPending+userID.String(), secret, ttl).Err()
+}
+
+func (s *TokenStore) GetTOTPPending(ctx context.Context, userID uuid.UUID) (string, error) {
+	val, err := s.client.Get(ctx, prefixTOTPPending+userID.String()).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", ErrTokenNotFound
+		}
+		return "", err
+	}
+	return val, nil
+}
+
+func (s *TokenStore) DeleteTOTPPending(ctx context.Context, userID uuid.UUID) error {
+	return s.client.Del(ctx, prefixTOTPPending+userID.String()).Err()
+}
+
+// MarkTOTPStepUsed records that a code for the given time step was accepted.
+// It returns false if the step was already used, so a code cannot be replayed.
+func (s *TokenStore) MarkTOTPStepUsed(ctx context.Context, userID uuid.UUID, step int64, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("%s%s:%d", prefixTOTPUsedStep, userID.String(), step)
+	return s.client.SetNX(ctx, key, 1, ttl).Result()
+}
+
+func (s *TokenStore) SetMFAChallenge(ctx context.Context, tokenHash string, userID uuid.UUID, ttl time.Duration) error {
+	key := prefixMFAChallenge + tokenHash
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID.String(), "attempts", 0)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// takeMFAAttemptScript counts an attempt on challenge KEYS[1] and returns
+// its user ID, or false if the challenge is gone or already had ARGV[1]
+// attempts (it is then deleted). Counting before the code is checked means
+// parallel requests cannot get more guesses than the cap.
+var takeMFAAttemptScript = redis.NewScript(`
+local uid = redis.call('HGET', KEYS[1], 'user_id')
+if not uid then
+	return false
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if attempts > tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[1])
+	return false
+end
+return uid
+`)
+
+// TakeMFAAttempt uses up one of the at most max attempts of a challenge and
+// returns the user it belongs to, or ErrTokenNotFound if none are left.
+func (s *TokenStore) TakeMFAAttempt(ctx context.Context, tokenHash string, max int) (uuid.UUID, error) {
+	val, err := takeMFAAttemptScript.Run(ctx, s.client, []string{prefixMFAChallenge + tokenHash}, max).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return uuid.Nil, ErrTokenNotFound
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(val)
+}
+
+func (s *TokenStore) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	return s.client.Del(ctx, prefixMFAChallenge+tokenHash).Err()
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixRateWindow = "matcha:rl:"
+	prefixRateBlock  = "matcha:rl_block:"
+)
+
+// slidingWindowScript trims entries older than the window from a sorted set
+// of hit timestamps, optionally records a new hit, and returns the number of
+// hits left in the window together with the oldest one (unix ms).
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if ARGV[3] == '1' then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+end
+local n = redis.call('ZCARD', KEYS[1])
+local first = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local oldest = 0
+if first[2] then
+	oldest = tonumber(first[2])
+end
+return {n, oldest}
+`)
+
+// RecordHit adds a hit to the sliding window stored under key and returns
+// the hit count inside the window and the time of the oldest counted hit.
+func (s *TokenStore) RecordHit(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	return s.slidingWindow(ctx, key, window, true)
+}
+
+func (s *TokenStore) CountHits(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	return s.slidingWindow(ctx, key, window, false)
+}
+
+func (s *TokenStore) ClearHits(ctx context.Context, keys ...string) error {
+	full := make([]string, len(keys))
+	for i, k := range keys {
+		full[i] = prefixRateWindow + k
+	}
+	return s.client.Del(ctx, full...).Err()
+}
+
+// SetBlock blocks key for ttl. It returns false if a block was already in
+// place, in which case the existing expiry is kept.
+func (s *TokenStore) SetBlock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, prefixRateBlock+key, 1, ttl).Result()
+}
+
+// BlockTTL returns the longest remaining block among keys, or 0 if none of
+// them is blocked.
+func (s *TokenStore) BlockTTL(ctx context.Context, keys ...string) (time.Duration, error) {
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.PTTL(ctx, prefixRateBlock+k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	for _, cmd := range cmds {
+		if d := cmd.Val(); d > longest {
+			longest = d
+		}
+	}
+	return longest, nil
+}
+
+func (s *TokenStore) slidingWindow(ctx context.Context, key string, window time.Duration, add bool) (int64, time.Time, error) {
+	now := time.Now().UnixMilli()
+	addFlag := "0"
+	if add {
+		addFlag = "1"
+	}
+	res, err := slidingWindowScript.Run(ctx, s.client, []string{prefixRateWindow + key}, now, window.Milliseconds(), addFlag, uuid.NewString()).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(res) != 2 {
+		return 0, time.Time{}, fmt.Errorf("unexpected sliding window reply: %v", res)
+	}
+	if res[1] == 0 {
+		return res[0], time.Time{}, nil
+	}
+	return res[0], time.UnixMilli(res[1]), nil
+}
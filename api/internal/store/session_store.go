@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixSession      = "matcha:session:"
+	prefixUserSessions = "matcha:user_sessions:"
+)
+
+type Session struct {
+	ID         string
+	UserID     uuid.UUID
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	IP         string
+	UserAgent  string
+}
+
+// rotateRefreshScript swaps the stored refresh hash only when the presented
+// one matches, so two concurrent refreshes cannot both succeed.
+// Returns -1 when the session is gone, 0 on mismatch, 1 on success.
+var rotateRefreshScript = redis.NewScript(`
+local cur = redis.call('HGET', KEYS[1], 'refresh_hash')
+if not cur then
+	return -1
+end
+if cur ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'refresh_hash', ARGV[2], 'last_used_at', ARGV[4], 'ip', ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+var touchSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'last_used_at', ARGV[1], 'ip', ARGV[2])
+return 1
+`)
+
+// ackEventsScript raises the session's acknowledged event seq; acks that
+// arrive out of order never move it backwards.
+var ackEventsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local cur = tonumber(redis.call('HGET', KEYS[1], 'event_ack') or '0')
+if tonumber(ARGV[1]) > cur then
+	redis.call('HSET', KEYS[1], 'event_ack', ARGV[1])
+end
+return 1
+`)
+
+func (s *TokenStore) CreateSession(ctx context.Context, sessionID string, userID uuid.UUID, refreshHash, ip, userAgent string, ttl time.Duration) error {
+	key := prefixSession + sessionID
+	setKey := prefixUserSessions + userID.String()
+	now := time.Now().UTC().Unix()
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, map[string]any{
+		"user_id":      userID.String(),
+		"refresh_hash": refreshHash,
+		"created_at":   now,
+		"last_used_at": now,
+		"ip":           ip,
+		"user_agent":   userAgent,
+	})
+	pipe.Expire(ctx, key, ttl)
+	pipe.SAdd(ctx, setKey, sessionID)
+	pipe.Expire(ctx, setKey, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *TokenStore) RotateRefresh(ctx context.Context, sessionID, oldHash, newHash, ip string, ttl time.Duration) (uuid.UUID, error) {
+	key := prefixSession + sessionID
+	now := time.Now().UTC().Unix()
+	res, err := rotateRefreshScript.Run(ctx, s.client, []string{key}, oldHash, newHash, ttl.Milliseconds(), now, ip).Int()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	userID, err := s.sessionUserID(ctx, sessionID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	switch res {
+	case 1:
+		if err := s.client.Expire(ctx, prefixUserSessions+userID.String(), ttl).Err(); err != nil {
+			return uuid.Nil, err
+		}
+		return userID, nil
+	case 0:
+		// An old refresh token was replayed: assume it leaked and kill the session.
+		if err := s.RevokeSession(ctx, userID, sessionID); err != nil {
+			return uuid.Nil, err
+		}
+		return uuid.Nil, ErrRefreshTokenReused
+	default:
+		return uuid.Nil, ErrTokenNotFound
+	}
+}
+
+// TouchSession records activity on a session and reports whether it is
+// still active.
+func (s *TokenStore) TouchSession(ctx context.Context, sessionID, ip string) (bool, error) {
+	now := time.Now().UTC().Unix()
+	res, err := touchSessionScript.Run(ctx, s.client, []string{prefixSession + sessionID}, now, ip).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// AckEvents records that the client of sessionID has processed every
+// websocket event up to seq.
+func (s *TokenStore) AckEvents(ctx context.Context, sessionID string, seq int64) error {
+	return ackEventsScript.Run(ctx, s.client, []string{prefixSession + sessionID}, seq).Err()
+}
+
+// EventAck returns the last acknowledged event seq of sessionID (0 if none).
+func (s *TokenStore) EventAck(ctx context.Context, sessionID string) (int64, error) {
+	val, err := s.client.HGet(ctx, prefixSession+sessionID, "event_ack").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return val, err
+}
+
+func (s *TokenStore) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	vals, err := s.client.HGetAll(ctx, prefixSession+sessionID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, ErrTokenNotFound
+	}
+	return parseSession(sessionID, vals)
+}
+
+// ListUserSessions returns the user's active sessions and drops IDs of
+// sessions that already expired from the per-user index.
+func (s *TokenStore) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	setKey := prefixUserSessions + userID.String()
+	ids, err := s.client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, prefixSession+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	var stale []any
+	for i, cmd := range cmds {
+		vals, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) == 0 {
+			stale = append(stale, ids[i])
+			continue
+		}
+		sess, err := parseSession(ids[i], vals)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	if len(stale) > 0 {
+		_ = s.client.SRem(ctx, setKey, stale...).Err()
+	}
+	return sessions, nil
+}
+
+func (s *TokenStore) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, prefixSession+sessionID)
+	pipe.SRem(ctx, prefixUserSessions+userID.String(), sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeUserSessions ends every session of the user except keepSessionID
+// (pass "" to end all of them).
+func (s *TokenStore) RevokeUserSessions(ctx context.Context, userID uuid.UUID, keepSessionID string) error {
+	setKey := prefixUserSessions + userID.String()
+	ids, err := s.client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	for _, id := range ids {
+		if id == keepSessionID {
+			continue
+		}
+		pipe.Del(ctx, prefixSession+id)
+		pipe.SRem(ctx, setKey, id)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *TokenStore) sessionUserID(ctx context.Context, sessionID string) (uuid.UUID, error) {
+	val, err := s.client.HGet(ctx, prefixSession+sessionID, "user_id").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return uuid.Nil, ErrTokenNotFound
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(val)
+}
+
+func parseSession(sessionID string, vals map[string]string) (*Session, error) {
+	userID, err := uuid.Parse(vals["user_id"])
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:         sessionID,
+		UserID:     userID,
+		CreatedAt:  unixField(vals["created_at"]),
+		LastUsedAt: unixField(vals["last_used_at"]),
+		IP:         vals["ip"],
+		UserAgent:  vals["user_agent"],
+	}, nil
+}
+
+func unixField(v string) time.Time {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
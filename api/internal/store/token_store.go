@@ -12,6 +12,7 @@ import (
 const (
 	prefixEmailVerify = "matcha:email_verify:"
 	prefixPwdReset    = "matcha:pwd_reset:"
+	prefixMagicLink   = "matcha:magic_link:"
 )
 
 type TokenStore struct {
@@ -78,3 +79,20 @@ func (s *TokenStore) GetAndDeletePwdReset(ctx context.Context, tokenHash string)
 	}
 	return id, nil
 }
+
+func (s *TokenStore) SetMagicLink(ctx context.Context, tokenHash string, userID uuid.UUID, ttl time.Duration) error {
+	return s.client.Set(ctx, prefixMagicLink+tokenHash, userID.String(), ttl).Err()
+}
+
+// GetAndDeleteMagicLink consumes a login link atomically, so two concurrent
+// requests with the same link cannot both get a session.
+func (s *TokenStore) GetAndDeleteMagicLink(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	val, err := s.client.GetDel(ctx, prefixMagicLink+tokenHash).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return uuid.Nil, ErrTokenNotFound
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(val)
+}
@@ -0,0 +1,103 @@
+package validation
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	MaxReactionBytes = 32
+	MaxReactionRunes = 8
+)
+
+// ValidateReaction accepts a single emoji, including multi-codepoint ones
+// (skin tones, ZWJ sequences, flags, keycaps). Plain text, symbols that are
+// not emoji (arrows, ©) and several emoji in a row are rejected.
+func ValidateReaction(s string) error {
+	if s == "" {
+		return fmt.Errorf("emoji: required")
+	}
+	if len(s) > MaxReactionBytes || utf8.RuneCountInString(s) > MaxReactionRunes {
+		return fmt.Errorf("emoji: must be a single emoji")
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("emoji: invalid encoding")
+	}
+	if !isEmojiSequence([]rune(s)) {
+		return fmt.Errorf("emoji: must be a single emoji")
+	}
+	return nil
+}
+
+// isEmojiSequence reports whether rs is exactly one emoji: a keycap, a flag
+// (two regional indicators or a tag sequence), or emoji joined by ZWJ, each
+// optionally followed by a presentation selector or skin tone.
+func isEmojiSequence(rs []rune) bool {
+	switch {
+	case isKeycapBase(rs[0]):
+		rest := rs[1:]
+		if len(rest) > 0 && rest[0] == 0xFE0F {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == 0x20E3
+	case isRegionalIndicator(rs[0]):
+		return len(rs) == 2 && isRegionalIndicator(rs[1])
+	case rs[0] == 0x1F3F4 && len(rs) > 2 && isTag(rs[1]):
+		for _, r := range rs[1 : len(rs)-1] {
+			if !isTag(r) {
+				return false
+			}
+		}
+		return rs[len(rs)-1] == 0xE007F
+	}
+	i := 0
+	for {
+		n := emojiElement(rs[i:])
+		if n == 0 {
+			return false
+		}
+		i += n
+		if i == len(rs) {
+			return true
+		}
+		if rs[i] != 0x200D || i+1 == len(rs) {
+			return false
+		}
+		i++
+	}
+}
+
+// emojiElement returns the length of the emoji at the start of rs (the base
+// plus an optional presentation selector or skin tone), or 0 if there is
+// none.
+func emojiElement(rs []rune) int {
+	if len(rs) == 0 || !isEmojiBase(rs[0]) {
+		return 0
+	}
+	if len(rs) > 1 && (rs[1] == 0xFE0F || isSkinTone(rs[1])) {
+		return 2
+	}
+	return 1
+}
+
+func isEmojiBase(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return !isRegionalIndicator(r) && !isSkinTone(r)
+	case r >= 0x2600 && r <= 0x27BF, // misc symbols, dingbats
+		r == 0x231A, r == 0x231B, r == 0x2328, r == 0x23CF,
+		r >= 0x23E9 && r <= 0x23F3, r >= 0x23F8 && r <= 0x23FA,
+		r >= 0x2B05 && r <= 0x2B07, r == 0x2B1B, r == 0x2B1C, r == 0x2B50, r == 0x2B55,
+		r == 0x2934, r == 0x2935, r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+		return true
+	}
+	return false
+}
+
+func isKeycapBase(r rune) bool {
+	return r == '#' || r == '*' || (r >= '0' && r <= '9')
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+func isSkinTone(r rune) bool          { return r >= 0x1F3FB && r <= 0x1F3FF }
+func isTag(r rune) bool               { return r >= 0xE0020 && r <= 0xE007E }
@@ -0,0 +1,41 @@
+package validation
+
+import "testing"
+
+func TestValidateReaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"heart", "❤️", false},
+		{"face", "😂", false},
+		{"skin tone", "👍🏽", false},
+		{"zwj family", "👨‍👩‍👧", false},
+		{"flag", "🇫🇷", false},
+		{"keycap", "1️⃣", false},
+		{"zwj with selector", "❤️‍🔥", false},
+		{"tag flag", "🏴󠁧󠁢󠁳󠁣󠁴󠁿", false},
+		{"empty", "", true},
+		{"text", "lol", true},
+		{"digit only", "1", true},
+		{"emoji with text", "🔥a", true},
+		{"space", "🔥 ", true},
+		{"too long", "😀😀😀😀😀😀😀😀😀", true},
+		{"letter non-ascii", "é", true},
+		{"two emoji", "😀😀", true},
+		{"three emoji", "🔥🔥🔥", true},
+		{"arrow", "→", true},
+		{"copyright", "©", true},
+		{"lone skin tone", "🏽", true},
+		{"trailing zwj", "👍‍", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReaction(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateReaction(%q) err=%v wantErr=%v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
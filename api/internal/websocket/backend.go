@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	envelopeEvent      = "event"
+	envelopeDisconnect = "disconnect"
+)
+
+// Envelope is what travels between hub replicas: either an event for all of
+// a user's sockets or a request to close some of them.
+type Envelope struct {
+	Kind      string          `json:"kind"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Seq       int64           `json:"seq,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	SessionID string          `json:"session_id,omitempty"`
+	// KeepSession inverts SessionID for disconnects: close every socket
+	// except the ones of SessionID.
+	KeepSession bool `json:"keep_session,omitempty"`
+	// Ephemeral events may be dropped for a client that cannot keep up.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// Backend routes envelopes to every hub that holds sockets of the target
+// user and tracks which users are connected anywhere.
+type Backend interface {
+	// Start begins delivering envelopes published by any replica to deliver.
+	Start(ctx context.Context, deliver func(Envelope)) error
+	Publish(ctx context.Context, env Envelope) error
+	// Join and Leave are called once per local socket of userID. Join
+	// reports whether userID had no socket on any replica before, which is
+	// decided atomically so that exactly one of concurrent joins sees it.
+	Join(ctx context.Context, userID uuid.UUID) (bool, error)
+	Leave(ctx context.Context, userID uuid.UUID) error
+	IsOnline(ctx context.Context, userID uuid.UUID) (bool, error)
+}
+
+// LocalBackend keeps everything in process; enough for a single replica.
+type LocalBackend struct {
+	deliver func(Envelope)
+
+	mu    sync.Mutex
+	users map[uuid.UUID]int
+}
+
+func NewLocalBackend() *LocalBackend {
+	return &LocalBackend{users: make(map[uuid.UUID]int)}
+}
+
+func (b *LocalBackend) Start(_ context.Context, deliver func(Envelope)) error {
+	b.deliver = deliver
+	return nil
+}
+
+func (b *LocalBackend) Publish(_ context.Context, env Envelope) error {
+	b.deliver(env)
+	return nil
+}
+
+func (b *LocalBackend) Join(_ context.Context, userID uuid.UUID) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users[userID]++
+	return b.users[userID] == 1, nil
+}
+
+func (b *LocalBackend) Leave(_ context.Context, userID uuid.UUID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.users[userID] > 1 {
+		b.users[userID]--
+	} else {
+		delete(b.users, userID)
+	}
+	return nil
+}
+
+// IsOnline is always false: the hub already checked its own sockets.
+func (b *LocalBackend) IsOnline(context.Context, uuid.UUID) (bool, error) {
+	return false, nil
+}
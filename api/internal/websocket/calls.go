@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/store"
+)
+
+const (
+	// callRingTimeout is how long an invite rings before it counts as missed.
+	callRingTimeout = 30 * time.Second
+	// callMaxDuration bounds the busy state of a call nobody hung up.
+	callMaxDuration = 4 * time.Hour
+	maxCallIDLength = 64
+)
+
+// processCallSignal drives the call lifecycle. The server tracks each call
+// (ringing, then active) in Redis so it can answer busy, time out unanswered
+// invites and record the call once it is over; SDP and ICE payloads are
+// only relayed.
+func (h *ChatHandler) processCallSignal(fromUserID uuid.UUID, in incomingMessage, kind string) error {
+	callID := strings.TrimSpace(in.CallID)
+	if callID == "" {
+		return errors.New("call_id is required")
+	}
+	if len(callID) > maxCallIDLength {
+		return errors.New("call_id is too long")
+	}
+
+	switch kind {
+	case "call_invite":
+		return h.inviteCall(fromUserID, callID, in)
+	case "call_accept":
+		return h.acceptCall(fromUserID, callID, in)
+	case "call_ice":
+		return h.relayICE(fromUserID, callID, in)
+	case "call_reject", "call_end":
+		return h.hangUp(fromUserID, callID, kind)
+	}
+	return errors.New("unsupported event type")
+}
+
+func (h *ChatHandler) inviteCall(fromUserID uuid.UUID, callID string, in incomingMessage) error {
+	toUserID, err := h.validateMatchAndBlock(fromUserID, in.ToUserID)
+	if err != nil {
+		return err
+	}
+	mode := strings.ToLower(strings.TrimSpace(in.Mode))
+	if mode == "" {
+		mode = "video"
+	}
+	if mode != "video" && mode != "audio" {
+		return errors.New("mode must be video or audio")
+	}
+	if strings.TrimSpace(in.SDP) == "" {
+		return errors.New("sdp is required")
+	}
+
+	call := store.LiveCall{
+		ID:        callID,
+		CallerID:  fromUserID,
+		CalleeID:  toUserID,
+		Mode:      mode,
+		State:     store.CallRinging,
+		StartedAt: time.Now().UTC(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The ring timeout is kept in Redis as well, so RunCallTimeouts on any
+	// replica expires the call if this one dies before its timer fires. The
+	// TTL only cleans up if no replica is left running.
+	switch err := h.tokenStore.StartCall(ctx, call, callRingTimeout, callRingTimeout+time.Minute); err {
+	case nil:
+	case store.ErrCalleeBusy:
+		h.finishCall(&call, repository.CallBusy)
+		h.hub.SendToUser(fromUserID, callEvent("call_busy", &call, toUserID, fromUserID, nil))
+		return nil
+	case store.ErrCallerBusy:
+		return errors.New("you are already in a call")
+	case store.ErrCallExists:
+		return errors.New("duplicate call_id")
+	default:
+		return err
+	}
+
+	// Offers are useless once the call is over, so like ICE candidates they
+	// are not logged for replay.
+	event := callEvent("call_invite", &call, fromUserID, toUserID, gin.H{"sdp": in.SDP})
+	h.hub.SendEphemeral(fromUserID, event)
+	h.hub.SendEphemeral(toUserID, event)
+	time.AfterFunc(callRingTimeout, func() { h.expireCall(callID) })
+	return nil
+}
+
+func (h *ChatHandler) acceptCall(fromUserID uuid.UUID, callID string, in incomingMessage) error {
+	if strings.TrimSpace(in.SDP) == "" {
+		return errors.New("sdp is required")
+	}
+	call, peerID, err := h.callFor(fromUserID, callID)
+	if err != nil {
+		return err
+	}
+	if fromUserID != call.CalleeID {
+		return errors.New("only the callee can accept a call")
+	}
+	if _, err := h.validateMatchAndBlock(fromUserID, peerID.String()); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err = h.tokenStore.AnswerCall(ctx, callID, fromUserID, callMaxDuration)
+	if err != nil {
+		if err == store.ErrCallNotFound {
+			return errors.New("call is no longer ringing")
+		}
+		return err
+	}
+
+	event := callEvent("call_accept", call, fromUserID, peerID, gin.H{"sdp": in.SDP})
+	h.hub.SendEphemeral(fromUserID, event)
+	h.hub.SendEphemeral(peerID, event)
+	return nil
+}
+
+func (h *ChatHandler) relayICE(fromUserID uuid.UUID, callID string, in incomingMessage) error {
+	if in.Candidate == nil {
+		return errors.New("candidate is required")
+	}
+	call, peerID, err := h.callFor(fromUserID, callID)
+	if err != nil {
+		return err
+	}
+	if _, err := h.validateMatchAndBlock(fromUserID, peerID.String()); err != nil {
+		return err
+	}
+	// ICE candidates are useless once the call is over, so they are not logged.
+	h.hub.SendEphemeral(peerID, callEvent("call_ice", call, fromUserID, peerID, gin.H{"candidate": in.Candidate}))
+	return nil
+}
+
+// hangUp ends a call from either side. Hanging up is always allowed, even
+// after an unmatch or a block, so the busy state never lingers.
+func (h *ChatHandler) hangUp(fromUserID uuid.UUID, callID, kind string) error {
+	if _, _, err := h.callFor(fromUserID, callID); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := h.tokenStore.EndCall(ctx, callID)
+	if err != nil {
+		if err == store.ErrCallNotFound {
+			return nil
+		}
+		return err
+	}
+
+	status := hangUpStatus(call, fromUserID)
+	peerID := call.CalleeID
+	if fromUserID == call.CalleeID {
+		peerID = call.CallerID
+	}
+	event := callEvent(kind, call, fromUserID, peerID, gin.H{"status": status})
+	h.hub.SendToUser(fromUserID, event)
+	h.hub.SendToUser(peerID, event)
+	h.finishCall(call, status)
+	return nil
+}
+
+// hangUpStatus is how a call ended by fromUserID is recorded: an answered
+// call is completed, a ringing one rejected by the callee or cancelled by
+// the caller.
+func hangUpStatus(call *store.LiveCall, fromUserID uuid.UUID) string {
+	if call.State != store.CallRinging {
+		return repository.CallCompleted
+	}
+	if fromUserID == call.CallerID {
+		return repository.CallCancelled
+	}
+	return repository.CallRejected
+}
+
+// RunCallTimeouts expires the calls whose ring timeout passed every interval
+// until ctx is cancelled. It catches the invites whose replica went away
+// before its own timer fired.
+func (h *ChatHandler) RunCallTimeouts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lookup, cancel := context.WithTimeout(ctx, 5*time.Second)
+		ids, err := h.tokenStore.DueRingingCalls(lookup, time.Now(), 100)
+		cancel()
+		if err != nil {
+			log.Printf("[ws] listing timed out calls failed: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			h.expireCall(id)
+		}
+	}
+}
+
+// expireCall runs callRingTimeout after an invite. If nobody answered or
+// hung up in the meantime the call is missed.
+func (h *ChatHandler) expireCall(callID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := h.tokenStore.EndRingingCall(ctx, callID)
+	if err != nil {
+		if err != store.ErrCallNotFound {
+			log.Printf("[ws] call timeout failed for call=%s: %v", callID, err)
+		}
+		return
+	}
+	event := callEvent("call_end", call, call.CalleeID, call.CallerID, gin.H{"status": repository.CallMissed, "reason": "timeout"})
+	h.hub.SendToUser(call.CallerID, event)
+	h.hub.SendToUser(call.CalleeID, event)
+	h.finishCall(call, repository.CallMissed)
+}
+
+// endCallOf hangs up the call of a user whose last socket went away.
+func (h *ChatHandler) endCallOf(userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	callID, err := h.tokenStore.UserCall(ctx, userID)
+	if err != nil {
+		if err != store.ErrCallNotFound {
+			log.Printf("[ws] call lookup failed for user=%s: %v", userID, err)
+		}
+		return
+	}
+	if err := h.hangUp(userID, callID, "call_end"); err != nil {
+		log.Printf("[ws] ending call=%s of disconnected user=%s failed: %v", callID, userID, err)
+	}
+}
+
+// callFor loads a live call and checks that userID takes part in it. It
+// returns the other participant.
+func (h *ChatHandler) callFor(userID uuid.UUID, callID string) (*store.LiveCall, uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := h.tokenStore.GetCall(ctx, callID)
+	if err != nil {
+		if err == store.ErrCallNotFound {
+			return nil, uuid.Nil, errors.New("call not found")
+		}
+		return nil, uuid.Nil, err
+	}
+	switch userID {
+	case call.CallerID:
+		return call, call.CalleeID, nil
+	case call.CalleeID:
+		return call, call.CallerID, nil
+	}
+	return nil, uuid.Nil, errors.New("call not found")
+}
+
+// finishCall records a call that is over and, if the callee never picked
+// up, leaves them a missed_call notification.
+func (h *ChatHandler) finishCall(call *store.LiveCall, status string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rec := &repository.Call{
+		CallID:     call.ID,
+		CallerID:   call.CallerID,
+		CalleeID:   call.CalleeID,
+		Mode:       call.Mode,
+		Status:     status,
+		StartedAt:  call.StartedAt,
+		AnsweredAt: call.AnsweredAt,
+		EndedAt:    time.Now().UTC(),
+	}
+	if err := h.callRepo.Create(ctx, rec); err != nil {
+		log.Printf("[ws] failed recording call=%s: %v", call.ID, err)
+		return
+	}
+
+	switch status {
+	case repository.CallMissed, repository.CallCancelled, repository.CallBusy:
+	default:
+		return
+	}
+	if blocked, _ := h.blockRepo.BlockedBy(ctx, call.CalleeID, call.CallerID); blocked {
+		return
+	}
+	content := "Missed video call"
+	if call.Mode == "audio" {
+		content = "Missed voice call"
+	}
+	notif, err := h.notificationRepo.Create(ctx, call.CalleeID, &call.CallerID, "missed_call", &rec.ID, content)
+	if err != nil {
+		log.Printf("[ws] failed creating missed call notification for call=%s: %v", call.ID, err)
+		return
+	}
+	h.hub.SendToUser(call.CalleeID, gin.H{
+		"type": "notification",
+		"data": gin.H{
+			"id":         notif.ID,
+			"user_id":    notif.UserID,
+			"actor_id":   notif.ActorID,
+			"type":       notif.Type,
+			"entity_id":  notif.EntityID,
+			"content":    notif.Content,
+			"is_read":    notif.IsRead,
+			"created_at": notif.CreatedAt,
+			"read_at":    notif.ReadAt,
+		},
+	})
+}
+
+func callEvent(kind string, call *store.LiveCall, fromUserID, toUserID uuid.UUID, extra gin.H) gin.H {
+	data := gin.H{
+		"call_id":      call.ID,
+		"from_user_id": fromUserID,
+		"to_user_id":   toUserID,
+		"mode":         call.Mode,
+		"state":        call.State,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return gin.H{"type": kind, "data": data}
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"matcha/api/internal/repository"
+	"matcha/api/internal/store"
+)
+
+func TestHangUpStatus(t *testing.T) {
+	caller, callee := uuid.New(), uuid.New()
+	tests := []struct {
+		state string
+		from  uuid.UUID
+		want  string
+	}{
+		{store.CallRinging, caller, repository.CallCancelled},
+		{store.CallRinging, callee, repository.CallRejected},
+		{store.CallActive, caller, repository.CallCompleted},
+		{store.CallActive, callee, repository.CallCompleted},
+	}
+	for _, tt := range tests {
+		call := &store.LiveCall{CallerID: caller, CalleeID: callee, State: tt.state}
+		if got := hangUpStatus(call, tt.from); got != tt.want {
+			t.Errorf("hangUpStatus(%s, caller=%v) = %q, want %q", tt.state, tt.from == caller, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,8 +13,10 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	gws "github.com/gorilla/websocket"
+	"matcha/api/internal/jwtkeys"
 	"matcha/api/internal/repository"
 	"matcha/api/internal/services"
+	"matcha/api/internal/store"
 )
 
 type wsClaims struct {
@@ -29,12 +32,15 @@ type ChatHandler struct {
 	blockRepo        *repository.BlockRepository
 	notificationRepo *repository.NotificationRepository
 	presenceRepo     *repository.PresenceRepository
+	callRepo         *repository.CallRepository
 	mailer           *services.Mailer
-	jwtSecret        string
+	tokenStore       *store.TokenStore
+	keys             *jwtkeys.KeySet
 	upgrader         gws.Upgrader
 
-	rateMu   sync.Mutex
-	rateByID map[uuid.UUID]rateState
+	rateMu     sync.Mutex
+	rateByID   map[uuid.UUID]rateState
+	signalByID map[uuid.UUID]rateState
 }
 
 type rateState struct {
@@ -50,6 +56,10 @@ type incomingMessage struct {
 	Mode      string `json:"mode"`
 	SDP       string `json:"sdp"`
 	Candidate any    `json:"candidate"`
+	Seq       int64  `json:"seq"`
+	Status    string `json:"status"`
+	// ReplyToMessageID quotes an earlier message of the same conversation.
+	ReplyToMessageID string `json:"reply_to_message_id"`
 }
 
 func NewChatHandler(
@@ -60,8 +70,10 @@ func NewChatHandler(
 	blockRepo *repository.BlockRepository,
 	notificationRepo *repository.NotificationRepository,
 	presenceRepo *repository.PresenceRepository,
+	callRepo *repository.CallRepository,
 	mailer *services.Mailer,
-	jwtSecret string,
+	tokenStore *store.TokenStore,
+	keys *jwtkeys.KeySet,
 ) *ChatHandler {
 	return &ChatHandler{
 		hub:              hub,
@@ -71,9 +83,12 @@ func NewChatHandler(
 		blockRepo:        blockRepo,
 		notificationRepo: notificationRepo,
 		presenceRepo:     presenceRepo,
+		callRepo:         callRepo,
 		mailer:           mailer,
-		jwtSecret:        jwtSecret,
+		tokenStore:       tokenStore,
+		keys:             keys,
 		rateByID:         make(map[uuid.UUID]rateState),
+		signalByID:       make(map[uuid.UUID]rateState),
 		upgrader: gws.Upgrader{
 			CheckOrigin: func(_ *http.Request) bool { return true },
 		},
@@ -81,27 +96,42 @@ func NewChatHandler(
 }
 
 func (h *ChatHandler) Handle(c *gin.Context) {
-	userID, err := h.authenticate(c)
+	userID, sessionID, err := h.authenticate(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		return
 	}
 
+	since, replay, err := h.resumePoint(c, sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 
-	client := h.hub.Register(userID, conn)
-	defer h.hub.Unregister(userID, client)
+	client, cameOnline := h.hub.Register(userID, sessionID, conn, replay)
 	defer func() {
-		_ = h.presenceRepo.UpsertLastSeen(context.Background(), userID, time.Now().UTC())
+		h.hub.Unregister(userID, client)
+		h.disconnected(userID)
 	}()
+	if cameOnline {
+		go h.broadcastPresence(userID, presenceOnline, nil)
+	}
 
-	h.hub.SendToUser(userID, gin.H{
-		"type": "connected",
-		"data": gin.H{"user_id": userID},
-	})
+	h.sendConnected(client, userID)
+	if replay {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err := h.hub.Replay(ctx, userID, client, since)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+	h.sendPresenceSnapshot(client, userID)
 
 	_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	conn.SetPongHandler(func(string) error {
@@ -116,25 +146,131 @@ func (h *ChatHandler) Handle(c *gin.Context) {
 		var in incomingMessage
 		if err := conn.ReadJSON(&in); err != nil {
 			if gws.IsUnexpectedCloseError(err, gws.CloseGoingAway, gws.CloseAbnormalClosure) {
-				h.hub.SendToUser(userID, gin.H{"type": "error", "error": "connection closed"})
+				_ = client.writeJSON(gin.H{"type": "error", "error": "connection closed"})
 			}
 			break
 		}
 
-		if err := h.processIncoming(userID, in); err != nil {
-			h.hub.SendToUser(userID, gin.H{"type": "error", "error": err.Error()})
+		if err := h.processIncoming(userID, sessionID, in); err != nil {
+			_ = client.writeJSON(gin.H{"type": "error", "error": err.Error()})
 		}
 	}
 }
 
-func (h *ChatHandler) processIncoming(fromUserID uuid.UUID, in incomingMessage) error {
+// sendConnected greets a new connection with the newest seq issued to the
+// user, so the client knows where it stands before any replay.
+func (h *ChatHandler) sendConnected(client *clientConn, userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	lastSeq, err := h.hub.LastSeq(ctx, userID)
+	cancel()
+	if err != nil {
+		lastSeq = 0
+	}
+	_ = client.writeJSON(gin.H{
+		"type": "connected",
+		"data": gin.H{"user_id": userID, "last_seq": lastSeq},
+	})
+}
+
+func (h *ChatHandler) processIncoming(fromUserID uuid.UUID, sessionID string, in incomingMessage) error {
 	kind := strings.ToLower(strings.TrimSpace(in.Type))
-	if kind == "" || kind == "message" {
+	switch kind {
+	case "", "message":
 		return h.processChatMessage(fromUserID, in)
+	case "ack":
+		return h.processAck(sessionID, in)
+	case "typing_start", "typing_stop":
+		return h.processTyping(fromUserID, in, kind)
+	case "read":
+		return h.processRead(fromUserID, in)
+	case "presence":
+		return h.processPresence(fromUserID, in)
 	}
 	return h.processCallSignal(fromUserID, in, kind)
 }
 
+// processTyping relays a typing indicator to the peer. Typing events are not
+// logged: a replayed "typing" would be wrong by the time it arrives.
+func (h *ChatHandler) processTyping(fromUserID uuid.UUID, in incomingMessage, kind string) error {
+	if !h.allowSignal(fromUserID) {
+		return errors.New("rate limit exceeded: too many typing events")
+	}
+	toUserID, err := h.validateMatchAndBlock(fromUserID, in.ToUserID)
+	if err != nil {
+		return err
+	}
+	h.hub.SendEphemeral(toUserID, gin.H{
+		"type": kind,
+		"data": gin.H{
+			"from_user_id": fromUserID,
+			"to_user_id":   toUserID,
+		},
+	})
+	return nil
+}
+
+// processRead marks everything the peer sent as read and pushes a receipt to
+// the peer's sockets (and the reader's other sockets).
+func (h *ChatHandler) processRead(readerID uuid.UUID, in incomingMessage) error {
+	senderID, err := h.validateMatchAndBlock(readerID, in.ToUserID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	affected, err := h.messageRepo.MarkReadFromSender(ctx, readerID, senderID)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return nil
+	}
+	event := gin.H{
+		"type": "messages_read",
+		"data": gin.H{
+			"sender_id": senderID,
+			"reader_id": readerID,
+			"read_at":   time.Now().UTC(),
+			"count":     affected,
+		},
+	}
+	h.hub.SendToUser(senderID, event)
+	h.hub.SendToUser(readerID, event)
+	return nil
+}
+
+// processAck stores the highest event seq the client has processed, so a
+// later connection with ?resume=1 can pick up from there.
+func (h *ChatHandler) processAck(sessionID string, in incomingMessage) error {
+	if in.Seq <= 0 {
+		return errors.New("seq must be positive")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return h.tokenStore.AckEvents(ctx, sessionID, in.Seq)
+}
+
+// resumePoint decides where replay starts: ?since=<seq> is explicit,
+// ?resume=1 continues after the last seq acked on this session. Without
+// either the socket only gets live events.
+func (h *ChatHandler) resumePoint(c *gin.Context, sessionID string) (int64, bool, error) {
+	if raw := strings.TrimSpace(c.Query("since")); raw != "" {
+		since, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || since < 0 {
+			return 0, false, errors.New("since must be a non-negative integer")
+		}
+		return since, true, nil
+	}
+	if c.Query("resume") == "1" {
+		since, err := h.tokenStore.EventAck(c.Request.Context(), sessionID)
+		if err != nil {
+			return 0, false, err
+		}
+		return since, true, nil
+	}
+	return 0, false, nil
+}
+
 func (h *ChatHandler) processChatMessage(fromUserID uuid.UUID, in incomingMessage) error {
 	if !h.allowMessage(fromUserID) {
 		return errors.New("rate limit exceeded: too many messages")
@@ -151,7 +287,23 @@ func (h *ChatHandler) processChatMessage(fromUserID uuid.UUID, in incomingMessag
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	msg, err := h.messageRepo.Create(ctx, fromUserID, toUserID, content)
+	var replyToID *uuid.UUID
+	if raw := strings.TrimSpace(in.ReplyToMessageID); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return errors.New("invalid reply_to_message_id")
+		}
+		ok, err := h.messageRepo.InConversation(ctx, id, fromUserID, toUserID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("reply_to_message_id must be a message of this conversation")
+		}
+		replyToID = &id
+	}
+
+	msg, err := h.messageRepo.Create(ctx, fromUserID, toUserID, content, replyToID)
 	if err != nil {
 		return err
 	}
@@ -176,15 +328,18 @@ func (h *ChatHandler) processChatMessage(fromUserID uuid.UUID, in incomingMessag
 	event := gin.H{
 		"type": "message",
 		"data": gin.H{
-			"id":           msg.ID,
-			"sender_id":    msg.SenderID,
-			"receiver_id":  msg.ReceiverID,
-			"content":      msg.Content,
-			"message_type": msg.MessageType,
-			"media_url":    msg.MediaURL,
-			"created_at":   msg.CreatedAt,
-			"is_read":      msg.IsRead,
-			"read_at":      msg.ReadAt,
+			"id":                  msg.ID,
+			"sender_id":           msg.SenderID,
+			"receiver_id":         msg.ReceiverID,
+			"content":             msg.Content,
+			"message_type":        msg.MessageType,
+			"media_url":           msg.MediaURL,
+			"created_at":          msg.CreatedAt,
+			"is_read":             msg.IsRead,
+			"read_at":             msg.ReadAt,
+			"reply_to_message_id": msg.ReplyToID,
+			"expires_at":          msg.ExpiresAt,
+			"reactions":           []any{},
 		},
 	}
 	h.hub.SendToUser(fromUserID, event)
@@ -208,53 +363,6 @@ func (h *ChatHandler) processChatMessage(fromUserID uuid.UUID, in incomingMessag
 	return nil
 }
 
-func (h *ChatHandler) processCallSignal(fromUserID uuid.UUID, in incomingMessage, kind string) error {
-	toUserID, err := h.validateMatchAndBlock(fromUserID, in.ToUserID)
-	if err != nil {
-		return err
-	}
-	callID := strings.TrimSpace(in.CallID)
-	if callID == "" {
-		return errors.New("call_id is required")
-	}
-	mode := strings.ToLower(strings.TrimSpace(in.Mode))
-	if mode == "" {
-		mode = "video"
-	}
-	if mode != "video" && mode != "audio" {
-		return errors.New("mode must be video or audio")
-	}
-
-	switch kind {
-	case "call_invite", "call_accept":
-		if strings.TrimSpace(in.SDP) == "" {
-			return errors.New("sdp is required")
-		}
-	case "call_ice":
-		if in.Candidate == nil {
-			return errors.New("candidate is required")
-		}
-	case "call_reject", "call_end":
-	default:
-		return errors.New("unsupported event type")
-	}
-
-	event := gin.H{
-		"type": kind,
-		"data": gin.H{
-			"call_id":      callID,
-			"from_user_id": fromUserID,
-			"to_user_id":   toUserID,
-			"mode":         mode,
-			"sdp":          in.SDP,
-			"candidate":    in.Candidate,
-		},
-	}
-	h.hub.SendToUser(fromUserID, event)
-	h.hub.SendToUser(toUserID, event)
-	return nil
-}
-
 func (h *ChatHandler) validateMatchAndBlock(fromUserID uuid.UUID, toUserIDRaw string) (uuid.UUID, error) {
 	toUserID, err := uuid.Parse(strings.TrimSpace(toUserIDRaw))
 	if err != nil {
@@ -282,7 +390,7 @@ func (h *ChatHandler) validateMatchAndBlock(fromUserID uuid.UUID, toUserIDRaw st
 	return toUserID, nil
 }
 
-func (h *ChatHandler) authenticate(c *gin.Context) (uuid.UUID, error) {
+func (h *ChatHandler) authenticate(c *gin.Context) (uuid.UUID, string, error) {
 	token := strings.TrimSpace(c.Query("token"))
 	if token == "" {
 		token = strings.TrimSpace(c.GetHeader("Authorization"))
@@ -291,17 +399,22 @@ func (h *ChatHandler) authenticate(c *gin.Context) (uuid.UUID, error) {
 		token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
 	}
 	if token == "" {
-		return uuid.Nil, errors.New("missing token")
+		return uuid.Nil, "", errors.New("missing token")
 	}
 
 	claims := &wsClaims{}
-	t, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
-		return []byte(h.jwtSecret), nil
-	})
-	if err != nil || !t.Valid {
-		return uuid.Nil, errors.New("invalid token")
+	t, err := h.keys.Parse(token, claims)
+	if err != nil || !t.Valid || claims.ID == "" {
+		return uuid.Nil, "", errors.New("invalid token")
+	}
+	active, err := h.tokenStore.TouchSession(c.Request.Context(), claims.ID, c.ClientIP())
+	if err != nil {
+		return uuid.Nil, "", err
 	}
-	return claims.UserID, nil
+	if !active {
+		return uuid.Nil, "", errors.New("session revoked")
+	}
+	return claims.UserID, claims.ID, nil
 }
 
 func (h *ChatHandler) pingLoop(userID uuid.UUID, client *clientConn, stop <-chan struct{}) {
@@ -326,26 +439,40 @@ func (h *ChatHandler) allowMessage(userID uuid.UUID) bool {
 		maxMessagesPerWindow = 20
 		windowSize           = 10 * time.Second
 	)
+	return h.allow(h.rateByID, userID, maxMessagesPerWindow, windowSize)
+}
+
+// allowSignal caps ephemeral signals (typing, presence); clients are expected
+// to send one typing_start every few seconds while typing, not one per
+// keystroke.
+func (h *ChatHandler) allowSignal(userID uuid.UUID) bool {
+	const (
+		maxSignalsPerWindow = 10
+		windowSize          = 10 * time.Second
+	)
+	return h.allow(h.signalByID, userID, maxSignalsPerWindow, windowSize)
+}
 
+func (h *ChatHandler) allow(states map[uuid.UUID]rateState, userID uuid.UUID, limit int, window time.Duration) bool {
 	now := time.Now()
 
 	h.rateMu.Lock()
 	defer h.rateMu.Unlock()
 
-	s := h.rateByID[userID]
-	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= windowSize {
-		h.rateByID[userID] = rateState{
+	s := states[userID]
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= window {
+		states[userID] = rateState{
 			windowStart: now,
 			count:       1,
 		}
 		return true
 	}
 
-	if s.count >= maxMessagesPerWindow {
+	if s.count >= limit {
 		return false
 	}
 
 	s.count++
-	h.rateByID[userID] = s
+	states[userID] = s
 	return true
 }
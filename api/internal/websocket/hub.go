@@ -1,87 +1,437 @@
 package websocket
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"matcha/api/internal/repository"
 )
 
+const (
+	backendTimeout = 2 * time.Second
+
+	// sendQueueSize bounds the events waiting for one socket. A client that
+	// falls this far behind is treated as a slow consumer.
+	sendQueueSize = 256
+	writeTimeout  = 5 * time.Second
+)
+
+var (
+	errSlowConsumer = errors.New("send queue full")
+	errClientClosed = errors.New("client closed")
+)
+
+type outbound struct {
+	data []byte
+	seq  int64
+	// close, when set, makes the writer send a close frame with this code
+	// and shut the socket down after the messages queued before it.
+	close     int
+	closeText string
+}
+
+// clientConn is one socket or event stream. Every data frame goes through
+// the bounded out queue and is written by the connection's own writer (a
+// goroutine for websockets, the request goroutine for streams), so a slow
+// client never blocks whoever is sending to it.
 type clientConn struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	conn      *websocket.Conn // nil for event streams
+	sessionID string
+	out       chan outbound
+	done      chan struct{}
+	closeOnce sync.Once
+	// left is closed once the backend has been told this socket is gone.
+	left chan struct{}
+
+	// While replaying, live sequenced events are held back in backlog so
+	// they reach the client after the replayed ones. Afterwards lastSeq is
+	// the newest seq written and pending holds events that arrived ahead of
+	// a gap; fillGap runs if the gap is still open after seqGapTimeout.
+	replayMu  sync.Mutex
+	replaying bool
+	backlog   []Envelope
+	lastSeq   int64
+	pending   map[int64]Envelope
+	gapTimer  *time.Timer
+	fillGap   func()
+}
+
+func newClientConn(conn *websocket.Conn, sessionID string, replaying bool) *clientConn {
+	c := &clientConn{
+		conn:      conn,
+		sessionID: sessionID,
+		out:       make(chan outbound, sendQueueSize),
+		done:      make(chan struct{}),
+		left:      make(chan struct{}),
+		replaying: replaying,
+	}
+	if conn != nil {
+		go c.writeLoop()
+	}
+	return c
 }
 
+func (c *clientConn) writeLoop() {
+	defer c.close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.out:
+			if m.close != 0 {
+				msg := websocket.FormatCloseMessage(m.close, m.closeText)
+				_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+				return
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, m.data); err != nil {
+				return
+			}
+			hubStats.Add("events_sent", 1)
+		}
+	}
+}
+
+// send queues a hub event. Ephemeral events are dropped when the queue is
+// full; anything else returns errSlowConsumer and the caller drops the
+// client, which can replay what it missed after reconnecting.
+func (c *clientConn) send(env Envelope) error {
+	if env.Seq == 0 {
+		return c.push(env.Payload, 0, env.Ephemeral)
+	}
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if c.replaying {
+		c.backlog = append(c.backlog, env)
+		return nil
+	}
+	return c.sendInOrder(env)
+}
+
+func (c *clientConn) push(data []byte, seq int64, droppable bool) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+	select {
+	case c.out <- outbound{data: data, seq: seq}:
+		return nil
+	default:
+	}
+	if droppable {
+		recordDrop(data)
+		return nil
+	}
+	return errSlowConsumer
+}
+
+// pushWait queues data, waiting for room instead of failing; used while
+// replaying, when the queue is expected to fill up.
+func (c *clientConn) pushWait(ctx context.Context, data []byte, seq int64) error {
+	select {
+	case c.out <- outbound{data: data, seq: seq}:
+		return nil
+	case <-c.done:
+		return errClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeJSON queues a message meant only for this socket (connection
+// status, errors). Unlike ephemeral events these are never dropped: if the
+// queue is full the socket is closed, and the client reconnects and resyncs.
 func (c *clientConn) writeJSON(v any) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	return c.conn.WriteJSON(v)
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = c.push(data, 0, false)
+	if err == errSlowConsumer {
+		hubStats.Add("slow_disconnects", 1)
+		c.closeNow(websocket.CloseTryAgainLater, "send queue full")
+	}
+	return err
 }
 
+// writeControl writes a control frame directly; gorilla allows that
+// concurrently with the writer goroutine.
 func (c *clientConn) writeControl(messageType int, data []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	deadline := time.Now().Add(5 * time.Second)
-	return c.conn.WriteControl(messageType, data, deadline)
+	return c.conn.WriteControl(messageType, data, time.Now().Add(writeTimeout))
+}
+
+// closeAfterFlush closes the socket once the messages already queued have
+// been written, or right away if the queue is full.
+func (c *clientConn) closeAfterFlush(code int, text string) {
+	select {
+	case c.out <- outbound{close: code, closeText: text}:
+	default:
+		c.closeNow(code, text)
+	}
+}
+
+// closeNow sends the close frame directly, skipping whatever is queued, so
+// the client still learns why it was disconnected.
+func (c *clientConn) closeNow(code int, text string) {
+	if c.conn != nil {
+		_ = c.writeControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+	}
+	c.close()
+}
+
+func (c *clientConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			_ = c.conn.Close()
+		}
+	})
 }
 
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[uuid.UUID]map[*clientConn]struct{}
+	backend Backend
+	events  *repository.EventRepository
 }
 
-func NewHub() *Hub {
+// NewHub returns a hub that reaches sockets on other replicas through
+// backend and keeps sequenced events in events for replay. Start must be
+// called before the hub is used.
+func NewHub(backend Backend, events *repository.EventRepository) *Hub {
 	return &Hub{
 		clients: make(map[uuid.UUID]map[*clientConn]struct{}),
+		backend: backend,
+		events:  events,
 	}
 }
 
-func (h *Hub) Register(userID uuid.UUID, ws *websocket.Conn) *clientConn {
-	client := &clientConn{conn: ws}
+func (h *Hub) Start(ctx context.Context) error {
+	return h.backend.Start(ctx, h.deliver)
+}
+
+// Register adds a socket. With replay set, sequenced events are held back
+// until Replay has caught the socket up. It also reports whether the user
+// just came online, i.e. had no other socket on any replica.
+func (h *Hub) Register(userID uuid.UUID, sessionID string, ws *websocket.Conn, replay bool) (*clientConn, bool) {
+	client := newClientConn(ws, sessionID, replay)
+	return client, h.register(userID, client)
+}
 
+// RegisterStream adds an event stream. The caller drains the client's queue
+// itself (see ChatHandler.Stream).
+func (h *Hub) RegisterStream(userID uuid.UUID, sessionID string, replay bool) (*clientConn, bool) {
+	client := newClientConn(nil, sessionID, replay)
+	return client, h.register(userID, client)
+}
+
+func (h *Hub) register(userID uuid.UUID, client *clientConn) bool {
+	client.fillGap = func() { h.fillGap(userID, client) }
 	h.mu.Lock()
 	if _, ok := h.clients[userID]; !ok {
 		h.clients[userID] = make(map[*clientConn]struct{})
 	}
 	h.clients[userID][client] = struct{}{}
+	localFirst := len(h.clients[userID]) == 1
 	h.mu.Unlock()
 
-	return client
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	first, err := h.backend.Join(ctx, userID)
+	if err != nil {
+		// Without the backend only this replica's sockets can be judged.
+		log.Printf("[hub] join failed for user=%s: %v", userID, err)
+		return localFirst
+	}
+	return first
 }
 
 func (h *Hub) Unregister(userID uuid.UUID, client *clientConn) {
+	client.close()
+	h.remove(userID, client)
+}
+
+// remove drops client from the hub without closing its socket. It returns
+// once the backend has been told, even if the hub already dropped client on
+// the delivery path, so callers can trust IsOnline afterwards.
+func (h *Hub) remove(userID uuid.UUID, client *clientConn) {
+	if !h.detach(userID, client) {
+		<-client.left
+		return
+	}
+	h.leave(userID)
+	close(client.left)
+}
+
+// evict drops client from the hub like remove, but tells the backend in the
+// background so that one slow Leave does not hold up delivery to others.
+func (h *Hub) evict(userID uuid.UUID, client *clientConn) {
+	if !h.detach(userID, client) {
+		return
+	}
+	go func() {
+		h.leave(userID)
+		close(client.left)
+	}()
+}
+
+// detach deletes client from the local map and reports whether it was
+// still registered; only the caller that detaches it calls leave.
+func (h *Hub) detach(userID uuid.UUID, client *clientConn) bool {
 	h.mu.Lock()
-	if set, ok := h.clients[userID]; ok {
-		delete(set, client)
-		if len(set) == 0 {
-			delete(h.clients, userID)
-		}
+	defer h.mu.Unlock()
+	if _, registered := h.clients[userID][client]; !registered {
+		return false
+	}
+	delete(h.clients[userID], client)
+	if len(h.clients[userID]) == 0 {
+		delete(h.clients, userID)
+	}
+	return true
+}
+
+func (h *Hub) leave(userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	if err := h.backend.Leave(ctx, userID); err != nil {
+		log.Printf("[hub] leave failed for user=%s: %v", userID, err)
 	}
-	h.mu.Unlock()
-	_ = client.conn.Close()
 }
 
+// SendToUser delivers payload to every socket of userID on any replica. The
+// event is first written to the user's event log under the next sequence
+// number, so a client that was offline can replay it after reconnecting.
+// Concurrent senders may publish out of order; each socket puts sequenced
+// events back in order before writing them (see clientConn.sendInOrder).
 func (h *Hub) SendToUser(userID uuid.UUID, payload any) {
-	h.mu.RLock()
-	set := h.clients[userID]
-	clients := make([]*clientConn, 0, len(set))
-	for c := range set {
-		clients = append(clients, c)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[hub] cannot encode event for user=%s: %v", userID, err)
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	seq, err := h.events.Append(ctx, userID, eventType(data), data)
+	if err != nil {
+		// Still deliver live; the event just cannot be replayed.
+		log.Printf("[hub] event log append failed for user=%s: %v", userID, err)
+		h.publish(Envelope{Kind: envelopeEvent, UserID: userID, Payload: data})
+		return
+	}
+	h.publish(Envelope{Kind: envelopeEvent, UserID: userID, Seq: seq, Payload: stampEvent(data, seq, false)})
+}
+
+// SendEphemeral delivers payload to the user's connected sockets without
+// logging it; meant for events that are useless once stale.
+func (h *Hub) SendEphemeral(userID uuid.UUID, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[hub] cannot encode event for user=%s: %v", userID, err)
+		return
+	}
+	h.publish(Envelope{Kind: envelopeEvent, UserID: userID, Payload: data, Ephemeral: true})
+}
+
+// DisconnectSession closes every socket opened with the given session.
+func (h *Hub) DisconnectSession(userID uuid.UUID, sessionID string) {
+	h.publish(Envelope{Kind: envelopeDisconnect, UserID: userID, SessionID: sessionID})
+}
+
+// DisconnectUser closes all of the user's sockets except those belonging to
+// keepSessionID (pass "" to close all of them).
+func (h *Hub) DisconnectUser(userID uuid.UUID, keepSessionID string) {
+	h.publish(Envelope{Kind: envelopeDisconnect, UserID: userID, SessionID: keepSessionID, KeepSession: true})
+}
+
+// IsOnline reports whether userID has an open socket on any replica.
+func (h *Hub) IsOnline(userID uuid.UUID) bool {
+	h.mu.RLock()
+	local := len(h.clients[userID]) > 0
 	h.mu.RUnlock()
+	if local {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	online, err := h.backend.IsOnline(ctx, userID)
+	if err != nil {
+		log.Printf("[hub] online check failed for user=%s: %v", userID, err)
+		return false
+	}
+	return online
+}
+
+// publish hands env to the backend. If that fails the envelope is still
+// delivered locally, so a Redis outage degrades to single-replica behaviour.
+func (h *Hub) publish(env Envelope) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	if err := h.backend.Publish(ctx, env); err != nil {
+		log.Printf("[hub] publish failed for user=%s, delivering locally: %v", env.UserID, err)
+		h.deliver(env)
+	}
+}
 
-	for _, c := range clients {
-		if err := c.writeJSON(payload); err != nil {
-			h.Unregister(userID, c)
+func (h *Hub) deliver(env Envelope) {
+	switch env.Kind {
+	case envelopeEvent:
+		h.writeLocal(env)
+	case envelopeDisconnect:
+		h.disconnectLocal(env.UserID, func(c *clientConn) bool {
+			return (c.sessionID == env.SessionID) != env.KeepSession
+		})
+	}
+}
+
+func (h *Hub) writeLocal(env Envelope) {
+	for _, c := range h.localClients(env.UserID, nil) {
+		if err := c.send(env); err != nil {
+			if err == errSlowConsumer {
+				h.dropSlowClient(env.UserID, c)
+				continue
+			}
+			c.close()
+			h.evict(env.UserID, c)
 		}
 	}
 }
 
-func (h *Hub) IsOnline(userID uuid.UUID) bool {
+func (h *Hub) disconnectLocal(userID uuid.UUID, match func(*clientConn) bool) {
+	for _, c := range h.localClients(userID, match) {
+		h.evict(userID, c)
+		// The close frame carries the reason too, so a full queue must not
+		// turn this into a slow-consumer close.
+		data, _ := json.Marshal(map[string]any{"type": "session_revoked"})
+		_ = c.push(data, 0, false)
+		c.closeAfterFlush(websocket.ClosePolicyViolation, "session revoked")
+	}
+}
+
+// dropSlowClient disconnects a socket whose queue overflowed on an event it
+// must not miss. The client reconnects and replays from its last seq.
+func (h *Hub) dropSlowClient(userID uuid.UUID, c *clientConn) {
+	hubStats.Add("slow_disconnects", 1)
+	log.Printf("[hub] disconnecting slow client user=%s session=%s: send queue full", userID, c.sessionID)
+	c.closeNow(websocket.CloseTryAgainLater, "send queue full")
+	h.evict(userID, c)
+}
+
+func (h *Hub) localClients(userID uuid.UUID, match func(*clientConn) bool) []*clientConn {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	set, ok := h.clients[userID]
-	return ok && len(set) > 0
+	set := h.clients[userID]
+	out := make([]*clientConn, 0, len(set))
+	for c := range set {
+		if match == nil || match(c) {
+			out = append(out, c)
+		}
+	}
+	return out
 }
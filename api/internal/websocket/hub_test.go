@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClientPushOverflow(t *testing.T) {
+	c := &clientConn{out: make(chan outbound, 1), done: make(chan struct{})}
+
+	if err := c.push([]byte(`{"type":"message"}`), 1, false); err != nil {
+		t.Fatalf("push into empty queue: %v", err)
+	}
+	if err := c.push([]byte(`{"type":"typing_start"}`), 0, true); err != nil {
+		t.Fatalf("ephemeral push into full queue = %v, want dropped silently", err)
+	}
+	if err := c.push([]byte(`{"type":"message"}`), 1, false); err != errSlowConsumer {
+		t.Fatalf("push into full queue = %v, want errSlowConsumer", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.pushWait(ctx, []byte(`{}`), 0); err != context.DeadlineExceeded {
+		t.Fatalf("pushWait into full queue = %v, want deadline exceeded", err)
+	}
+
+	close(c.done)
+	<-c.out
+	if err := c.push([]byte(`{}`), 0, false); err != errClientClosed {
+		t.Fatalf("push after close = %v, want errClientClosed", err)
+	}
+}
+
+func TestHubRegisterReportsCameOnline(t *testing.T) {
+	h := NewHub(NewLocalBackend(), nil)
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	user := uuid.New()
+
+	a, first := h.RegisterStream(user, "s1", false)
+	if !first {
+		t.Fatal("first socket: cameOnline = false, want true")
+	}
+	b, first := h.RegisterStream(user, "s2", false)
+	if first {
+		t.Fatal("second socket: cameOnline = true, want false")
+	}
+	h.Unregister(user, a)
+	h.Unregister(user, b)
+	if _, first := h.RegisterStream(user, "s3", false); !first {
+		t.Fatal("socket after all left: cameOnline = false, want true")
+	}
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	h := NewHub(NewLocalBackend(), nil)
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	user := uuid.New()
+
+	c, _ := h.RegisterStream(user, "s1", false)
+	for i := 0; i < sendQueueSize; i++ {
+		c.out <- outbound{data: []byte(`{}`)}
+	}
+	h.deliver(Envelope{Kind: envelopeEvent, UserID: user, Seq: 1, Payload: []byte(`{}`)})
+
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("slow client was not closed")
+	}
+	// Unregister still waits for the backend leave the hub started.
+	h.Unregister(user, c)
+	if h.IsOnline(user) {
+		t.Fatal("user still online after its only socket was dropped")
+	}
+}
@@ -0,0 +1,18 @@
+package websocket
+
+import "expvar"
+
+// Hub counters, published through expvar (/debug/vars when metrics are
+// enabled):
+//   - events_sent: frames written to sockets
+//   - events_dropped: ephemeral events dropped because a queue was full
+//   - slow_disconnects: sockets closed for falling behind on logged events
+var (
+	hubStats      = expvar.NewMap("ws_hub")
+	droppedByType = expvar.NewMap("ws_hub_dropped_by_type")
+)
+
+func recordDrop(data []byte) {
+	hubStats.Add("events_dropped", 1)
+	droppedByType.Add(eventType(data), 1)
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	presenceOnline  = "online"
+	presenceAway    = "away"
+	presenceOffline = "offline"
+)
+
+// broadcastPresence pushes the user's presence to each of their matches.
+// Blocked users are left out by ListMatchIDs. Presence is ephemeral: a
+// client that reconnects gets a fresh presence_snapshot instead.
+func (h *ChatHandler) broadcastPresence(userID uuid.UUID, status string, lastSeen *time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	matchIDs, err := h.likeRepo.ListMatchIDs(ctx, userID)
+	if err != nil {
+		log.Printf("[ws] presence fan-out failed for user=%s: %v", userID, err)
+		return
+	}
+	event := gin.H{
+		"type": "presence",
+		"data": gin.H{
+			"user_id":   userID,
+			"status":    status,
+			"last_seen": lastSeen,
+		},
+	}
+	for _, id := range matchIDs {
+		h.hub.SendEphemeral(id, event)
+	}
+}
+
+// sendPresenceSnapshot tells a freshly connected socket which matches are
+// online right now, so it does not have to poll each of them.
+func (h *ChatHandler) sendPresenceSnapshot(client *clientConn, userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	matchIDs, err := h.likeRepo.ListMatchIDs(ctx, userID)
+	if err != nil {
+		log.Printf("[ws] presence snapshot failed for user=%s: %v", userID, err)
+		return
+	}
+	online := make([]uuid.UUID, 0, len(matchIDs))
+	for _, id := range matchIDs {
+		if h.hub.IsOnline(id) {
+			online = append(online, id)
+		}
+	}
+	_ = client.writeJSON(gin.H{
+		"type": "presence_snapshot",
+		"data": gin.H{"online_user_ids": online},
+	})
+}
+
+// processPresence lets a client flag the user as away (e.g. the app went to
+// the background) or back online.
+func (h *ChatHandler) processPresence(userID uuid.UUID, in incomingMessage) error {
+	switch in.Status {
+	case presenceAway, presenceOnline:
+	default:
+		return errors.New("status must be online or away")
+	}
+	if !h.allowSignal(userID) {
+		return errors.New("rate limit exceeded: too many presence updates")
+	}
+	var lastSeen *time.Time
+	if in.Status == presenceAway {
+		now := time.Now().UTC()
+		lastSeen = &now
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = h.presenceRepo.UpsertLastSeen(ctx, userID, now)
+		cancel()
+	}
+	go h.broadcastPresence(userID, in.Status, lastSeen)
+	return nil
+}
+
+// disconnected runs after a socket is gone. Matches only hear "offline" once
+// the user's last socket on any replica has closed; that also ends any call
+// the user was in.
+func (h *ChatHandler) disconnected(userID uuid.UUID) {
+	now := time.Now().UTC()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_ = h.presenceRepo.UpsertLastSeen(ctx, userID, now)
+	cancel()
+	if h.hub.IsOnline(userID) {
+		return
+	}
+	h.endCallOf(userID)
+	h.broadcastPresence(userID, presenceOffline, &now)
+}
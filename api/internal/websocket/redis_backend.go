@@ -0,0 +1,332 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	prefixHubChannel = "matcha:hub:user:"
+	prefixHubOnline  = "matcha:hub:online:"
+
+	// Each replica refreshes its presence entries every onlineRefresh; an
+	// entry not refreshed for onlineTTL (e.g. the replica crashed) is ignored.
+	onlineRefresh = 30 * time.Second
+	onlineTTL     = 90 * time.Second
+)
+
+// RedisBackend fans hub envelopes out through Redis pub/sub on one channel
+// per user. A replica subscribes to a user's channel while it holds at least
+// one of their sockets. Presence is a sorted set per user of replica IDs
+// scored by expiry.
+type RedisBackend struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	nodeID string
+
+	mu    sync.Mutex
+	users map[uuid.UUID]*localUser
+}
+
+// localUser tracks one user's sockets on this replica. subscribed is the
+// state the last sync moved to; it is set before the Redis calls are made so
+// refreshOnline never re-adds a user that is on its way out. At most one
+// goroutine syncs a user at a time. joined holds the Joins that took the
+// count from zero and wait for the subscribe they caused to report whether
+// the user came online.
+type localUser struct {
+	count      int
+	subscribed bool
+	syncing    bool
+	joined     []chan bool
+}
+
+func NewRedisBackend(redisURL string) (*RedisBackend, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	client := redis.NewClient(opt)
+	return &RedisBackend{
+		client: client,
+		pubsub: client.Subscribe(context.Background()),
+		nodeID: uuid.NewString(),
+		users:  make(map[uuid.UUID]*localUser),
+	}, nil
+}
+
+func (b *RedisBackend) Start(ctx context.Context, deliver func(Envelope)) error {
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	go b.receiveLoop(ctx, deliver)
+	go b.refreshLoop(ctx)
+	return nil
+}
+
+func (b *RedisBackend) Publish(ctx context.Context, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, prefixHubChannel+env.UserID.String(), data).Err()
+}
+
+func (b *RedisBackend) Join(ctx context.Context, userID uuid.UUID) (bool, error) {
+	b.mu.Lock()
+	u := b.users[userID]
+	if u == nil {
+		u = &localUser{}
+		b.users[userID] = u
+	}
+	u.count++
+	var joined chan bool
+	if u.count == 1 {
+		joined = make(chan bool, 1)
+		u.joined = append(u.joined, joined)
+	}
+	start := b.claimSync(u)
+	if !u.syncing {
+		u.releaseJoined()
+	}
+	b.mu.Unlock()
+	if start {
+		if err := b.sync(ctx, userID); err != nil {
+			return false, err
+		}
+	}
+	if joined == nil {
+		return false, nil
+	}
+	// Whoever is syncing, possibly a Leave, answers once it subscribed.
+	select {
+	case first := <-joined:
+		return first, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (b *RedisBackend) Leave(ctx context.Context, userID uuid.UUID) error {
+	b.mu.Lock()
+	u := b.users[userID]
+	if u == nil {
+		b.mu.Unlock()
+		return nil
+	}
+	u.count--
+	start := b.claimSync(u)
+	b.mu.Unlock()
+	if !start {
+		return nil
+	}
+	return b.sync(ctx, userID)
+}
+
+// claimSync reports whether the caller should sync u, marking it as syncing.
+// It must be called with b.mu held.
+func (b *RedisBackend) claimSync(u *localUser) bool {
+	if u.syncing || (u.count > 0) == u.subscribed {
+		return false
+	}
+	u.syncing = true
+	return true
+}
+
+// releaseJoined answers waiting Joins whose subscribe will not happen, e.g.
+// because the user never went away from Redis' point of view. It must be
+// called with b.mu held.
+func (u *localUser) releaseJoined() {
+	for _, ch := range u.joined {
+		ch <- false
+	}
+	u.joined = nil
+}
+
+// sync subscribes or unsubscribes userID until the Redis state matches the
+// local socket count, which may change while the calls are in flight. The
+// lock is only held to read and update the bookkeeping. Each subscribe
+// reports whether the user came online to the Joins waiting for it.
+func (b *RedisBackend) sync(ctx context.Context, userID uuid.UUID) error {
+	for {
+		b.mu.Lock()
+		u := b.users[userID]
+		want := u.count > 0
+		if want == u.subscribed {
+			u.syncing = false
+			u.releaseJoined()
+			if u.count <= 0 {
+				delete(b.users, userID)
+			}
+			b.mu.Unlock()
+			return nil
+		}
+		u.subscribed = want
+		var waiting []chan bool
+		if want {
+			waiting, u.joined = u.joined, nil
+		}
+		b.mu.Unlock()
+
+		var err error
+		if want {
+			var alone bool
+			alone, err = b.subscribe(ctx, userID)
+			for i, ch := range waiting {
+				ch <- err == nil && alone && i == 0
+			}
+		} else {
+			err = b.unsubscribe(ctx, userID)
+		}
+		if err != nil {
+			b.mu.Lock()
+			u.syncing = false
+			u.releaseJoined()
+			if u.count <= 0 && !u.subscribed {
+				delete(b.users, userID)
+			}
+			b.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (b *RedisBackend) subscribe(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if err := b.pubsub.Subscribe(ctx, prefixHubChannel+userID.String()); err != nil {
+		return false, err
+	}
+	return b.markOnline(ctx, userID)
+}
+
+func (b *RedisBackend) unsubscribe(ctx context.Context, userID uuid.UUID) error {
+	if err := b.pubsub.Unsubscribe(ctx, prefixHubChannel+userID.String()); err != nil {
+		return err
+	}
+	return b.client.ZRem(ctx, prefixHubOnline+userID.String(), b.nodeID).Err()
+}
+
+func (b *RedisBackend) IsOnline(ctx context.Context, userID uuid.UUID) (bool, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	n, err := b.client.ZCount(ctx, prefixHubOnline+userID.String(), now, "+inf").Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (b *RedisBackend) Close() error {
+	_ = b.pubsub.Close()
+	return b.client.Close()
+}
+
+// markOnlineScript adds this replica (ARGV[1], expiring at ARGV[2]) to the
+// presence set KEYS[1] and returns how many replicas, itself included, are
+// live at ARGV[3]. Doing both in one step means concurrent joins on different
+// replicas cannot both see themselves alone.
+var markOnlineScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return redis.call('ZCOUNT', KEYS[1], ARGV[3], '+inf')
+`)
+
+// markOnline reports whether this replica is the only live one for userID.
+func (b *RedisBackend) markOnline(ctx context.Context, userID uuid.UUID) (bool, error) {
+	now := time.Now()
+	n, err := markOnlineScript.Run(ctx, b.client, []string{prefixHubOnline + userID.String()},
+		b.nodeID, now.Add(onlineTTL).UnixMilli(), now.UnixMilli(), onlineTTL.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (b *RedisBackend) receiveLoop(ctx context.Context, deliver func(Envelope)) {
+	ch := b.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var env Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Printf("[hub] dropping malformed envelope on %s: %v", msg.Channel, err)
+				continue
+			}
+			deliver(env)
+		}
+	}
+}
+
+func (b *RedisBackend) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(onlineRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.refreshOnline(ctx); err != nil {
+				log.Printf("[hub] presence refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// refreshOnline extends the presence entries of all local users in one round
+// trip. The user list is copied under the lock; entries of users that left
+// while the pipeline ran are removed again so a stale refresh cannot undo a
+// Leave.
+func (b *RedisBackend) refreshOnline(ctx context.Context) error {
+	b.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(b.users))
+	for id, u := range b.users {
+		if u.subscribed {
+			ids = append(ids, id)
+		}
+	}
+	b.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	expires := float64(time.Now().Add(onlineTTL).UnixMilli())
+	pipe := b.client.Pipeline()
+	for _, id := range ids {
+		key := prefixHubOnline + id.String()
+		pipe.ZAdd(ctx, key, redis.Z{Score: expires, Member: b.nodeID})
+		pipe.PExpire(ctx, key, onlineTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	var gone []uuid.UUID
+	b.mu.Lock()
+	for _, id := range ids {
+		if u := b.users[id]; u == nil || !u.subscribed {
+			gone = append(gone, id)
+		}
+	}
+	b.mu.Unlock()
+	if len(gone) == 0 {
+		return nil
+	}
+	pipe = b.client.Pipeline()
+	for _, id := range gone {
+		pipe.ZRem(ctx, prefixHubOnline+id.String(), b.nodeID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
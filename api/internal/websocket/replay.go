@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// replayBatch is how many logged events are read per query while
+	// replaying.
+	replayBatch = 200
+
+	// seqGapTimeout is how long a socket holds events that arrived ahead of
+	// a missing seq before reading the missing ones from the event log.
+	seqGapTimeout = time.Second
+)
+
+// Replay sends the events logged for userID after since to client, then
+// releases the live events held back since Register. If the log no longer
+// reaches back to since, a resync_required event tells the client to reload
+// its state over REST first.
+func (h *Hub) Replay(ctx context.Context, userID uuid.UUID, client *clientConn, since int64) error {
+	last := since
+	defer h.finishReplay(client, &last)
+
+	for first := true; ; first = false {
+		events, oldest, err := h.events.ListSince(ctx, userID, last, replayBatch)
+		if err != nil {
+			return err
+		}
+		if first && oldest > since+1 {
+			data, _ := json.Marshal(map[string]any{
+				"type": "resync_required",
+				"data": map[string]any{"since": since, "oldest_seq": oldest},
+			})
+			if err := client.pushWait(ctx, data, 0); err != nil {
+				return err
+			}
+		}
+		for _, e := range events {
+			if err := client.pushWait(ctx, stampEvent(e.Payload, e.Seq, true), e.Seq); err != nil {
+				return err
+			}
+			last = e.Seq
+		}
+		if len(events) < replayBatch {
+			return nil
+		}
+	}
+}
+
+// finishReplay flushes the held-back live events the replay did not already
+// cover and switches the client to direct delivery. A client whose queue
+// cannot take the backlog is closed like any other slow consumer.
+func (h *Hub) finishReplay(client *clientConn, last *int64) {
+	client.replayMu.Lock()
+	defer client.replayMu.Unlock()
+	client.lastSeq = *last
+	for _, env := range client.backlog {
+		if err := client.sendInOrder(env); err != nil {
+			if err == errSlowConsumer {
+				hubStats.Add("slow_disconnects", 1)
+			}
+			client.close()
+			break
+		}
+	}
+	client.backlog = nil
+	client.replaying = false
+}
+
+// sendInOrder writes env if it is the next seq for this socket and holds it
+// in pending otherwise. Events are published after their transaction
+// commits, so two senders can reach a replica out of seq order; this puts
+// them back. A socket that has not seen a seq yet takes the first one as
+// its starting point. Callers hold replayMu.
+func (c *clientConn) sendInOrder(env Envelope) error {
+	if c.lastSeq > 0 {
+		if env.Seq <= c.lastSeq {
+			return nil
+		}
+		if env.Seq > c.lastSeq+1 {
+			if c.pending == nil {
+				c.pending = make(map[int64]Envelope)
+			}
+			c.pending[env.Seq] = env
+			if c.gapTimer == nil && c.fillGap != nil {
+				c.gapTimer = time.AfterFunc(seqGapTimeout, c.fillGap)
+			}
+			return nil
+		}
+	}
+	if err := c.push(env.Payload, env.Seq, false); err != nil {
+		return err
+	}
+	c.lastSeq = env.Seq
+	return c.flushPending(false)
+}
+
+// flushPending writes the held events that are now next in line. With
+// skipGaps it writes all of them, jumping over seqs that never arrived.
+func (c *clientConn) flushPending(skipGaps bool) error {
+	for len(c.pending) > 0 {
+		next := c.lastSeq + 1
+		if skipGaps {
+			next = 0
+			for seq := range c.pending {
+				if next == 0 || seq < next {
+					next = seq
+				}
+			}
+		}
+		env, ok := c.pending[next]
+		if !ok {
+			break
+		}
+		delete(c.pending, next)
+		if err := c.push(env.Payload, env.Seq, false); err != nil {
+			return err
+		}
+		c.lastSeq = next
+	}
+	if len(c.pending) == 0 && c.gapTimer != nil {
+		c.gapTimer.Stop()
+		c.gapTimer = nil
+	}
+	return nil
+}
+
+// fillGap runs when a socket has held events past seqGapTimeout. It reads
+// the missing seqs from the event log and writes everything held, so a lost
+// or failed publish cannot stall the stream.
+func (h *Hub) fillGap(userID uuid.UUID, c *clientConn) {
+	c.replayMu.Lock()
+	c.gapTimer = nil
+	after, upto := c.lastSeq, int64(0)
+	for seq := range c.pending {
+		if seq > upto {
+			upto = seq
+		}
+	}
+	c.replayMu.Unlock()
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	if upto == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	events, _, err := h.events.ListSince(ctx, userID, after, replayBatch)
+	cancel()
+	if err != nil {
+		log.Printf("[hub] cannot fill seq gap for user=%s: %v", userID, err)
+	}
+
+	c.replayMu.Lock()
+	for _, e := range events {
+		if e.Seq <= c.lastSeq || e.Seq >= upto {
+			continue
+		}
+		if _, ok := c.pending[e.Seq]; !ok {
+			c.pending[e.Seq] = Envelope{Kind: envelopeEvent, UserID: userID, Seq: e.Seq, Payload: stampEvent(e.Payload, e.Seq, false)}
+		}
+	}
+	err = c.flushPending(true)
+	c.replayMu.Unlock()
+	if err == errSlowConsumer {
+		h.dropSlowClient(userID, c)
+	}
+}
+
+// LastSeq returns the newest sequence number issued to userID.
+func (h *Hub) LastSeq(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return h.events.LastSeq(ctx, userID)
+}
+
+// stampEvent adds "seq" (and "replayed" for replays) to an encoded event
+// object without decoding it.
+func stampEvent(data []byte, seq int64, replayed bool) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	stamp := `{"seq":` + strconv.FormatInt(seq, 10)
+	if replayed {
+		stamp += `,"replayed":true`
+	}
+	out := make([]byte, 0, len(data)+len(stamp)+1)
+	out = append(out, stamp...)
+	if rest := data[1:]; len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
+
+// eventType reads the "type" field of an encoded event for the log.
+func eventType(data []byte) string {
+	var ev struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(data, &ev)
+	if ev.Type == "" {
+		return "unknown"
+	}
+	return ev.Type
+}
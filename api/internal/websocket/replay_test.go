@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStampEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		seq      int64
+		replayed bool
+		want     string
+	}{
+		{"live", `{"data":{"id":1},"type":"message"}`, 7, false, `{"seq":7,"data":{"id":1},"type":"message"}`},
+		{"replayed", `{"type":"notification"}`, 42, true, `{"seq":42,"replayed":true,"type":"notification"}`},
+		{"empty object", `{}`, 1, false, `{"seq":1}`},
+		{"not an object", `[1,2]`, 3, false, `[1,2]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(stampEvent([]byte(tt.in), tt.seq, tt.replayed))
+			if got != tt.want {
+				t.Fatalf("stampEvent(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Fatalf("stampEvent(%s) produced invalid JSON %s", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestEventType(t *testing.T) {
+	if got := eventType([]byte(`{"type":"call_invite","data":{}}`)); got != "call_invite" {
+		t.Fatalf("eventType = %q, want call_invite", got)
+	}
+	if got := eventType([]byte(`{"data":{}}`)); got != "unknown" {
+		t.Fatalf("eventType without type = %q, want unknown", got)
+	}
+}
+
+func TestSendInOrder(t *testing.T) {
+	c := &clientConn{out: make(chan outbound, 8), done: make(chan struct{}), lastSeq: 4}
+	for _, seq := range []int64{4, 6, 7, 5, 6, 9} {
+		if err := c.send(Envelope{Seq: seq, Payload: []byte(`{}`)}); err != nil {
+			t.Fatalf("send seq %d: %v", seq, err)
+		}
+	}
+	for _, want := range []int64{5, 6, 7} {
+		if got := (<-c.out).seq; got != want {
+			t.Fatalf("wrote seq %d, want %d", got, want)
+		}
+	}
+	if len(c.out) != 0 {
+		t.Fatalf("seq 9 written before seq 8 arrived")
+	}
+
+	c.replayMu.Lock()
+	err := c.flushPending(true)
+	c.replayMu.Unlock()
+	if err != nil {
+		t.Fatalf("flushPending: %v", err)
+	}
+	if got := (<-c.out).seq; got != 9 || c.lastSeq != 9 {
+		t.Fatalf("after skipping the gap wrote seq %d (last %d), want 9", got, c.lastSeq)
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"matcha/api/internal/middleware"
+)
+
+// streamHeartbeat keeps proxies from closing an idle stream and refreshes
+// the user's last_seen, like websocket pings do.
+const streamHeartbeat = 25 * time.Second
+
+// Stream godoc
+// @Summary	Realtime events over Server-Sent Events
+// @Description	One-way fallback for networks that block websockets: the same events as /api/v1/ws/chat, one JSON object per "data:" line. Sequenced events carry their seq as the SSE id, so a reconnect with Last-Event-ID (or ?since=) replays what was missed. Send messages over REST.
+// @Tags		chat
+// @Security	BearerAuth
+// @Produce	text/event-stream
+// @Param		since	query	int		false	"Replay events after this seq"
+// @Param		resume	query	string	false	"1 to continue after the last seq acked by this session"
+// @Success	200
+// @Failure	400	{object}	map[string]string
+// @Router		/api/v1/events/stream [get]
+func (h *ChatHandler) Stream(c *gin.Context) {
+	uid, _ := c.Get(middleware.UserIDKey)
+	userID := uid.(uuid.UUID)
+	sessionID := c.GetString(middleware.SessionIDKey)
+
+	since, replay, err := h.streamResumePoint(c, sessionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	rc := http.NewResponseController(c.Writer)
+	// Tell EventSource how long to wait before reconnecting.
+	if _, err := c.Writer.WriteString("retry: 3000\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	client, cameOnline := h.hub.RegisterStream(userID, sessionID, replay)
+	defer func() {
+		h.hub.Unregister(userID, client)
+		h.disconnected(userID)
+	}()
+	if cameOnline {
+		go h.broadcastPresence(userID, presenceOnline, nil)
+	}
+
+	h.sendConnected(client, userID)
+	// Replay fills the queue this goroutine drains, so it cannot run inline.
+	go func() {
+		if replay {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			err := h.hub.Replay(ctx, userID, client, since)
+			cancel()
+			if err != nil {
+				client.close()
+				return
+			}
+		}
+		h.sendPresenceSnapshot(client, userID)
+	}()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		var frame string
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-client.done:
+			return
+		case <-ticker.C:
+			_ = h.presenceRepo.UpsertLastSeen(context.Background(), userID, time.Now().UTC())
+			frame = ": ping\n\n"
+		case m := <-client.out:
+			if m.close != 0 {
+				return
+			}
+			frame = sseFrame(m.data, m.seq)
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.Writer.WriteString(frame); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamResumePoint prefers the Last-Event-ID header EventSource sends on
+// reconnect over the query parameters the websocket accepts.
+func (h *ChatHandler) streamResumePoint(c *gin.Context, sessionID string) (int64, bool, error) {
+	if raw := strings.TrimSpace(c.GetHeader("Last-Event-ID")); raw != "" {
+		since, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || since < 0 {
+			return 0, false, errors.New("Last-Event-ID must be a non-negative integer")
+		}
+		return since, true, nil
+	}
+	return h.resumePoint(c, sessionID)
+}
+
+// sseFrame encodes one event. Events are single-line JSON, so one data line
+// is enough; seq, when set, becomes the event id.
+func sseFrame(data []byte, seq int64) string {
+	var b strings.Builder
+	if seq > 0 {
+		b.WriteString("id: ")
+		b.WriteString(strconv.FormatInt(seq, 10))
+		b.WriteByte('\n')
+	}
+	b.WriteString("data: ")
+	b.Write(data)
+	b.WriteString("\n\n")
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package websocket
+
+import "testing"
+
+func TestSSEFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		seq  int64
+		want string
+	}{
+		{"sequenced", `{"seq":5,"type":"message"}`, 5, "id: 5\ndata: {\"seq\":5,\"type\":\"message\"}\n\n"},
+		{"ephemeral", `{"type":"typing_start"}`, 0, "data: {\"type\":\"typing_start\"}\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sseFrame([]byte(tt.data), tt.seq); got != tt.want {
+				t.Fatalf("sseFrame(%s, %d) = %q, want %q", tt.data, tt.seq, got, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import Home from './pages/Home'
 import Login from './pages/Login'
 import Register from './pages/Register'
 import ForgotPassword from './pages/ForgotPassword'
+import MagicLink from './pages/MagicLink'
 import ResetPassword from './pages/ResetPassword'
 import Profile from './pages/Profile'
 import Discovery from './pages/Discovery'
@@ -28,6 +29,7 @@ export default function App() {
               <Route path="/login" element={<Login />} />
               <Route path="/register" element={<Register />} />
               <Route path="/forgot-password" element={<ForgotPassword />} />
+              <Route path="/magic-link" element={<MagicLink />} />
               <Route path="/reset-password" element={<ResetPassword />} />
               <Route
                 path="/profile"
@@ -4,7 +4,49 @@ function getToken() {
   return localStorage.getItem('token')
 }
 
-export async function api(endpoint, options = {}) {
+export function setTokens(token, refreshToken) {
+  localStorage.setItem('token', token)
+  if (refreshToken) {
+    localStorage.setItem('refresh_token', refreshToken)
+  }
+}
+
+export function clearTokens() {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+}
+
+let refreshInFlight = null
+
+// Access tokens are short-lived; swap the refresh token for a new pair once,
+// sharing the request between concurrent callers.
+function refreshTokens() {
+  const refreshToken = localStorage.getItem('refresh_token')
+  if (!refreshToken) return Promise.resolve(false)
+  if (!refreshInFlight) {
+    refreshInFlight = fetch(`${API_BASE}/api/v1/auth/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    })
+      .then(async (res) => {
+        if (!res.ok) {
+          clearTokens()
+          return false
+        }
+        const data = await res.json()
+        setTokens(data.token, data.refresh_token)
+        return true
+      })
+      .catch(() => false)
+      .finally(() => {
+        refreshInFlight = null
+      })
+  }
+  return refreshInFlight
+}
+
+export async function api(endpoint, options = {}, retried = false) {
   const headers = { ...options.headers }
   const isFormData = options.body instanceof FormData
   if (!isFormData && !headers['Content-Type']) {
@@ -15,6 +57,9 @@ export async function api(endpoint, options = {}) {
     headers['Authorization'] = token.startsWith('Bearer ') ? token : `Bearer ${token}`
   }
   const res = await fetch(`${API_BASE}${endpoint}`, { ...options, headers })
+  if (res.status === 401 && token && !retried && (await refreshTokens())) {
+    return api(endpoint, options, true)
+  }
   const data = await res.json().catch(() => ({}))
   if (!res.ok) {
     throw new Error(data.error || `HTTP ${res.status}`)
@@ -22,15 +67,39 @@ export async function api(endpoint, options = {}) {
   return data
 }
 
+// fetchObjectURL loads a file that needs the Authorization header (e.g. a
+// private chat image) and returns an object URL for it. Revoke it when done.
+export async function fetchObjectURL(endpoint, retried = false) {
+  const headers = {}
+  const token = getToken()
+  if (token) headers.Authorization = token.startsWith('Bearer ') ? token : `Bearer ${token}`
+  const res = await fetch(`${API_BASE}${endpoint}`, { headers })
+  if (res.status === 401 && token && !retried && (await refreshTokens())) {
+    return fetchObjectURL(endpoint, true)
+  }
+  if (!res.ok) throw new Error(`HTTP ${res.status}`)
+  return URL.createObjectURL(await res.blob())
+}
+
 export const auth = {
   register: (body) => api('/api/v1/auth/register', { method: 'POST', body: JSON.stringify(body) }),
   login: (body) => api('/api/v1/auth/login', { method: 'POST', body: JSON.stringify(body) }),
   forgotPassword: (body) => api('/api/v1/auth/forgot-password', { method: 'POST', body: JSON.stringify(body) }),
+  requestMagicLink: (body) => api('/api/v1/auth/magic-link', { method: 'POST', body: JSON.stringify(body) }),
   resetPassword: (body) => api('/api/v1/auth/reset-password', { method: 'POST', body: JSON.stringify(body) }),
+  refresh: (refreshToken) => api('/api/v1/auth/refresh', { method: 'POST', body: JSON.stringify({ refresh_token: refreshToken }) }),
   logout: () => api('/api/v1/auth/logout', { method: 'POST', body: JSON.stringify({}) }),
+  sessions: () => api('/api/v1/auth/sessions'),
+  revokeSession: (id) => api(`/api/v1/auth/sessions/${id}`, { method: 'DELETE' }),
   me: () => api('/api/v1/auth/me'),
   updateMe: (body) => api('/api/v1/auth/me', { method: 'PATCH', body: JSON.stringify(body) }),
   changePassword: (body) => api('/api/v1/auth/me/password', { method: 'PATCH', body: JSON.stringify(body) }),
+  requestExport: () => api('/api/v1/auth/me/export', { method: 'POST', body: JSON.stringify({}) }),
+  deleteMe: (password) => api('/api/v1/auth/me', { method: 'DELETE', body: JSON.stringify({ password }) }),
+  verifyMfa: (body) => api('/api/v1/auth/2fa/verify', { method: 'POST', body: JSON.stringify(body) }),
+  enrollTotp: () => api('/api/v1/auth/2fa/enroll', { method: 'POST', body: JSON.stringify({}) }),
+  confirmTotp: (code) => api('/api/v1/auth/2fa/confirm', { method: 'POST', body: JSON.stringify({ code }) }),
+  disableTotp: (body) => api('/api/v1/auth/2fa/disable', { method: 'POST', body: JSON.stringify(body) }),
 }
 
 export const profile = {
@@ -99,10 +168,10 @@ export const chat = {
     const q = new URLSearchParams(params).toString()
     return api(`/api/v1/users/${userId}/messages${q ? '?' + q : ''}`)
   },
-  sendMessage: (userId, content) =>
+  sendMessage: (userId, content, replyToMessageId) =>
     api(`/api/v1/users/${userId}/messages`, {
       method: 'POST',
-      body: JSON.stringify({ content }),
+      body: JSON.stringify({ content, reply_to_message_id: replyToMessageId || undefined }),
     }),
   sendVoiceMessage: (userId, file) => {
     const body = new FormData()
@@ -112,11 +181,61 @@ export const chat = {
       body,
     })
   },
+  sendImageMessage: (userId, file) => {
+    const body = new FormData()
+    body.append('file', file)
+    return api(`/api/v1/users/${userId}/messages/image`, {
+      method: 'POST',
+      body,
+    })
+  },
+  editMessage: (messageId, content) =>
+    api(`/api/v1/messages/${messageId}`, {
+      method: 'PATCH',
+      body: JSON.stringify({ content }),
+    }),
+  deleteMessage: (messageId) =>
+    api(`/api/v1/messages/${messageId}`, { method: 'DELETE' }),
+  toggleReaction: (messageId, emoji) =>
+    api(`/api/v1/messages/${messageId}/reactions`, {
+      method: 'POST',
+      body: JSON.stringify({ emoji }),
+    }),
   markRead: (userId) =>
     api(`/api/v1/users/${userId}/messages/read`, {
       method: 'PATCH',
       body: JSON.stringify({}),
     }),
+  searchMessages: (params = {}) => {
+    const q = new URLSearchParams(params).toString()
+    return api(`/api/v1/messages/search${q ? '?' + q : ''}`)
+  },
+  listCalls: (userId, params = {}) => {
+    const q = new URLSearchParams(params).toString()
+    return api(`/api/v1/users/${userId}/calls${q ? '?' + q : ''}`)
+  },
+}
+
+export const conversations = {
+  list: (params = {}) => {
+    const q = new URLSearchParams(params).toString()
+    return api(`/api/v1/conversations${q ? '?' + q : ''}`)
+  },
+  update: (userId, body) =>
+    api(`/api/v1/conversations/${userId}`, {
+      method: 'PATCH',
+      body: JSON.stringify(body),
+    }),
+  getRetention: (userId) => api(`/api/v1/conversations/${userId}/retention`),
+  setRetention: (userId, retention) =>
+    api(`/api/v1/conversations/${userId}/retention`, {
+      method: 'PUT',
+      body: JSON.stringify({ retention }),
+    }),
+}
+
+export const calls = {
+  iceServers: () => api('/api/v1/calls/ice-servers'),
 }
 
 export const notifications = {
@@ -146,7 +265,62 @@ export const photos = {
   setPrimary: (id) => api(`/api/v1/photos/${id}/primary`, { method: 'PATCH', body: JSON.stringify({}) }),
 }
 
-export function wsChatUrl() {
+// openEventStream is the Server-Sent Events fallback for networks that block
+// websockets. It uses fetch because EventSource cannot send the
+// Authorization header, and reconnects with Last-Event-ID until the returned
+// function is called. onEvent receives the raw JSON of each event.
+export function openEventStream(onEvent, { since = 0, onOpen, onDrop } = {}) {
+  let closed = false
+  let controller = null
+  let lastId = since > 0 ? String(since) : ''
+
+  const run = async () => {
+    while (!closed) {
+      controller = new AbortController()
+      try {
+        const headers = { Accept: 'text/event-stream' }
+        const token = getToken()
+        if (token) headers.Authorization = token.startsWith('Bearer ') ? token : `Bearer ${token}`
+        if (lastId) headers['Last-Event-ID'] = lastId
+        const res = await fetch(`${API_BASE}/api/v1/events/stream`, { headers, signal: controller.signal })
+        if (res.status === 401 && token && (await refreshTokens())) continue
+        if (!res.ok || !res.body) throw new Error(`HTTP ${res.status}`)
+        onOpen?.()
+        const reader = res.body.pipeThrough(new TextDecoderStream()).getReader()
+        let buf = ''
+        for (;;) {
+          const { value, done } = await reader.read()
+          if (done) break
+          buf += value
+          let end
+          while ((end = buf.indexOf('\n\n')) >= 0) {
+            const frame = buf.slice(0, end)
+            buf = buf.slice(end + 2)
+            let data = ''
+            for (const line of frame.split('\n')) {
+              if (line.startsWith('id: ')) lastId = line.slice(4)
+              else if (line.startsWith('data: ')) data += line.slice(6)
+            }
+            if (data) onEvent(data)
+          }
+        }
+      } catch {
+      }
+      if (closed) return
+      onDrop?.()
+      await new Promise((resolve) => setTimeout(resolve, 3000))
+    }
+  }
+
+  run()
+  return () => {
+    closed = true
+    controller?.abort()
+  }
+}
+
+// since: resume after this event seq (replays what was missed while offline).
+export function wsChatUrl(since) {
   const token = getToken()
   if (!token) return null
   const normalized = token.startsWith('Bearer ') ? token.slice(7) : token
@@ -155,5 +329,6 @@ export function wsChatUrl() {
     .replace(/^http:\/\//, 'ws://')
     .replace(/^https:\/\//, 'wss://')
     .replace(/\/$/, '')
-  return `${wsBase}/api/v1/ws/chat?token=${encodeURIComponent(normalized)}`
+  const url = `${wsBase}/api/v1/ws/chat?token=${encodeURIComponent(normalized)}`
+  return since > 0 ? `${url}&since=${since}` : url
 }
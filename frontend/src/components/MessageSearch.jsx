@@ -0,0 +1,125 @@
+import { useState } from 'react'
+import { Link } from 'react-router-dom'
+import { chat } from '../api/client'
+
+const PAGE_SIZE = 20
+
+// mergeGroups appends a page of grouped hits, folding a conversation that
+// continues from the previous page into its existing group.
+function mergeGroups(prev, page) {
+  const next = prev.map((g) => ({ ...g, hits: [...g.hits] }))
+  for (const group of page) {
+    const existing = next.find((g) => g.peer.id === group.peer.id)
+    if (existing) existing.hits.push(...group.hits)
+    else next.push(group)
+  }
+  return next
+}
+
+export default function MessageSearch() {
+  const [query, setQuery] = useState('')
+  const [submitted, setSubmitted] = useState('')
+  const [groups, setGroups] = useState([])
+  const [nextCursor, setNextCursor] = useState('')
+  const [hasMore, setHasMore] = useState(false)
+  const [loading, setLoading] = useState(false)
+  const [error, setError] = useState('')
+
+  const run = async (q, cursor = '') => {
+    setLoading(true)
+    setError('')
+    try {
+      const params = { q, limit: PAGE_SIZE }
+      if (cursor) params.cursor = cursor
+      const data = await chat.searchMessages(params)
+      setGroups((prev) => (cursor ? mergeGroups(prev, data.items || []) : data.items || []))
+      setNextCursor(data.next_cursor || '')
+      setHasMore(!!data.has_more)
+    } catch (err) {
+      setError(err.message || 'Search failed')
+      if (!cursor) setGroups([])
+      setHasMore(false)
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const onSubmit = (e) => {
+    e.preventDefault()
+    const q = query.trim()
+    if (!q) {
+      setSubmitted('')
+      setGroups([])
+      setHasMore(false)
+      return
+    }
+    setSubmitted(q)
+    run(q)
+  }
+
+  return (
+    <div className="mb-6">
+      <form onSubmit={onSubmit} className="flex gap-2">
+        <input
+          type="search"
+          value={query}
+          onChange={(e) => setQuery(e.target.value)}
+          maxLength={200}
+          placeholder="Search your messages"
+          className="flex-1 px-4 py-2 rounded-lg border border-slate-300 focus:outline-none focus:ring-2 focus:ring-rose-300"
+        />
+        <button
+          type="submit"
+          disabled={loading}
+          className="px-4 py-2 rounded-lg bg-rose-500 text-white hover:bg-rose-600 disabled:opacity-60"
+        >
+          Search
+        </button>
+      </form>
+      {error && <p className="text-rose-600 mt-2">{error}</p>}
+      {submitted && !loading && !error && groups.length === 0 && (
+        <p className="text-slate-500 mt-3">No messages match “{submitted}”.</p>
+      )}
+      {groups.length > 0 && (
+        <div className="mt-4 space-y-4">
+          {groups.map((group) => (
+            <div key={group.peer.id} className="rounded-xl border border-slate-200 bg-white p-4">
+              <Link to={`/chat/${group.peer.id}`} className="font-semibold text-slate-800 hover:text-rose-600">
+                {group.peer.first_name} {group.peer.last_name}
+                <span className="ml-1 text-xs font-normal text-slate-500">@{group.peer.username}</span>
+              </Link>
+              <ul className="mt-2 space-y-2">
+                {group.hits.map((hit) => (
+                  <li key={hit.id} className="text-sm text-slate-700">
+                    <span className="mr-2 text-xs text-slate-400">
+                      {new Date(hit.created_at).toLocaleString()}
+                    </span>
+                    {(hit.snippet || []).map((seg, i) =>
+                      seg.match ? (
+                        <mark key={i} className="bg-amber-200 rounded px-0.5">{seg.text}</mark>
+                      ) : (
+                        <span key={i}>{seg.text}</span>
+                      ),
+                    )}
+                  </li>
+                ))}
+              </ul>
+            </div>
+          ))}
+          {hasMore && (
+            <div className="flex justify-center">
+              <button
+                type="button"
+                onClick={() => run(submitted, nextCursor)}
+                disabled={loading}
+                className="px-4 py-2 rounded-lg border border-slate-300 text-slate-700 hover:bg-slate-50 disabled:opacity-60"
+              >
+                {loading ? 'Loading...' : 'More results'}
+              </button>
+            </div>
+          )}
+        </div>
+      )}
+    </div>
+  )
+}
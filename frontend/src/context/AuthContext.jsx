@@ -1,5 +1,5 @@
 import { createContext, useContext, useState, useEffect } from 'react'
-import { auth as authApi } from '../api/client'
+import { auth as authApi, setTokens, clearTokens } from '../api/client'
 
 const AuthContext = createContext(null)
 
@@ -11,8 +11,9 @@ export function AuthProvider({ children }) {
     const params = new URLSearchParams(window.location.search)
     const tokenFromUrl = params.get('token')
     if (tokenFromUrl) {
-      localStorage.setItem('token', tokenFromUrl)
+      setTokens(tokenFromUrl, params.get('refresh_token'))
       params.delete('token')
+      params.delete('refresh_token')
       const newSearch = params.toString()
       window.history.replaceState({}, '', window.location.pathname + (newSearch ? '?' + newSearch : '') + window.location.hash)
     }
@@ -25,19 +26,21 @@ export function AuthProvider({ children }) {
       .me()
       .then((data) => setUser(data))
       .catch(() => {
-        localStorage.removeItem('token')
+        clearTokens()
       })
       .finally(() => setLoading(false))
   }, [])
 
-  const login = (token, userData) => {
-    localStorage.setItem('token', token)
+  const login = (token, userData, refreshToken) => {
+    setTokens(token, refreshToken)
     setUser(userData)
   }
 
   const logout = () => {
-    authApi.logout().catch(() => {})
-    localStorage.removeItem('token')
+    authApi
+      .logout()
+      .catch(() => {})
+      .finally(() => clearTokens())
     setUser(null)
   }
 
@@ -1,5 +1,5 @@
 import { createContext, useCallback, useContext, useEffect, useMemo, useState } from 'react'
-import { notifications, wsChatUrl } from '../api/client'
+import { notifications, openEventStream, wsChatUrl } from '../api/client'
 import { useAuth } from './AuthContext'
 
 const NotificationsContext = createContext(null)
@@ -10,6 +10,8 @@ export function NotificationsProvider({ children }) {
   const [likesCount, setLikesCount] = useState(0)
   const [matchesCount, setMatchesCount] = useState(0)
   const [viewsCount, setViewsCount] = useState(0)
+  // Presence of matches pushed over the websocket: { [userId]: { status, last_seen } }
+  const [presenceByUser, setPresenceByUser] = useState({})
 
   const refreshUnread = useCallback(async () => {
     if (!user) {
@@ -49,23 +51,49 @@ export function NotificationsProvider({ children }) {
     const timer = setInterval(safeRefresh, 30000)
     const url = wsChatUrl()
     let ws = null
+    let closeStream = null
+    const reportVisibility = () => {
+      if (ws?.readyState !== WebSocket.OPEN) return
+      ws.send(JSON.stringify({ type: 'presence', status: document.hidden ? 'away' : 'online' }))
+    }
+    const handleEvent = (raw) => {
+      try {
+        const payload = JSON.parse(raw)
+        if (payload?.type === 'notification') {
+          safeRefresh()
+        } else if (payload?.type === 'presence_snapshot') {
+          const next = {}
+          for (const id of payload.data?.online_user_ids || []) next[id] = { status: 'online', last_seen: null }
+          setPresenceByUser(next)
+        } else if (payload?.type === 'presence' && payload.data) {
+          const { user_id: id, status, last_seen: lastSeen } = payload.data
+          setPresenceByUser((prev) => ({ ...prev, [id]: { status, last_seen: lastSeen } }))
+        }
+      } catch {
+      }
+    }
     if (url) {
       ws = new WebSocket(url)
-      ws.onmessage = (event) => {
-        try {
-          const payload = JSON.parse(event.data)
-          if (payload?.type === 'notification') {
-            safeRefresh()
-          }
-        } catch {
-        }
+      let opened = false
+      ws.onopen = () => {
+        opened = true
+      }
+      // A socket that never opens is most likely blocked by a proxy; fall
+      // back to the SSE stream.
+      ws.onclose = () => {
+        if (active && !opened) closeStream = openEventStream(handleEvent)
       }
+      ws.onmessage = (event) => handleEvent(event.data)
+      document.addEventListener('visibilitychange', reportVisibility)
     }
 
     return () => {
       active = false
       clearInterval(timer)
+      document.removeEventListener('visibilitychange', reportVisibility)
+      setPresenceByUser({})
       if (ws) ws.close()
+      if (closeStream) closeStream()
     }
   }, [user, refreshUnread])
 
@@ -77,8 +105,9 @@ export function NotificationsProvider({ children }) {
       viewsCount,
       refreshUnread,
       setUnreadCount,
+      presenceByUser,
     }),
-    [unreadCount, likesCount, matchesCount, viewsCount, refreshUnread],
+    [unreadCount, likesCount, matchesCount, viewsCount, refreshUnread, presenceByUser],
   )
 
   return <NotificationsContext.Provider value={value}>{children}</NotificationsContext.Provider>
@@ -1,6 +1,6 @@
 import { useEffect, useRef, useState } from 'react'
 import { Link, useLocation, useParams } from 'react-router-dom'
-import { chat, presence, users, wsChatUrl } from '../api/client'
+import { calls, chat, conversations, fetchObjectURL, openEventStream, presence, users, wsChatUrl } from '../api/client'
 import { useAuth } from '../context/AuthContext'
 
 function formatDate(ts) {
@@ -29,6 +29,118 @@ function friendlyCallError(err) {
   return err?.message || 'Failed to start call'
 }
 
+// mergeMessages folds a freshly fetched window into what is already shown,
+// keeping older pages the user scrolled back to.
+function mergeMessages(prev, next) {
+  const byId = new Map(prev.map((m) => [m.id, m]))
+  for (const m of next) byId.set(m.id, m)
+  return [...byId.values()].sort((a, b) => new Date(a.created_at) - new Date(b.created_at))
+}
+
+// The server's default MESSAGE_EDIT_WINDOW_SECONDS; it has the final say.
+const EDIT_WINDOW_MS = 15 * 60 * 1000
+
+function canChange(m, userId) {
+  return (
+    m.sender_id === userId &&
+    !m.deleted_at &&
+    Date.now() - new Date(m.created_at).getTime() < EDIT_WINDOW_MS
+  )
+}
+
+// ChatImage shows a private chat attachment, which has to be fetched with
+// the user's token rather than linked directly.
+function ChatImage({ src, alt }) {
+  const [url, setUrl] = useState('')
+
+  useEffect(() => {
+    let active = true
+    let objectUrl = ''
+    fetchObjectURL(src)
+      .then((u) => {
+        objectUrl = u
+        if (active) setUrl(u)
+        else URL.revokeObjectURL(u)
+      })
+      .catch(() => {})
+    return () => {
+      active = false
+      if (objectUrl) URL.revokeObjectURL(objectUrl)
+    }
+  }, [src])
+
+  if (!url) return <div className="w-48 h-32 rounded-lg bg-slate-100 animate-pulse" />
+  return (
+    <a href={url} target="_blank" rel="noreferrer">
+      <img src={url} alt={alt} className="max-w-[240px] max-h-[320px] rounded-lg object-cover" />
+    </a>
+  )
+}
+
+function formatDuration(ms) {
+  const total = Math.round(ms / 1000)
+  return `${Math.floor(total / 60)}:${String(total % 60).padStart(2, '0')}`
+}
+
+// VoiceMeta shows what the server measured on a processed voice note.
+function VoiceMeta({ message, mine }) {
+  if (message.media_status === 'processing') {
+    return <span className={`text-xs ${mine ? 'text-rose-100' : 'text-slate-400'}`}>Processing…</span>
+  }
+  const meta = message.metadata
+  if (!meta) return null
+  return (
+    <div className="flex items-center gap-2">
+      <div className="flex items-center gap-px h-6" aria-hidden="true">
+        {(meta.waveform || []).map((level, i) => (
+          <span
+            key={i}
+            className={`w-0.5 rounded-full ${mine ? 'bg-rose-100' : 'bg-rose-400'}`}
+            style={{ height: `${Math.max(8, level)}%` }}
+          />
+        ))}
+      </div>
+      <span className={`text-xs tabular-nums ${mine ? 'text-rose-100' : 'text-slate-500'}`}>
+        {formatDuration(meta.duration_ms)}
+      </span>
+    </div>
+  )
+}
+
+const QUICK_REACTIONS = ['❤️', '😂', '👍', '😮', '😢']
+
+const RETENTION_OPTIONS = [
+  ['off', 'Off'],
+  ['24h', '24 hours'],
+  ['7d', '7 days'],
+  ['30d', '30 days'],
+]
+
+// isExpired tells whether a disappearing message is past its timer; the
+// server deletes it shortly after.
+function isExpired(m, now = Date.now()) {
+  return !!m.expires_at && new Date(m.expires_at).getTime() <= now
+}
+
+// applyReaction mirrors a reaction_added/reaction_removed event onto the
+// message's grouped reactions.
+function applyReaction(m, { user_id: userId, emoji }, added) {
+  const groups = (m.reactions || []).map((g) => ({ ...g, user_ids: [...g.user_ids] }))
+  let group = groups.find((g) => g.emoji === emoji)
+  if (added) {
+    if (!group) {
+      group = { emoji, user_ids: [] }
+      groups.push(group)
+    }
+    if (!group.user_ids.includes(userId)) group.user_ids.push(userId)
+  } else if (group) {
+    group.user_ids = group.user_ids.filter((id) => id !== userId)
+  }
+  return { ...m, reactions: groups.filter((g) => g.user_ids.length > 0) }
+}
+
+const FALLBACK_ICE_SERVERS = [{ urls: 'stun:stun.l.google.com:19302' }]
+
 function createCallId() {
   const maybeRandomUUID = globalThis?.crypto?.randomUUID
   if (typeof maybeRandomUUID === 'function') return maybeRandomUUID.call(globalThis.crypto)
@@ -43,6 +155,13 @@ export default function Chat() {
   const [profile, setProfile] = useState(null)
   const [presenceState, setPresenceState] = useState({ is_online: false, last_seen: null })
   const [messages, setMessages] = useState([])
+  const [olderCursor, setOlderCursor] = useState('')
+  const [hasOlder, setHasOlder] = useState(false)
+  const [loadingOlder, setLoadingOlder] = useState(false)
+  const [editing, setEditing] = useState(null)
+  const [replyTo, setReplyTo] = useState(null)
+  const [retention, setRetention] = useState('off')
+  const [reactingId, setReactingId] = useState(null)
   const [input, setInput] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(true)
@@ -51,6 +170,7 @@ export default function Chat() {
   const [incomingOffer, setIncomingOffer] = useState(null)
   const [callMode, setCallMode] = useState('video')
   const [isRecordingVoice, setIsRecordingVoice] = useState(false)
+  const [peerTyping, setPeerTyping] = useState(false)
 
   const wsRef = useRef(null)
   const listEndRef = useRef(null)
@@ -62,12 +182,20 @@ export default function Chat() {
   const activeCallIdRef = useRef('')
   const callStateRef = useRef('idle')
   const activeCallModeRef = useRef('video')
+  const iceConfigRef = useRef(null)
   const mediaRecorderRef = useRef(null)
   const mediaChunksRef = useRef([])
+  const imageInputRef = useRef(null)
+  const typingSentAtRef = useRef(0)
+  const typingStopTimerRef = useRef(null)
+  const peerTypingTimerRef = useRef(null)
 
+  // Only follow the bottom when a newer message arrives, not when an older
+  // page is prepended.
+  const lastMessageId = messages[messages.length - 1]?.id
   useEffect(() => {
     listEndRef.current?.scrollIntoView({ behavior: 'smooth' })
-  }, [messages])
+  }, [lastMessageId])
 
   useEffect(() => {
     callStateRef.current = callState
@@ -127,10 +255,22 @@ export default function Chat() {
     }
   }, [])
 
-  const setupPeerConnection = (callId) => {
-    const pc = new RTCPeerConnection({
-      iceServers: [{ urls: 'stun:stun.l.google.com:19302' }],
-    })
+  // TURN credentials expire, so they are refetched shortly before expires_at.
+  const loadIceServers = async () => {
+    const cached = iceConfigRef.current
+    if (cached && (!cached.expiresAt || cached.expiresAt - Date.now() > 60000)) return cached.servers
+    try {
+      const res = await calls.iceServers()
+      const servers = res.ice_servers?.length ? res.ice_servers : FALLBACK_ICE_SERVERS
+      iceConfigRef.current = { servers, expiresAt: res.expires_at ? new Date(res.expires_at).getTime() : 0 }
+      return servers
+    } catch {
+      return FALLBACK_ICE_SERVERS
+    }
+  }
+
+  const setupPeerConnection = (callId, iceServers) => {
+    const pc = new RTCPeerConnection({ iceServers })
     pc.onicecandidate = (event) => {
       if (!event.candidate || !activeCallIdRef.current) return
       try {
@@ -189,8 +329,8 @@ export default function Chat() {
       setCallMode(mode)
       activeCallModeRef.current = mode
       const callId = createCallId()
-      const stream = await getLocalMedia(mode)
-      const pc = setupPeerConnection(callId)
+      const [stream, iceServers] = await Promise.all([getLocalMedia(mode), loadIceServers()])
+      const pc = setupPeerConnection(callId, iceServers)
       stream.getTracks().forEach((track) => pc.addTrack(track, stream))
       const offer = await pc.createOffer()
       await pc.setLocalDescription(offer)
@@ -210,6 +350,19 @@ export default function Chat() {
   const startVideoCall = async () => startCall('video')
   const startVoiceCall = async () => startCall('audio')
 
+  const sendImage = async (e) => {
+    const file = e.target.files?.[0]
+    e.target.value = ''
+    if (!file) return
+    setError('')
+    try {
+      const created = await chat.sendImageMessage(otherUserId, file)
+      setMessages((prev) => (prev.some((x) => x.id === created.id) ? prev : [...prev, created]))
+    } catch (err) {
+      setError(err.message || 'Failed to send image')
+    }
+  }
+
   const startVoiceRecording = async () => {
     setError('')
     try {
@@ -267,8 +420,8 @@ export default function Chat() {
       const mode = incomingOffer.mode || 'video'
       setCallMode(mode)
       activeCallModeRef.current = mode
-      const stream = await getLocalMedia(mode)
-      const pc = setupPeerConnection(incomingOffer.call_id)
+      const [stream, iceServers] = await Promise.all([getLocalMedia(mode), loadIceServers()])
+      const pc = setupPeerConnection(incomingOffer.call_id, iceServers)
       stream.getTracks().forEach((track) => pc.addTrack(track, stream))
       await pc.setRemoteDescription({ type: 'offer', sdp: incomingOffer.sdp })
       const answer = await pc.createAnswer()
@@ -320,14 +473,18 @@ export default function Chat() {
 
     ;(async () => {
       try {
-        const [u, msgs, p] = await Promise.all([
+        const [u, page, p, r] = await Promise.all([
           users.getById(otherUserId),
           chat.listMessages(otherUserId),
           presence.get(otherUserId),
+          conversations.getRetention(otherUserId),
         ])
         if (!active) return
         setProfile(u)
-        setMessages(msgs)
+        setRetention(r.retention)
+        setMessages(page.items)
+        setOlderCursor(page.older_cursor)
+        setHasOlder(page.has_older)
         setPresenceState(p)
         setError('')
         await chat.markRead(otherUserId)
@@ -344,97 +501,177 @@ export default function Chat() {
   }, [otherUserId])
 
   useEffect(() => {
-    const wsUrl = wsChatUrl()
-    if (!wsUrl) return undefined
+    if (!wsChatUrl()) return undefined
 
     let active = true
     let ws = null
     let reconnectTimer = null
+    let lastSeq = 0
+    // After repeated failed websocket upgrades (e.g. a proxy strips them)
+    // events come over SSE instead; messages are then sent over REST.
+    let failedOpens = 0
+    let closeStream = null
 
     const connect = () => {
+      const wsUrl = wsChatUrl(lastSeq)
+      if (!wsUrl) return
       ws = new WebSocket(wsUrl)
       wsRef.current = ws
+      let opened = false
 
-      ws.onopen = () => setConnected(true)
-      ws.onclose = () => {
+      ws.onopen = () => {
+        opened = true
+        failedOpens = 0
+        setConnected(true)
+      }
+      ws.onclose = (event) => {
         setConnected(false)
-        if (active) {
-          reconnectTimer = setTimeout(connect, 2000)
+        if (!active) return
+        // 1008: the session was revoked; reconnecting would only be refused.
+        if (event.code === 1008) return
+        if (!opened && ++failedOpens >= 2) {
+          closeStream = openEventStream(handleEvent, { since: lastSeq })
+          return
         }
+        reconnectTimer = setTimeout(connect, 2000)
       }
       ws.onerror = () => setConnected(false)
-      ws.onmessage = async (event) => {
-        try {
-          const payload = JSON.parse(event.data)
-          if (payload.type === 'message' && payload.data) {
-            const m = payload.data
-            const isCurrentConversation =
-              (m.sender_id === otherUserId && m.receiver_id === user?.id) ||
-              (m.sender_id === user?.id && m.receiver_id === otherUserId)
-
-            if (isCurrentConversation) {
-              setMessages((prev) => {
-                if (prev.some((x) => x.id === m.id)) return prev
-                return [...prev, m]
-              })
-              if (m.sender_id === otherUserId) {
+      ws.onmessage = (event) => handleEvent(event.data)
+    }
+
+    const handleEvent = async (raw) => {
+      try {
+        const payload = JSON.parse(raw)
+        if (payload.seq) {
+          lastSeq = Math.max(lastSeq, payload.seq)
+          if (ws?.readyState === WebSocket.OPEN) ws.send(JSON.stringify({ type: 'ack', seq: payload.seq }))
+          // A replayed call signal belongs to a call that is long gone.
+          if (payload.replayed && payload.type?.startsWith('call_')) return
+        }
+        if (payload.type === 'connected' && payload.data) {
+          if (!lastSeq) lastSeq = payload.data.last_seq || 0
+        } else if (payload.type === 'presence_snapshot' && payload.data) {
+          const online = (payload.data.online_user_ids || []).includes(otherUserId)
+          setPresenceState((prev) => ({ ...prev, is_online: online, status: online ? 'online' : 'offline' }))
+        } else if (payload.type === 'presence' && payload.data) {
+          const d = payload.data
+          if (d.user_id !== otherUserId) return
+          setPresenceState((prev) => ({
+            is_online: d.status === 'online',
+            status: d.status,
+            last_seen: d.last_seen || prev.last_seen,
+          }))
+        } else if (payload.type === 'resync_required') {
+          const page = await chat.listMessages(otherUserId)
+          setMessages((prev) => mergeMessages(prev, page.items))
+        } else if (payload.type === 'message' && payload.data) {
+          const m = payload.data
+          const isCurrentConversation =
+            (m.sender_id === otherUserId && m.receiver_id === user?.id) ||
+            (m.sender_id === user?.id && m.receiver_id === otherUserId)
+
+          if (isCurrentConversation) {
+            setMessages((prev) => {
+              if (prev.some((x) => x.id === m.id)) return prev
+              return [...prev, m]
+            })
+            if (m.sender_id === otherUserId) {
+              setPeerTyping(false)
+              if (ws.readyState === WebSocket.OPEN) {
+                ws.send(JSON.stringify({ type: 'read', to_user_id: otherUserId }))
+              } else {
                 await chat.markRead(otherUserId)
               }
             }
-          } else if (payload.type === 'message_read' && payload.data) {
-            const r = payload.data
-            const readByOther = r.reader_id === otherUserId && r.sender_id === user?.id
-            if (readByOther) {
-              setMessages((prev) =>
-                prev.map((m) => {
-                  const shouldMark = m.sender_id === user?.id && m.receiver_id === otherUserId
-                  return shouldMark ? { ...m, is_read: true, read_at: r.read_at || m.read_at } : m
-                }),
-              )
-            }
-          } else if (payload.type === 'error') {
-            setError(payload.error || 'WebSocket error')
-          } else if (payload.type === 'call_invite' && payload.data) {
-            const d = payload.data
-            if (d.from_user_id !== otherUserId) return
-            if (callStateRef.current !== 'idle') {
-              sendWsEvent({
-                type: 'call_reject',
-                to_user_id: otherUserId,
-                call_id: d.call_id,
-              })
-              return
-            }
-            setIncomingOffer({ call_id: d.call_id, sdp: d.sdp, mode: d.mode || 'video' })
-            setCallState('incoming')
-          } else if (payload.type === 'call_accept' && payload.data) {
-            const d = payload.data
-            if (d.from_user_id !== otherUserId || !pcRef.current || !d.sdp) return
-            if (activeCallIdRef.current && d.call_id !== activeCallIdRef.current) return
-            setCallMode(d.mode || activeCallModeRef.current || 'video')
-            activeCallModeRef.current = d.mode || activeCallModeRef.current || 'video'
-            await pcRef.current.setRemoteDescription({ type: 'answer', sdp: d.sdp })
-            setCallState('connecting')
-          } else if (payload.type === 'call_ice' && payload.data) {
-            const d = payload.data
-            if (d.from_user_id !== otherUserId || !pcRef.current || !d.candidate) return
-            if (activeCallIdRef.current && d.call_id !== activeCallIdRef.current) return
-            try {
-              await pcRef.current.addIceCandidate(d.candidate)
-            } catch {
-            }
-          } else if (payload.type === 'call_reject' && payload.data) {
-            const d = payload.data
-            if (d.from_user_id !== otherUserId) return
-            setError('Call rejected')
-            stopVideoCall()
-          } else if (payload.type === 'call_end' && payload.data) {
-            const d = payload.data
-            if (d.from_user_id !== otherUserId) return
+          }
+        } else if ((payload.type === 'message_edited' || payload.type === 'message_processed') && payload.data) {
+          const m = payload.data
+          setMessages((prev) => prev.map((x) => (x.id === m.id ? { ...x, ...m } : x)))
+        } else if (payload.type === 'conversation_retention' && payload.data) {
+          if (payload.data.user_ids?.includes(otherUserId)) setRetention(payload.data.retention)
+        } else if ((payload.type === 'reaction_added' || payload.type === 'reaction_removed') && payload.data) {
+          const r = payload.data
+          const added = payload.type === 'reaction_added'
+          setMessages((prev) => prev.map((x) => (x.id === r.message_id ? applyReaction(x, r, added) : x)))
+        } else if (payload.type === 'message_deleted' && payload.data) {
+          const d = payload.data
+          setMessages((prev) =>
+            prev.map((x) =>
+              x.id === d.id ? { ...x, content: '', media_url: null, deleted_at: d.deleted_at } : x,
+            ),
+          )
+        } else if ((payload.type === 'typing_start' || payload.type === 'typing_stop') && payload.data) {
+          if (payload.data.from_user_id !== otherUserId) return
+          clearTimeout(peerTypingTimerRef.current)
+          const typing = payload.type === 'typing_start'
+          setPeerTyping(typing)
+          // typing_stop can get lost with the connection; don't show "typing" forever.
+          if (typing) peerTypingTimerRef.current = setTimeout(() => setPeerTyping(false), 6000)
+        } else if ((payload.type === 'message_read' || payload.type === 'messages_read') && payload.data) {
+          const r = payload.data
+          const readByOther = r.reader_id === otherUserId && r.sender_id === user?.id
+          if (readByOther) {
+            setMessages((prev) =>
+              prev.map((m) => {
+                const shouldMark = m.sender_id === user?.id && m.receiver_id === otherUserId
+                return shouldMark ? { ...m, is_read: true, read_at: r.read_at || m.read_at } : m
+              }),
+            )
+          }
+        } else if (payload.type === 'error') {
+          setError(payload.error || 'WebSocket error')
+        } else if (payload.type === 'call_invite' && payload.data) {
+          const d = payload.data
+          if (d.from_user_id !== otherUserId) return
+          if (callStateRef.current !== 'idle') {
+            sendWsEvent({
+              type: 'call_reject',
+              to_user_id: otherUserId,
+              call_id: d.call_id,
+            })
+            return
+          }
+          setIncomingOffer({ call_id: d.call_id, sdp: d.sdp, mode: d.mode || 'video' })
+          setCallState('incoming')
+        } else if (payload.type === 'call_accept' && payload.data) {
+          const d = payload.data
+          if (d.from_user_id !== otherUserId || !pcRef.current || !d.sdp) return
+          if (activeCallIdRef.current && d.call_id !== activeCallIdRef.current) return
+          setCallMode(d.mode || activeCallModeRef.current || 'video')
+          activeCallModeRef.current = d.mode || activeCallModeRef.current || 'video'
+          await pcRef.current.setRemoteDescription({ type: 'answer', sdp: d.sdp })
+          setCallState('connecting')
+        } else if (payload.type === 'call_ice' && payload.data) {
+          const d = payload.data
+          if (d.from_user_id !== otherUserId || !pcRef.current || !d.candidate) return
+          if (activeCallIdRef.current && d.call_id !== activeCallIdRef.current) return
+          try {
+            await pcRef.current.addIceCandidate(d.candidate)
+          } catch {
+          }
+        } else if (payload.type === 'call_reject' && payload.data) {
+          const d = payload.data
+          if (d.from_user_id !== otherUserId) return
+          setError('Call rejected')
+          stopVideoCall()
+        } else if (payload.type === 'call_busy' && payload.data) {
+          const d = payload.data
+          if (d.from_user_id !== otherUserId) return
+          setError('User is busy in another call')
+          stopVideoCall()
+        } else if (payload.type === 'call_end' && payload.data) {
+          const d = payload.data
+          // A ringing timeout is sent by the server to both sides.
+          if (d.reason === 'timeout') {
+            if (d.from_user_id !== otherUserId && d.to_user_id !== otherUserId) return
+            if (d.from_user_id === otherUserId) setError('No answer')
             stopVideoCall()
+            return
           }
-        } catch {
+          if (d.from_user_id !== otherUserId) return
+          stopVideoCall()
         }
+      } catch {
       }
     }
 
@@ -442,37 +679,123 @@ export default function Chat() {
     return () => {
       active = false
       if (reconnectTimer) clearTimeout(reconnectTimer)
+      if (closeStream) closeStream()
+      clearTimeout(peerTypingTimerRef.current)
+      clearTimeout(typingStopTimerRef.current)
+      typingSentAtRef.current = 0
+      setPeerTyping(false)
       if (ws) ws.close()
     }
   }, [otherUserId, user?.id])
 
   useEffect(() => {
     const id = setInterval(async () => {
+      if (connected) return
       try {
-        const p = await presence.get(otherUserId)
-        setPresenceState(p)
+        const page = await chat.listMessages(otherUserId)
+        setMessages((prev) => mergeMessages(prev, page.items))
       } catch {
       }
-    }, 15000)
+    }, 5000)
     return () => clearInterval(id)
-  }, [otherUserId])
+  }, [connected, otherUserId])
 
   useEffect(() => {
-    const id = setInterval(async () => {
-      if (connected) return
+    const id = setInterval(() => {
+      const now = Date.now()
+      setMessages((prev) => (prev.some((m) => isExpired(m, now)) ? prev.filter((m) => !isExpired(m, now)) : prev))
+    }, 30000)
+    return () => clearInterval(id)
+  }, [])
+
+  const changeRetention = async (value) => {
+    const previous = retention
+    setRetention(value)
+    try {
+      await conversations.setRetention(otherUserId, value)
+    } catch (err) {
+      setRetention(previous)
+      setError(err.message || 'Failed to change disappearing messages')
+    }
+  }
+
+  const loadOlder = async () => {
+    if (!hasOlder || loadingOlder) return
+    setLoadingOlder(true)
+    try {
+      const page = await chat.listMessages(otherUserId, { before: olderCursor })
+      setMessages((prev) => mergeMessages(prev, page.items))
+      setOlderCursor(page.older_cursor)
+      setHasOlder(page.has_older)
+    } catch (err) {
+      setError(err.message || 'Failed to load earlier messages')
+    } finally {
+      setLoadingOlder(false)
+    }
+  }
+
+  const saveEdit = async (e) => {
+    e.preventDefault()
+    const content = editing?.content.trim()
+    if (!content) return
+    try {
+      const updated = await chat.editMessage(editing.id, content)
+      setMessages((prev) => prev.map((x) => (x.id === updated.id ? updated : x)))
+      setEditing(null)
+    } catch (err) {
+      setError(err.message || 'Failed to edit message')
+    }
+  }
+
+  const react = async (messageId, emoji) => {
+    setReactingId(null)
+    try {
+      const r = await chat.toggleReaction(messageId, emoji)
+      setMessages((prev) => prev.map((x) => (x.id === messageId ? applyReaction(x, r, r.added) : x)))
+    } catch (err) {
+      setError(err.message || 'Failed to react')
+    }
+  }
+
+  const unsend = async (messageId) => {
+    try {
+      const tombstone = await chat.deleteMessage(messageId)
+      setMessages((prev) => prev.map((x) => (x.id === tombstone.id ? tombstone : x)))
+    } catch (err) {
+      setError(err.message || 'Failed to unsend message')
+    }
+  }
+
+  const stopTyping = () => {
+    clearTimeout(typingStopTimerRef.current)
+    if (!typingSentAtRef.current) return
+    typingSentAtRef.current = 0
+    try {
+      sendWsEvent({ type: 'typing_stop', to_user_id: otherUserId })
+    } catch {
+    }
+  }
+
+  const handleInputChange = (e) => {
+    setInput(e.target.value)
+    if (!wsRef.current || wsRef.current.readyState !== WebSocket.OPEN) return
+    const now = Date.now()
+    if (now - typingSentAtRef.current > 3000) {
+      typingSentAtRef.current = now
       try {
-        const msgs = await chat.listMessages(otherUserId)
-        setMessages(msgs)
+        sendWsEvent({ type: 'typing_start', to_user_id: otherUserId })
       } catch {
       }
-    }, 5000)
-    return () => clearInterval(id)
-  }, [connected, otherUserId])
+    }
+    clearTimeout(typingStopTimerRef.current)
+    typingStopTimerRef.current = setTimeout(stopTyping, 4000)
+  }
 
   const send = async (e) => {
     e.preventDefault()
     const content = input.trim()
     if (!content) return
+    stopTyping()
 
     setError('')
     try {
@@ -481,14 +804,16 @@ export default function Chat() {
           JSON.stringify({
             to_user_id: otherUserId,
             content,
+            reply_to_message_id: replyTo?.id,
           }),
         )
       } else {
-        await chat.sendMessage(otherUserId, content)
-        const refreshed = await chat.listMessages(otherUserId)
-        setMessages(refreshed)
+        await chat.sendMessage(otherUserId, content, replyTo?.id)
+        const page = await chat.listMessages(otherUserId)
+        setMessages((prev) => mergeMessages(prev, page.items))
       }
       setInput('')
+      setReplyTo(null)
     } catch (err) {
       setError(err.message || 'Failed to send message')
     }
@@ -552,7 +877,11 @@ export default function Chat() {
           <div className="flex items-center gap-1.5 mt-0.5">
             <span className={`w-2 h-2 rounded-full shrink-0 ${presenceState.is_online ? 'bg-emerald-500' : 'bg-slate-300'}`} />
             <span className="text-xs text-slate-500 truncate">
-              {presenceState.is_online
+              {peerTyping
+                ? 'typing…'
+                : presenceState.status === 'away'
+                ? 'Away'
+                : presenceState.is_online
                 ? 'Online now'
                 : presenceState.last_seen
                 ? `Last seen ${new Date(presenceState.last_seen).toLocaleString()}`
@@ -562,6 +891,18 @@ export default function Chat() {
         </div>
 
         <div className="flex items-center gap-2 shrink-0">
+          <select
+            value={retention}
+            onChange={(e) => changeRetention(e.target.value)}
+            title="Disappearing messages"
+            className="h-9 rounded-full border border-slate-200 bg-white px-2 text-xs text-slate-600 hover:border-rose-300 focus:outline-none"
+          >
+            {RETENTION_OPTIONS.map(([value, label]) => (
+              <option key={value} value={value}>
+                {value === 'off' ? '⏱ Off' : `⏱ ${label}`}
+              </option>
+            ))}
+          </select>
           <button
             type="button"
             onClick={startVoiceCall}
@@ -745,9 +1086,32 @@ export default function Chat() {
           </div>
         ) : (
           <>
-            {messages.map((m) => {
+            {hasOlder && (
+              <div className="flex justify-center">
+                <button
+                  type="button"
+                  onClick={loadOlder}
+                  disabled={loadingOlder}
+                  className="text-xs text-slate-500 hover:text-slate-700 disabled:opacity-50"
+                >
+                  {loadingOlder ? 'Loading…' : 'Load earlier messages'}
+                </button>
+              </div>
+            )}
+            {messages.filter((m) => !isExpired(m)).map((m) => {
+              if (m.message_type === 'system') {
+                return (
+                  <div key={m.id} className="flex justify-center">
+                    <span className="rounded-full bg-slate-100 px-3 py-1 text-xs text-slate-500">
+                      {m.sender_id === user?.id ? 'You' : displayName}: {m.content}
+                    </span>
+                  </div>
+                )
+              }
               const mine = m.sender_id === user?.id
               const isVoice = m.message_type === 'voice' && m.media_url
+              const isImage = m.message_type === 'image' && m.media_url
+              const quoted = m.reply_to_message_id ? messages.find((x) => x.id === m.reply_to_message_id) : null
               return (
                 <div key={m.id} className={`flex items-end gap-2 ${mine ? 'flex-row-reverse' : 'flex-row'}`}>
                   {!mine && (
@@ -765,20 +1129,120 @@ export default function Chat() {
                         ? 'bg-rose-500 text-white rounded-br-sm'
                         : 'bg-white border border-slate-200 text-slate-800 rounded-bl-sm shadow-sm'
                     }`}>
-                      {isVoice ? (
+                      {m.reply_to_message_id && !m.deleted_at && (
+                        <div className={`mb-1.5 border-l-2 pl-2 text-xs truncate ${
+                          mine ? 'border-rose-200 text-rose-100' : 'border-slate-300 text-slate-500'
+                        }`}>
+                          {!quoted
+                            ? 'Original message'
+                            : quoted.deleted_at
+                              ? 'Message unsent'
+                              : quoted.content}
+                        </div>
+                      )}
+                      {m.deleted_at ? (
+                        <p className={`text-sm italic ${mine ? 'text-rose-100' : 'text-slate-400'}`}>Message unsent</p>
+                      ) : editing?.id === m.id ? (
+                        <form onSubmit={saveEdit} className="flex flex-col gap-1.5">
+                          <textarea
+                            value={editing.content}
+                            onChange={(e) => setEditing({ ...editing, content: e.target.value })}
+                            maxLength={2000}
+                            rows={2}
+                            className="text-sm text-slate-800 rounded-lg px-2 py-1 min-w-[200px] focus:outline-none"
+                          />
+                          <div className="flex justify-end gap-2 text-xs">
+                            <button type="button" onClick={() => setEditing(null)} className="text-rose-100 hover:text-white">
+                              Cancel
+                            </button>
+                            <button type="submit" className="font-semibold hover:underline">
+                              Save
+                            </button>
+                          </div>
+                        </form>
+                      ) : isImage ? (
+                        <ChatImage src={m.media_url} alt={m.content} />
+                      ) : isVoice ? (
                         <div className="flex items-center gap-2 py-0.5">
                           <svg className={`w-4 h-4 shrink-0 ${mine ? 'text-rose-200' : 'text-rose-500'}`} fill="currentColor" viewBox="0 0 24 24">
                             <path d="M12 1a3 3 0 00-3 3v8a3 3 0 006 0V4a3 3 0 00-3-3z"/>
                             <path d="M19 10v2a7 7 0 01-14 0v-2H3v2a9 9 0 008 8.94V23h2v-2.06A9 9 0 0021 12v-2h-2z"/>
                           </svg>
-                          <audio controls preload="none" src={m.media_url} className="h-8 max-w-[180px]" style={{ filter: mine ? 'invert(1) brightness(1.8)' : 'none' }} />
+                          <div className="flex flex-col gap-1">
+                            <audio controls preload="none" src={m.media_url} className="h-8 max-w-[180px]" style={{ filter: mine ? 'invert(1) brightness(1.8)' : 'none' }} />
+                            <VoiceMeta message={m} mine={mine} />
+                          </div>
                         </div>
                       ) : (
                         <p className="text-sm whitespace-pre-wrap leading-relaxed">{m.content}</p>
                       )}
                     </div>
+                    {m.reactions?.length > 0 && (
+                      <div className={`flex flex-wrap gap-1 px-1 ${mine ? 'justify-end' : ''}`}>
+                        {m.reactions.map((g) => (
+                          <button
+                            key={g.emoji}
+                            type="button"
+                            onClick={() => react(m.id, g.emoji)}
+                            className={`text-xs rounded-full px-1.5 py-0.5 border ${
+                              g.user_ids.includes(user?.id) ? 'border-rose-300 bg-rose-50' : 'border-slate-200 bg-white'
+                            }`}
+                          >
+                            {g.emoji} {g.user_ids.length > 1 ? g.user_ids.length : ''}
+                          </button>
+                        ))}
+                      </div>
+                    )}
+                    {reactingId === m.id && (
+                      <div className="flex gap-1 px-1">
+                        {QUICK_REACTIONS.map((emoji) => (
+                          <button key={emoji} type="button" onClick={() => react(m.id, emoji)} className="text-base hover:scale-110 transition">
+                            {emoji}
+                          </button>
+                        ))}
+                      </div>
+                    )}
                     <div className={`flex items-center gap-1 px-1 ${mine ? 'flex-row-reverse' : ''}`}>
                       <span className="text-[10px] text-slate-400">{formatDate(m.created_at)}</span>
+                      {!m.deleted_at && (
+                        <>
+                          <button
+                            type="button"
+                            onClick={() => setReplyTo(m)}
+                            className="text-[10px] text-slate-400 hover:text-slate-600"
+                          >
+                            Reply
+                          </button>
+                          <button
+                            type="button"
+                            onClick={() => setReactingId(reactingId === m.id ? null : m.id)}
+                            className="text-[10px] text-slate-400 hover:text-slate-600"
+                          >
+                            React
+                          </button>
+                        </>
+                      )}
+                      {m.edited_at && !m.deleted_at && <span className="text-[10px] text-slate-400">edited</span>}
+                      {canChange(m, user?.id) && editing?.id !== m.id && (
+                        <>
+                          {m.message_type === 'text' && (
+                            <button
+                              type="button"
+                              onClick={() => setEditing({ id: m.id, content: m.content })}
+                              className="text-[10px] text-slate-400 hover:text-slate-600"
+                            >
+                              Edit
+                            </button>
+                          )}
+                          <button
+                            type="button"
+                            onClick={() => unsend(m.id)}
+                            className="text-[10px] text-slate-400 hover:text-slate-600"
+                          >
+                            Unsend
+                          </button>
+                        </>
+                      )}
                       {mine && (
                         <span className={`text-[10px] ${m.is_read ? 'text-rose-400' : 'text-slate-400'}`}>
                           {m.is_read ? '✓✓' : '✓'}
@@ -813,36 +1277,68 @@ export default function Chat() {
             </button>
           </div>
         ) : (
-          <form onSubmit={send} className="flex items-end gap-2">
-            <button
-              type="button"
-              onClick={startVoiceRecording}
-              title="Record voice message"
-              className="w-10 h-10 rounded-full flex items-center justify-center border border-slate-200 text-slate-500 hover:border-rose-300 hover:text-rose-500 hover:bg-rose-50 transition shrink-0"
-            >
-              <svg className="w-4 h-4" fill="currentColor" viewBox="0 0 24 24">
-                <path d="M12 1a3 3 0 00-3 3v8a3 3 0 006 0V4a3 3 0 00-3-3z"/>
-                <path d="M19 10v2a7 7 0 01-14 0v-2H3v2a9 9 0 008 8.94V23h2v-2.06A9 9 0 0021 12v-2h-2z"/>
-              </svg>
-            </button>
-            <input
-              value={input}
-              onChange={(e) => setInput(e.target.value)}
-              maxLength={2000}
-              placeholder={`Message ${profile?.first_name ?? ''}…`}
-              className="flex-1 bg-slate-50 border border-slate-200 rounded-2xl px-4 py-2.5 text-sm text-slate-800 placeholder-slate-400 focus:outline-none focus:ring-2 focus:ring-rose-400 focus:border-transparent transition resize-none"
-            />
-            <button
-              type="submit"
-              disabled={!input.trim()}
-              className="w-10 h-10 rounded-full flex items-center justify-center bg-rose-500 text-white hover:bg-rose-600 disabled:opacity-40 disabled:cursor-not-allowed transition shrink-0"
-              title="Send"
-            >
-              <svg className="w-4 h-4 -translate-x-px" fill="none" stroke="currentColor" strokeWidth="2" viewBox="0 0 24 24">
-                <path strokeLinecap="round" strokeLinejoin="round" d="M12 19l9 2-9-18-9 18 9-2zm0 0v-8" />
-              </svg>
-            </button>
-          </form>
+          <>
+            {replyTo && (
+              <div className="flex items-center gap-2 mb-2 px-3 py-1.5 bg-slate-50 border-l-2 border-rose-400 rounded text-xs text-slate-600">
+                <span className="flex-1 truncate">
+                  Replying to {replyTo.sender_id === user?.id ? 'yourself' : profile?.first_name}:{' '}
+                  {replyTo.content}
+                </span>
+                <button type="button" onClick={() => setReplyTo(null)} className="text-slate-400 hover:text-slate-600">
+                  ✕
+                </button>
+              </div>
+            )}
+            <form onSubmit={send} className="flex items-end gap-2">
+              <button
+                type="button"
+                onClick={startVoiceRecording}
+                title="Record voice message"
+                className="w-10 h-10 rounded-full flex items-center justify-center border border-slate-200 text-slate-500 hover:border-rose-300 hover:text-rose-500 hover:bg-rose-50 transition shrink-0"
+              >
+                <svg className="w-4 h-4" fill="currentColor" viewBox="0 0 24 24">
+                  <path d="M12 1a3 3 0 00-3 3v8a3 3 0 006 0V4a3 3 0 00-3-3z"/>
+                  <path d="M19 10v2a7 7 0 01-14 0v-2H3v2a9 9 0 008 8.94V23h2v-2.06A9 9 0 0021 12v-2h-2z"/>
+                </svg>
+              </button>
+              <button
+                type="button"
+                onClick={() => imageInputRef.current?.click()}
+                title="Send a photo or GIF"
+                className="w-10 h-10 rounded-full flex items-center justify-center border border-slate-200 text-slate-500 hover:border-rose-300 hover:text-rose-500 hover:bg-rose-50 transition shrink-0"
+              >
+                <svg className="w-4 h-4" fill="none" stroke="currentColor" strokeWidth="2" viewBox="0 0 24 24">
+                  <rect x="3" y="3" width="18" height="18" rx="2" />
+                  <circle cx="8.5" cy="8.5" r="1.5" />
+                  <path strokeLinecap="round" strokeLinejoin="round" d="M21 15l-5-5L5 21" />
+                </svg>
+              </button>
+              <input
+                ref={imageInputRef}
+                type="file"
+                accept="image/jpeg,image/png,image/webp,image/gif"
+                onChange={sendImage}
+                className="hidden"
+              />
+              <input
+                value={input}
+                onChange={handleInputChange}
+                maxLength={2000}
+                placeholder={`Message ${profile?.first_name ?? ''}…`}
+                className="flex-1 bg-slate-50 border border-slate-200 rounded-2xl px-4 py-2.5 text-sm text-slate-800 placeholder-slate-400 focus:outline-none focus:ring-2 focus:ring-rose-400 focus:border-transparent transition resize-none"
+              />
+              <button
+                type="submit"
+                disabled={!input.trim()}
+                className="w-10 h-10 rounded-full flex items-center justify-center bg-rose-500 text-white hover:bg-rose-600 disabled:opacity-40 disabled:cursor-not-allowed transition shrink-0"
+                title="Send"
+              >
+                <svg className="w-4 h-4 -translate-x-px" fill="none" stroke="currentColor" strokeWidth="2" viewBox="0 0 24 24">
+                  <path strokeLinecap="round" strokeLinejoin="round" d="M12 19l9 2-9-18-9 18 9-2zm0 0v-8" />
+                </svg>
+              </button>
+            </form>
+          </>
         )}
       </div>
 
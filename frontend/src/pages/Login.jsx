@@ -7,6 +7,8 @@ export default function Login() {
   const [searchParams, setSearchParams] = useSearchParams()
   const [username, setUsername] = useState('')
   const [password, setPassword] = useState('')
+  const [mfaToken, setMfaToken] = useState('')
+  const [code, setCode] = useState('')
   const [error, setError] = useState('')
   const [info, setInfo] = useState('')
   const [loading, setLoading] = useState(false)
@@ -20,13 +22,23 @@ export default function Login() {
     const verified = searchParams.get('verified')
     const err = searchParams.get('error')
     const already = searchParams.get('already')
+    const emailChangeCancelled = searchParams.get('email_change_cancelled')
+    const mfa = searchParams.get('mfa_token')
     if (u) setUsername(decodeURIComponent(u))
+    if (mfa) {
+      setMfaToken(mfa)
+      setInfo('Enter the code from your authenticator app or a recovery code.')
+    }
     if (verified === '1') setInfo('Email verified! Enter your password to sign in.')
     if (err === 'token_required') setError('Verification link is invalid or expired.')
     else if (err === 'verify_failed') setError('Verification failed. Please try again.')
+    else if (err === 'magic_link_invalid') setError('This sign-in link is invalid, expired or already used.')
+    else if (err === 'email_change_expired') setError('This email change link is invalid or expired.')
+    else if (err === 'account_deleted') setError('This account has been deleted.')
     else if (err === 'internal') setError('Something went wrong. Please try again.')
     if (already === '1') setInfo('Your email is already verified. Sign in below.')
-    if (u || verified || err || already) {
+    if (emailChangeCancelled === '1') setInfo('Email change cancelled and all sessions signed out. Sign in and change your password.')
+    if (u || verified || err || already || emailChangeCancelled || mfa) {
       setSearchParams({}, { replace: true })
     }
   }, [searchParams, setSearchParams])
@@ -36,10 +48,21 @@ export default function Login() {
     setError('')
     setLoading(true)
     try {
-      const { token, user } = await auth.login({ username, password })
-      login(token, user)
+      const res = mfaToken
+        ? await auth.verifyMfa({ mfa_token: mfaToken, code })
+        : await auth.login({ username, password })
+      if (res.mfa_required) {
+        setMfaToken(res.mfa_token)
+        setInfo('Enter the code from your authenticator app or a recovery code.')
+        return
+      }
+      login(res.token, res.user, res.refresh_token)
       navigate(from, { replace: true })
     } catch (err) {
+      if (mfaToken && err.message?.includes('expired')) {
+        setMfaToken('')
+        setCode('')
+      }
       setError(err.message || 'Login failed')
     } finally {
       setLoading(false)
@@ -61,46 +84,67 @@ export default function Login() {
               {error}
             </div>
           )}
-          <div>
-            <label className="block text-sm font-medium text-slate-700 mb-1">Username</label>
-            <input
-              type="text"
-              value={username}
-              onChange={(e) => setUsername(e.target.value)}
-              className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
-              placeholder="your_username"
-              required
-              autoComplete="username"
-            />
-          </div>
-          <div>
-            <label className="block text-sm font-medium text-slate-700 mb-1">Password</label>
-            <input
-              type="password"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
-              placeholder="••••••••"
-              required
-              minLength={8}
-              maxLength={72}
-              autoComplete="current-password"
-            />
-            <p className="text-xs text-slate-500 mt-1">
-              8–72 characters. Avoid common passwords (e.g. password123, qwerty).
-            </p>
-            <div className="mt-2 text-right">
-              <Link to="/forgot-password" className="text-sm text-rose-600 hover:underline">
-                Forgot password?
-              </Link>
+          {mfaToken ? (
+            <div>
+              <label className="block text-sm font-medium text-slate-700 mb-1">Two-factor code</label>
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
+                placeholder="123456"
+                required
+                autoFocus
+                autoComplete="one-time-code"
+              />
             </div>
-          </div>
+          ) : (
+            <>
+              <div>
+                <label className="block text-sm font-medium text-slate-700 mb-1">Username</label>
+                <input
+                  type="text"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
+                  placeholder="your_username"
+                  required
+                  autoComplete="username"
+                />
+              </div>
+              <div>
+                <label className="block text-sm font-medium text-slate-700 mb-1">Password</label>
+                <input
+                  type="password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
+                  placeholder="••••••••"
+                  required
+                  minLength={8}
+                  maxLength={72}
+                  autoComplete="current-password"
+                />
+                <p className="text-xs text-slate-500 mt-1">
+                  8–72 characters. Avoid common passwords (e.g. password123, qwerty).
+                </p>
+                <div className="mt-2 flex justify-between">
+                  <Link to="/magic-link" className="text-sm text-rose-600 hover:underline">
+                    Email me a sign-in link
+                  </Link>
+                  <Link to="/forgot-password" className="text-sm text-rose-600 hover:underline">
+                    Forgot password?
+                  </Link>
+                </div>
+              </div>
+            </>
+          )}
           <button
             type="submit"
             disabled={loading}
             className="w-full py-3 bg-rose-500 text-white font-medium rounded-lg hover:bg-rose-600 disabled:opacity-50 transition"
           >
-            {loading ? 'Signing in...' : 'Sign in'}
+            {loading ? 'Signing in...' : mfaToken ? 'Verify' : 'Sign in'}
           </button>
         </form>
         <p className="mt-6 text-center text-slate-600 text-sm">
@@ -0,0 +1,72 @@
+import { useState } from 'react'
+import { Link } from 'react-router-dom'
+import { auth } from '../api/client'
+
+export default function MagicLink() {
+  const [email, setEmail] = useState('')
+  const [loading, setLoading] = useState(false)
+  const [error, setError] = useState('')
+  const [message, setMessage] = useState('')
+
+  const handleSubmit = async (e) => {
+    e.preventDefault()
+    setError('')
+    setMessage('')
+    setLoading(true)
+    try {
+      const res = await auth.requestMagicLink({ email })
+      setMessage(res.message || 'If this email exists, a sign-in link was sent.')
+    } catch (err) {
+      setError(err.message || 'Failed to request sign-in link')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="max-w-md mx-auto w-full px-2 sm:px-0">
+      <div className="bg-white rounded-2xl shadow-lg p-8 border border-slate-100">
+        <h1 className="text-2xl font-bold text-slate-800 mb-6">Sign in with email</h1>
+        <form onSubmit={handleSubmit} className="space-y-4">
+          {message && (
+            <div className="bg-emerald-50 text-emerald-700 px-4 py-3 rounded-lg text-sm">
+              {message}
+            </div>
+          )}
+          {error && (
+            <div className="bg-rose-50 text-rose-700 px-4 py-3 rounded-lg text-sm">
+              {error}
+            </div>
+          )}
+          <p className="text-sm text-slate-600">
+            We will email you a link that signs you in without a password. It works once and expires in 10 minutes.
+          </p>
+          <div>
+            <label className="block text-sm font-medium text-slate-700 mb-1">Email</label>
+            <input
+              type="email"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none transition"
+              required
+              autoComplete="email"
+            />
+          </div>
+          <button
+            type="submit"
+            disabled={loading}
+            className="w-full py-3 bg-rose-500 text-white font-medium rounded-lg hover:bg-rose-600 disabled:opacity-50 transition"
+          >
+            {loading ? 'Sending...' : 'Email me a sign-in link'}
+          </button>
+        </form>
+        <p className="mt-6 text-center text-slate-600 text-sm">
+          Back to{' '}
+          <Link to="/login" className="text-rose-600 font-medium hover:underline">
+            Sign in
+          </Link>
+        </p>
+      </div>
+    </div>
+  )
+}
@@ -1,6 +1,8 @@
 import { useEffect, useState } from 'react'
 import { Link, useSearchParams } from 'react-router-dom'
 import { matches, users } from '../api/client'
+import { useNotifications } from '../context/NotificationsContext'
+import MessageSearch from '../components/MessageSearch'
 
 const PAGE_SIZE = 24
 
@@ -15,6 +17,7 @@ export default function Matches() {
   const [blockingId, setBlockingId] = useState(null)
 
   const [flashMessage, setFlashMessage] = useState(null)
+  const { presenceByUser } = useNotifications()
 
   useEffect(() => {
     const verified = searchParams.get('verified')
@@ -92,6 +95,7 @@ export default function Matches() {
           Verification link is invalid or expired.
         </div>
       )}
+      <MessageSearch />
       {error && <p className="text-rose-600 mb-4">{error}</p>}
       {items.length === 0 ? (
         <p className="text-slate-500">No matches yet.</p>
@@ -114,7 +118,15 @@ export default function Matches() {
                 )}
                 <div className="absolute inset-0 bg-gradient-to-t from-black/75 via-black/10 to-transparent" />
                 <div className="absolute bottom-0 inset-x-0 p-4 text-white">
-                  <p className="font-bold text-lg leading-tight truncate drop-shadow">{u.first_name} {u.last_name}</p>
+                  <p className="font-bold text-lg leading-tight truncate drop-shadow flex items-center gap-1.5">
+                    {presenceByUser[u.id]?.status === 'online' && (
+                      <span className="w-2.5 h-2.5 rounded-full bg-emerald-400 shrink-0" title="Online" />
+                    )}
+                    {presenceByUser[u.id]?.status === 'away' && (
+                      <span className="w-2.5 h-2.5 rounded-full bg-amber-400 shrink-0" title="Away" />
+                    )}
+                    <span className="truncate">{u.first_name} {u.last_name}</span>
+                  </p>
                   <p className="text-xs text-white/70 mb-3">@{u.username}</p>
                   <div className="flex flex-wrap gap-2">
                     <Link to={`/users/${u.id}`} className="px-3 py-1.5 rounded-full bg-white/20 backdrop-blur-sm text-xs text-white hover:bg-white/30 transition">
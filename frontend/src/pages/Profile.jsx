@@ -1,4 +1,5 @@
 import { useState, useEffect, useRef } from 'react'
+import { useSearchParams } from 'react-router-dom'
 import { auth, photos, profile } from '../api/client'
 import { useAuth } from '../context/AuthContext'
 import CityInput from '../components/CityInput'
@@ -16,7 +17,8 @@ const RELATIONSHIP_GOALS = [
 ]
 
 export default function Profile() {
-  const { updateUser } = useAuth()
+  const { updateUser, logout } = useAuth()
+  const [searchParams, setSearchParams] = useSearchParams()
   const cityInputRef = useRef(null)
   const [account, setAccount] = useState({
     username: '',
@@ -50,6 +52,10 @@ export default function Profile() {
   const [error, setError] = useState('')
   const [tagsMessage, setTagsMessage] = useState('')
   const [tagsError, setTagsError] = useState('')
+  const [exporting, setExporting] = useState(false)
+  const [deletePassword, setDeletePassword] = useState('')
+  const [deleting, setDeleting] = useState(false)
+  const [pendingEmail, setPendingEmail] = useState('')
   const [passwordForm, setPasswordForm] = useState({
     current_password: '',
     new_password: '',
@@ -65,6 +71,7 @@ export default function Profile() {
           first_name: me.first_name || '',
           last_name: me.last_name || '',
         })
+        setPendingEmail(me.pending_email || '')
         setData({
           bio: p.bio || '',
           gender: p.gender || '',
@@ -83,6 +90,18 @@ export default function Profile() {
       .finally(() => setLoading(false))
   }, [])
 
+  useEffect(() => {
+    const changed = searchParams.get('email_changed')
+    const err = searchParams.get('error')
+    if (changed === '1') setMessage('Your email address has been changed.')
+    if (err === 'email_change_expired') setError('This email change link is invalid or expired.')
+    else if (err === 'email_taken') setError('That email address is already used by another account.')
+    else if (err) setError('Email change failed. Please try again.')
+    if (changed || err) {
+      setSearchParams({}, { replace: true })
+    }
+  }, [searchParams, setSearchParams])
+
   const handleChange = (e) => {
     const { name, value } = e.target
     setData((d) => ({ ...d, [name]: value }))
@@ -99,9 +118,14 @@ export default function Profile() {
     setMessage('')
     setSavingAccount(true)
     try {
-      await auth.updateMe(account)
+      const res = await auth.updateMe(account)
       updateUser({ username: account.username })
-      setMessage('Account updated')
+      if (res?.pending_email) {
+        setPendingEmail(res.pending_email)
+        setMessage(`Account updated. Confirm your new email via the link we sent to ${res.pending_email}.`)
+      } else {
+        setMessage('Account updated')
+      }
       setSavedAccount(true)
       setTimeout(() => setSavedAccount(false), 2000)
     } catch (err) {
@@ -140,6 +164,35 @@ export default function Profile() {
     }
   }
 
+  const handleExport = async () => {
+    setError('')
+    setMessage('')
+    setExporting(true)
+    try {
+      await auth.requestExport()
+      setMessage('Export requested. We will email you a download link when it is ready.')
+    } catch (err) {
+      setError(err.message || 'Export request failed')
+    } finally {
+      setExporting(false)
+    }
+  }
+
+  const handleDeleteAccount = async (e) => {
+    e.preventDefault()
+    setError('')
+    setMessage('')
+    if (!window.confirm('Delete your account? You can restore it by signing in within 14 days.')) return
+    setDeleting(true)
+    try {
+      await auth.deleteMe(deletePassword)
+      logout()
+    } catch (err) {
+      setError(err.message || 'Account deletion failed')
+      setDeleting(false)
+    }
+  }
+
   const handleSubmit = async (e) => {
     e.preventDefault()
     setError('')
@@ -356,6 +409,9 @@ export default function Profile() {
                 <label className="block text-sm font-medium text-slate-700 mb-1">Email</label>
                 <input type="email" name="email" value={account.email} onChange={handleAccountChange}
                   className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none" />
+                {pendingEmail && (
+                  <p className="mt-1 text-xs text-slate-500">Waiting for confirmation of {pendingEmail}</p>
+                )}
               </div>
               <div>
                 <label className="block text-sm font-medium text-slate-700 mb-1">First name</label>
@@ -417,6 +473,45 @@ export default function Profile() {
             </button>
           </form>
 
+          <div className="p-5 bg-white rounded-xl border border-slate-200 space-y-3">
+            <p className="text-sm font-semibold text-slate-700">Your data</p>
+            <p className="text-sm text-slate-600">
+              Get a ZIP archive with your account, profile, activity, messages, photos and voice messages.
+            </p>
+            <button
+              type="button"
+              onClick={handleExport}
+              disabled={exporting}
+              className="py-2 px-4 bg-slate-800 text-white font-medium rounded-lg hover:bg-slate-900 disabled:opacity-50 transition"
+            >
+              {exporting ? 'Requesting...' : 'Export my data'}
+            </button>
+          </div>
+
+          <form onSubmit={handleDeleteAccount} className="p-5 bg-white rounded-xl border border-rose-200 space-y-4">
+            <p className="text-sm font-semibold text-rose-700">Delete account</p>
+            <p className="text-sm text-slate-600">
+              Your profile is hidden right away and permanently removed after 14 days. Signing in before then restores it.
+            </p>
+            <div className="sm:w-1/3">
+              <label className="block text-sm font-medium text-slate-700 mb-1">Current password</label>
+              <input
+                type="password"
+                value={deletePassword}
+                onChange={(e) => setDeletePassword(e.target.value)}
+                required
+                className="w-full px-4 py-2 rounded-lg border border-slate-200 focus:ring-2 focus:ring-rose-500 focus:border-transparent outline-none"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={deleting}
+              className="py-2 px-4 bg-rose-600 text-white font-medium rounded-lg hover:bg-rose-700 disabled:opacity-50 transition"
+            >
+              {deleting ? 'Deleting...' : 'Delete account'}
+            </button>
+          </form>
+
           <div className="p-5 bg-white rounded-xl border border-slate-200 space-y-3">
             <p className="text-sm font-semibold text-slate-700">Interests / Tags</p>
             <input type="text" value={tagsInput} onChange={(e) => setTagsInput(e.target.value)}